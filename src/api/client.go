@@ -2,35 +2,204 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gophergala/gopher_talkie/src/common"
 	"github.com/gophergala/gopher_talkie/src/crypto"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeout      = 30 * time.Second
+	DefaultRetries      = 3
+	DefaultRetryBackoff = 500 * time.Millisecond
+
+	// never wait longer than this between two attempts
+	maxRetryBackoff = 30 * time.Second
+
+	// error bodies are read up to this size
+	maxErrorBodySize = 64 << 10
 )
 
 var (
 	ErrInvalidRequest        = errors.New("invalid request")
 	ErrUnexpectedContentType = errors.New("unexpected content type")
+	ErrUnauthorized          = errors.New("not authorized")
+	ErrMessageTooLarge       = errors.New("message too large")
+	ErrMailboxFull           = errors.New("recipient mailbox is full")
+	ErrQuotaExceeded         = errors.New("recipient mailbox quota exceeded")
+	ErrNotFound              = errors.New("not found")
+	ErrRateLimited           = errors.New("too many requests, try again later")
+	ErrSenderBlocked         = errors.New("recipient does not accept messages from you")
+	ErrServerUnavailable     = errors.New("server unavailable, try again later")
+	ErrUploadOffset          = errors.New("upload is not where it was expected to be")
+	ErrChecksumMismatch      = errors.New("upload was damaged on the way")
+	ErrTooManyUploads        = errors.New("too many unfinished uploads, try again later")
+	ErrKeyNotFound           = errors.New("key not found")
 )
 
+// codeErrors maps the error codes sent by the server to our errors.
+var codeErrors = map[string]error{
+	common.ErrCodeForbidden:       ErrUnauthorized,
+	common.ErrCodeNotFound:        ErrNotFound,
+	common.ErrCodeMessageTooLarge: ErrMessageTooLarge,
+	common.ErrCodeMailboxFull:     ErrMailboxFull,
+	common.ErrCodeQuotaExceeded:   ErrQuotaExceeded,
+	common.ErrCodeRateLimited:     ErrRateLimited,
+	common.ErrCodeSenderBlocked:   ErrSenderBlocked,
+	common.ErrCodeKeyNotFound:     ErrKeyNotFound,
+
+	common.ErrCodeUploadOffset:     ErrUploadOffset,
+	common.ErrCodeChecksumMismatch: ErrChecksumMismatch,
+	common.ErrCodeTooManyUploads:   ErrTooManyUploads,
+}
+
+// statusErrors maps HTTP status codes to our errors, for responses without
+// an error code such as those of proxies or older servers.
+var statusErrors = map[int]error{
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrUnauthorized,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusRequestEntityTooLarge: ErrMessageTooLarge,
+	http.StatusTooManyRequests:       ErrRateLimited,
+	http.StatusInsufficientStorage:   ErrQuotaExceeded,
+	http.StatusBadGateway:            ErrServerUnavailable,
+	http.StatusServiceUnavailable:    ErrServerUnavailable,
+	http.StatusGatewayTimeout:        ErrServerUnavailable,
+}
+
+// statuses worth another attempt, the server or a proxy may recover
+var retryStatuses = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// serverError turns a failed response into an error, preferring the typed
+// error for a known code.
+func serverError(code, msg string) error {
+	if err, ok := codeErrors[code]; ok {
+		return err
+	}
+	return errors.New(msg)
+}
+
+type ClientOptions struct {
+	// HTTPClient is used as is when set, CAFile and PinnedCert are ignored then.
+	HTTPClient *http.Client
+	// CAFile is a PEM bundle of certificate authorities to trust instead of
+	// the system ones.
+	CAFile string
+	// PinnedCert is the SHA-256 fingerprint of the server certificate. When
+	// set the server must present exactly this certificate, which also works
+	// for self-signed certificates.
+	PinnedCert string
+
+	Timeout      time.Duration // per attempt. default: DefaultTimeout
+	Retries      int           // attempts after the first one. default: DefaultRetries, -1 for none
+	RetryBackoff time.Duration // first wait between attempts, doubled each time. default: DefaultRetryBackoff
+}
+
 type Client struct {
+	scheme     string
 	serverAddr string
+	httpClient *http.Client
+	engine     crypto.Engine
+
+	timeout      time.Duration
+	retries      int
+	retryBackoff time.Duration
 }
 
+// NewClient talks to the server at addr, a "https://" or "http://" URL. A
+// bare "host:port" means https: plain HTTP has to be asked for.
 func NewClient(addr string) *Client {
-	return &Client{
-		serverAddr: addr,
+	c, _ := NewClientWithOptions(addr, nil)
+	return c
+}
+
+// NewClientWithOptions is like NewClient but configured by options.
+func NewClientWithOptions(addr string, options *ClientOptions) (*Client, error) {
+	scheme, host := splitScheme(addr)
+	if options == nil {
+		options = &ClientOptions{}
+	}
+	if scheme == "" {
+		scheme = "https"
 	}
+
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		config, err := options.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: config,
+			},
+		}
+	}
+
+	c := &Client{
+		scheme:       scheme,
+		serverAddr:   host,
+		httpClient:   httpClient,
+		engine:       crypto.NewGPGEngine(),
+		timeout:      options.Timeout,
+		retries:      options.Retries,
+		retryBackoff: options.RetryBackoff,
+	}
+	if c.timeout == 0 {
+		c.timeout = DefaultTimeout
+	}
+	if c.retries == 0 {
+		c.retries = DefaultRetries
+	} else if c.retries < 0 {
+		c.retries = 0
+	}
+	if c.retryBackoff == 0 {
+		c.retryBackoff = DefaultRetryBackoff
+	}
+	return c, nil
+}
+
+// SetEngine replaces the gpg engine used to sign requests and decrypt
+// listings.
+func (c *Client) SetEngine(engine crypto.Engine) {
+	c.engine = engine
+}
+
+// SetHTTPClient replaces the http.Client requests are sent with.
+func (c *Client) SetHTTPClient(httpClient *http.Client) {
+	c.httpClient = httpClient
+}
+
+// ErrorResponse is the body of every failed /v1 request.
+type ErrorResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+	Code    string `json:"code"`
 }
 
 type RegisterResponse struct {
 	Success bool         `json:"success"`
 	Data    *common.User `json:"data,omitempty"`
 	Error   string       `json:"error,omitempty"`
+	Code    string       `json:"code,omitempty"`
 }
 
 func (c *Client) GetURL(p string, query *url.Values) string {
@@ -38,42 +207,267 @@ func (c *Client) GetURL(p string, query *url.Values) string {
 		c.serverAddr = "127.0.0.1:3333"
 	}
 	if query != nil && len(query.Encode()) > 0 {
-		return fmt.Sprintf("http://%s/%s?%s", c.serverAddr, p, query.Encode())
+		return fmt.Sprintf("%s://%s/%s?%s", c.scheme, c.serverAddr, p, query.Encode())
 	} else {
-		return fmt.Sprintf("http://%s/%s", c.serverAddr, p)
+		return fmt.Sprintf("%s://%s/%s", c.scheme, c.serverAddr, p)
+	}
+}
+
+// request describes a call to the server. Only idempotent requests are
+// retried.
+type request struct {
+	method      string
+	path        string
+	query       *url.Values
+	contentType string
+	header      http.Header
+	body        []byte
+	idempotent  bool
+	retried     bool // set by do once it sent the request more than once
+}
+
+// do sends req, retrying with backoff as configured. On success the response
+// body holds all of the response and the caller doesn't need to close it.
+// Failed responses are returned as errors, typed where possible.
+func (c *Client) do(ctx context.Context, req *request) (*http.Response, []byte, error) {
+	attempts := 1
+	if req.idempotent {
+		attempts += c.retries
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := c.wait(ctx, attempt, lastErr); err != nil {
+				return nil, nil, lastErr
+			}
+			req.retried = true
+		}
+
+		res, body, err := c.attempt(ctx, req)
+		if err == nil {
+			return res, body, nil
+		}
+		lastErr = err
+		if !retryable(ctx, err) {
+			break
+		}
 	}
+	return nil, nil, lastErr
 }
 
-func (c *Client) Register(user *common.User) error {
+// attempt sends req once, within the per attempt timeout.
+func (c *Client) attempt(ctx context.Context, req *request) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	httpReq, err := c.newHTTPRequest(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, nil, responseError(res)
+	}
+	d, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, d, nil
+}
+
+func (c *Client) newHTTPRequest(ctx context.Context, req *request) (*http.Request, error) {
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequest(req.method, c.GetURL(req.path, req.query), body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	for k, v := range req.header {
+		httpReq.Header[k] = v
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	return httpReq, nil
+}
+
+// statusError is a failed response we should try again.
+type statusError struct {
+	err        error
+	status     int
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+// responseError reads the error out of a failed response.
+func responseError(res *http.Response) error {
+	d, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+
+	var err error
+	var e ErrorResponse
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") && json.Unmarshal(d, &e) == nil && e.Error != "" {
+		err = serverError(e.Code, e.Error)
+	} else if typed, ok := statusErrors[res.StatusCode]; ok {
+		err = typed
+	} else if msg := strings.TrimSpace(string(d)); msg != "" && len(msg) < 200 {
+		err = errors.New(msg)
+	} else {
+		err = fmt.Errorf("server error: %s", res.Status)
+	}
+
+	if !retryStatuses[res.StatusCode] {
+		return err
+	}
+	secs, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+	return &statusError{
+		err:        err,
+		status:     res.StatusCode,
+		retryAfter: time.Duration(secs) * time.Second,
+	}
+}
+
+// retryable reports whether a request that failed with err may succeed if
+// sent again.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return true
+	}
+	if _, ok := err.(*url.Error); !ok {
+		// not a transport error
+		return false
+	}
+
+	// a bad certificate won't get any better
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+	return !errors.As(err, &unknownAuthority) && !errors.As(err, &hostname) &&
+		!errors.As(err, &invalid) && !errors.As(err, &verification) && !errors.Is(err, ErrCertNotPinned)
+}
+
+// wait sleeps before the given attempt: exponential backoff with jitter, or
+// as long as the server asked for if that is longer.
+func (c *Client) wait(ctx context.Context, attempt int, lastErr error) error {
+	backoff := c.retryBackoff << uint(attempt-1)
+	if backoff > maxRetryBackoff || backoff <= 0 {
+		backoff = maxRetryBackoff
+	}
+	// spread clients out over the second half of the backoff
+	delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+	var se *statusError
+	if errors.As(lastErr, &se) && se.retryAfter > delay {
+		delay = se.retryAfter
+		if delay > maxRetryBackoff {
+			delay = maxRetryBackoff
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unwrap returns the typed error behind a retried response.
+func unwrap(err error) error {
+	if se, ok := err.(*statusError); ok {
+		return se.err
+	}
+	return err
+}
+
+// decodeJSON unmarshals a successful JSON response.
+func decodeJSON(res *http.Response, body []byte, v interface{}) error {
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		return ErrUnexpectedContentType
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (c *Client) Register(ctx context.Context, user *common.User) error {
 	if user == nil {
 		return ErrInvalidRequest
 	}
-	url := c.GetURL("register", nil)
 	body, err := json.Marshal(user)
 	if err != nil {
 		return err
 	}
+	return c.register(ctx, user, body)
+}
 
-	// fmt.Printf("register: %v\n", user)
-	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+// RegisterKey registers user along with its public key from the keyring,
+// so the server needn't fetch the key from a keyserver and can hand it out
+// to those who look user's email up. The request is signed with user's key.
+func (c *Client) RegisterKey(ctx context.Context, user *common.User) error {
+	if user == nil {
+		return ErrInvalidRequest
+	}
+	key, err := c.engine.ExportKey(user.Key)
 	if err != nil {
 		return err
 	}
-	if res.Header.Get("Content-Type") != "application/json" {
-		return ErrUnexpectedContentType
+	req, err := json.Marshal(&common.RegisterRequest{
+		User:      *user,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
 	}
-	d, err := ioutil.ReadAll(res.Body)
+	signed, err := c.engine.ClearSign(user.Key, bytes.NewReader(req))
 	if err != nil {
 		return err
 	}
+	body, err := json.Marshal(&common.KeyRegistration{
+		PublicKey: key,
+		Request:   string(signed),
+	})
+	if err != nil {
+		return err
+	}
+	return c.register(ctx, user, body)
+}
+
+func (c *Client) register(ctx context.Context, user *common.User, body []byte) error {
+	// registering again is harmless, so it is retried
+	res, d, err := c.do(ctx, &request{
+		method:      "POST",
+		path:        "v1/users",
+		contentType: "application/json",
+		body:        body,
+		idempotent:  true,
+	})
+	if err != nil {
+		return unwrap(err)
+	}
 
 	var reg RegisterResponse
-	if err := json.Unmarshal(d, &reg); err != nil {
+	if err := decodeJSON(res, d, &reg); err != nil {
 		return err
 	}
-
 	if !reg.Success {
-		return errors.New(reg.Error)
+		return serverError(reg.Code, reg.Error)
 	}
 
 	// success, update userID
@@ -83,40 +477,76 @@ func (c *Client) Register(user *common.User) error {
 	return nil
 }
 
+type KeysResponse struct {
+	Success bool                `json:"success"`
+	Data    []*common.PublicKey `json:"data,omitempty"`
+	Error   string              `json:"error,omitempty"`
+	Code    string              `json:"code,omitempty"`
+}
+
+// LookupKeys asks the server for the public keys of the users registered
+// with email. It fails with ErrKeyNotFound if there are none.
+func (c *Client) LookupKeys(ctx context.Context, email string) ([]*common.PublicKey, error) {
+	if email == "" {
+		return nil, ErrInvalidRequest
+	}
+	query := &url.Values{}
+	query.Set("email", email)
+	res, d, err := c.do(ctx, &request{
+		method:     "GET",
+		path:       "v1/keys",
+		query:      query,
+		idempotent: true,
+	})
+	if err != nil {
+		return nil, unwrap(err)
+	}
+
+	var s KeysResponse
+	if err := decodeJSON(res, d, &s); err != nil {
+		return nil, err
+	}
+	if !s.Success {
+		return nil, serverError(s.Code, s.Error)
+	}
+	return s.Data, nil
+}
+
 type SendResponse struct {
 	Success bool   `json:"success"`
 	Data    int64  `json:"data,omitempty"`
 	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
 }
 
-func (c *Client) Send(msg *common.Message) error {
+func (c *Client) Send(ctx context.Context, msg *common.Message) error {
 	if msg == nil {
 		return ErrInvalidRequest
 	}
-	url := c.GetURL("send", nil)
-	body, err := json.Marshal(msg)
-	if err != nil {
+	if err := c.signMessage(msg); err != nil {
 		return err
 	}
-	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+	body, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
-	if res.Header.Get("Content-Type") != "application/json" {
-		return ErrUnexpectedContentType
-	}
-	d, err := ioutil.ReadAll(res.Body)
+	// not retried, the recipient would get the message twice
+	res, d, err := c.do(ctx, &request{
+		method:      "POST",
+		path:        "v1/messages",
+		contentType: "application/json",
+		body:        body,
+	})
 	if err != nil {
-		return err
+		return unwrap(err)
 	}
 
 	var s SendResponse
-	if err := json.Unmarshal(d, &s); err != nil {
+	if err := decodeJSON(res, d, &s); err != nil {
 		return err
 	}
-
 	if !s.Success {
-		return errors.New(s.Error)
+		return serverError(s.Code, s.Error)
 	}
 
 	// success, update messageID
@@ -124,61 +554,223 @@ func (c *Client) Send(msg *common.Message) error {
 	return nil
 }
 
+// signMessage attaches the SendRequest for msg, signed by its sender, so
+// that the server knows who it comes from. A sealed message names no
+// sender and goes without.
+func (c *Client) signMessage(msg *common.Message) error {
+	if msg.Sealed {
+		return nil
+	}
+	if msg.From == nil {
+		return ErrInvalidRequest
+	}
+	req := &common.SendRequest{
+		From:      msg.From.Key,
+		Group:     msg.Group,
+		SHA256:    checksum(msg.Content),
+		CreatedAt: time.Now(),
+	}
+	if msg.To != nil {
+		req.To = msg.To.Key
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	signed, err := c.engine.ClearSign(msg.From.Key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	msg.Signature = string(signed)
+	return nil
+}
+
 type MessagesResponse struct {
 	Success  bool              `json:"success"`
 	Messages []*common.Message `json:"data,omitempty"`
 	Error    string            `json:"error,omitempty"`
+	Code     string            `json:"code,omitempty"`
 }
 
-func (c *Client) GetMessages(user *common.User) ([]*common.Message, error) {
+func (c *Client) GetMessages(ctx context.Context, user *common.User) ([]*common.Message, error) {
 	if user == nil {
 		return nil, ErrInvalidRequest
 	}
 	query := &url.Values{}
 	query.Set("key", user.Key)
-	url := c.GetURL("messages", query)
-	res, err := http.Get(url)
+	res, d, err := c.do(ctx, &request{
+		method:     "GET",
+		path:       "v1/messages",
+		query:      query,
+		idempotent: true,
+	})
 	if err != nil {
-		return nil, err
+		return nil, unwrap(err)
 	}
 
 	var s MessagesResponse
 	if res.Header.Get("Content-Type") == "application/octet-stream" {
-		decryptedData, err := crypto.GPGDecrypt(user.Key, res.Body)
+		decryptedData, err := c.engine.Decrypt(user.Key, bytes.NewReader(d))
 		if err != nil {
 			return nil, err
 		}
 		if err := json.Unmarshal(decryptedData, &s); err != nil {
 			return nil, err
 		}
-	} else if res.Header.Get("Content-Type") == "application/json" {
-		d, _ := ioutil.ReadAll(res.Body)
-		if err := json.Unmarshal(d, &s); err != nil {
-			return nil, err
-		}
+	} else if err := decodeJSON(res, d, &s); err != nil {
+		return nil, err
 	}
 
 	if !s.Success {
-		return nil, errors.New(s.Error)
+		return nil, serverError(s.Code, s.Error)
 	}
 
 	// success
 	return s.Messages, nil
 }
 
-// download message content
-func (c *Client) DownloadMessage(msgID int64) ([]byte, error) {
-	query := &url.Values{}
-	query.Set("id", fmt.Sprintf("%d", msgID))
-	url := c.GetURL("m", query)
-	res, err := http.Get(url)
+type SenderRuleResponse struct {
+	Success bool               `json:"success"`
+	Data    *common.SenderRule `json:"data,omitempty"`
+	Error   string             `json:"error,omitempty"`
+	Code    string             `json:"code,omitempty"`
+}
+
+// SetSenderRule blocks or allows sender for user's mailbox, or removes the
+// rule for sender. The request is signed with user's key.
+func (c *Client) SetSenderRule(ctx context.Context, user *common.User, sender string, allow, remove bool) error {
+	if user == nil || sender == "" {
+		return ErrInvalidRequest
+	}
+	req := &common.SenderRuleRequest{
+		SenderRule: common.SenderRule{
+			Owner:  user.Key,
+			Sender: sender,
+			Allow:  allow,
+		},
+		Remove:    remove,
+		CreatedAt: time.Now(),
+	}
+	body, err := json.Marshal(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if res.Header.Get("Content-Type") != "application/octet-stream" {
-		return nil, ErrUnexpectedContentType
+	signed, err := c.engine.ClearSign(user.Key, bytes.NewReader(body))
+	if err != nil {
+		return err
 	}
 
-	// success
-	return ioutil.ReadAll(res.Body)
+	// applying a rule twice leaves the same rule
+	res, d, err := c.do(ctx, &request{
+		method:      "POST",
+		path:        "v1/rules",
+		contentType: "text/plain",
+		body:        signed,
+		idempotent:  true,
+	})
+	if err != nil {
+		return unwrap(err)
+	}
+
+	var s SenderRuleResponse
+	if err := decodeJSON(res, d, &s); err != nil {
+		return err
+	}
+	if !s.Success {
+		return serverError(s.Code, s.Error)
+	}
+	return nil
+}
+
+// DeleteMessage deletes the message with msgID from user's mailbox. The
+// request is signed with user's key.
+func (c *Client) DeleteMessage(ctx context.Context, user *common.User, msgID int64) error {
+	if user == nil {
+		return ErrInvalidRequest
+	}
+	body, err := json.Marshal(&common.DeleteRequest{
+		Key:       user.Key,
+		MessageID: msgID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	signed, err := c.engine.ClearSign(user.Key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	// deleting twice leaves the message deleted
+	req := &request{
+		method:      "DELETE",
+		path:        fmt.Sprintf("v1/messages/%d", msgID),
+		contentType: "text/plain",
+		body:        signed,
+		idempotent:  true,
+	}
+	res, d, err := c.do(ctx, req)
+	if err == ErrNotFound && req.retried {
+		// an earlier attempt deleted it, its answer got lost
+		return nil
+	}
+	if err != nil {
+		return unwrap(err)
+	}
+
+	var s ErrorResponse
+	if err := decodeJSON(res, d, &s); err != nil {
+		return err
+	}
+	if !s.Success {
+		return serverError(s.Code, s.Error)
+	}
+	return nil
+}
+
+// Acknowledge tells the server that user played the message with msgID:
+// its sender gets a receipt, and it burns if it asked for that. The request
+// is signed with user's key.
+func (c *Client) Acknowledge(ctx context.Context, user *common.User, msgID int64) error {
+	if user == nil {
+		return ErrInvalidRequest
+	}
+	body, err := json.Marshal(&common.ReceiptRequest{
+		Key:       user.Key,
+		MessageID: msgID,
+		Played:    true,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	signed, err := c.engine.ClearSign(user.Key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	// not retried, the sender would get the receipt twice
+	res, d, err := c.do(ctx, &request{
+		method:      "POST",
+		path:        fmt.Sprintf("v1/messages/%d/receipt", msgID),
+		contentType: "text/plain",
+		body:        signed,
+	})
+	if err != nil {
+		return unwrap(err)
+	}
+
+	var s ErrorResponse
+	if err := decodeJSON(res, d, &s); err != nil {
+		return err
+	}
+	if !s.Success {
+		return serverError(s.Code, s.Error)
+	}
+	return nil
+}
+
+// download message content
+func (c *Client) DownloadMessage(ctx context.Context, msgID int64) ([]byte, error) {
+	return c.Download(ctx, msgID, nil)
 }
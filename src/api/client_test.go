@@ -1,20 +1,39 @@
 package api
 
 import (
+	"context"
 	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
 	"github.com/stretchr/testify/assert"
 
+	"encoding/pem"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// passEngine signs by passing data through, for servers that don't check.
+type passEngine struct {
+	crypto.Engine
+}
+
+func (passEngine) ClearSign(uid string, src io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(src)
+}
+
 func TestNewClient(t *testing.T) {
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/v1/users", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(`{"success":true}`))
 	})
@@ -22,7 +41,9 @@ func TestNewClient(t *testing.T) {
 	u, err := url.Parse(ts.URL)
 	assert.Nil(t, err)
 
-	c := NewClient(u.Host)
+	// a bare host:port is https, plain HTTP has to be asked for
+	assert.Equal(t, fmt.Sprintf("https://%s/hello", u.Host), NewClient(u.Host).GetURL("hello", nil))
+	c := NewClient(ts.URL)
 	url := c.GetURL("hello", nil)
 	assert.Equal(t, fmt.Sprintf("%s/hello", ts.URL), url)
 
@@ -31,6 +52,285 @@ func TestNewClient(t *testing.T) {
 		Email: "tester1@example.com",
 		Key:   "123456",
 	}
-	err = c.Register(user)
+	err = c.Register(context.Background(), user)
 	assert.Nil(t, err)
 }
+
+func TestSendQuotaErrors(t *testing.T) {
+	code := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInsufficientStorage)
+		w.Write([]byte(fmt.Sprintf(`{"success":false,"error":"nope","code":"%s"}`, code)))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := NewClient(ts.URL)
+	c.SetEngine(passEngine{})
+	msg := common.NewMessage(&common.User{Key: "1"}, &common.User{Key: "2"})
+
+	code = common.ErrCodeMailboxFull
+	assert.Equal(t, ErrMailboxFull, c.Send(context.Background(), msg))
+
+	code = common.ErrCodeQuotaExceeded
+	assert.Equal(t, ErrQuotaExceeded, c.Send(context.Background(), msg))
+
+	code = common.ErrCodeMessageTooLarge
+	assert.Equal(t, ErrMessageTooLarge, c.Send(context.Background(), msg))
+
+	code = "something_else"
+	err := c.Send(context.Background(), msg)
+	assert.NotNil(t, err)
+	assert.Equal(t, "nope", err.Error())
+}
+
+func TestTLS(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success":true}`))
+	})
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	assert.Nil(t, err)
+	user := &common.User{Key: "123456"}
+	fingerprint := common.CertFingerprint(ts.Certificate().Raw)
+
+	// self-signed, so not trusted by default
+	c, err := NewClientWithOptions(u.Host, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("%s/hello", ts.URL), c.GetURL("hello", nil))
+	assert.NotNil(t, c.Register(context.Background(), user))
+
+	c, err = NewClientWithOptions(ts.URL, &ClientOptions{PinnedCert: strings.ToUpper(fingerprint)})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Register(context.Background(), user))
+
+	c, err = NewClientWithOptions(ts.URL, &ClientOptions{PinnedCert: strings.Repeat("00", 32)})
+	assert.Nil(t, err)
+	assert.NotNil(t, c.Register(context.Background(), user))
+
+	caFile := path.Join(os.TempDir(), fmt.Sprintf("talkie-ca-%d.pem", os.Getpid()))
+	defer os.Remove(caFile)
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+	assert.Nil(t, err)
+	c, err = NewClientWithOptions(u.Host, &ClientOptions{CAFile: caFile, PinnedCert: fingerprint})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Register(context.Background(), user))
+
+	pinned, err := FetchCertFingerprint(ts.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, fingerprint, pinned)
+}
+
+// faultServer answers the first failures requests to each path with fault,
+// and the rest with the handler for the path.
+type faultServer struct {
+	*httptest.Server
+	failures int32
+	fault    http.HandlerFunc
+	requests map[string]*int32
+}
+
+func newFaultServer(failures int32, fault http.HandlerFunc, handlers map[string]http.HandlerFunc) *faultServer {
+	fs := &faultServer{
+		failures: failures,
+		fault:    fault,
+		requests: make(map[string]*int32),
+	}
+	mux := http.NewServeMux()
+	for p, h := range handlers {
+		count := new(int32)
+		fs.requests[p] = count
+		h := h
+		mux.HandleFunc(p, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(count, 1) <= atomic.LoadInt32(&fs.failures) {
+				fs.fault(w, r)
+				return
+			}
+			h(w, r)
+		})
+	}
+	fs.Server = httptest.NewServer(mux)
+	return fs
+}
+
+func (fs *faultServer) count(p string) int32 {
+	return atomic.LoadInt32(fs.requests[p])
+}
+
+func (fs *faultServer) client(t *testing.T, options *ClientOptions) *Client {
+	if options.RetryBackoff == 0 {
+		options.RetryBackoff = time.Millisecond
+	}
+	c, err := NewClientWithOptions(fs.URL, options)
+	assert.Nil(t, err)
+	return c
+}
+
+func status(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}
+}
+
+func hangUp(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+var testHandlers = map[string]http.HandlerFunc{
+	"/v1/messages": func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"success":true,"data":7}`))
+			return
+		}
+		w.Write([]byte(`{"success":true,"data":[{"id":7}]}`))
+	},
+	"/v1/messages/7/content": func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("content"))
+	},
+}
+
+func TestRetries(t *testing.T) {
+	user := &common.User{Key: "1"}
+	ctx := context.Background()
+
+	for _, fault := range []http.HandlerFunc{status(http.StatusServiceUnavailable), status(http.StatusBadGateway), hangUp} {
+		fs := newFaultServer(2, fault, testHandlers)
+		c := fs.client(t, &ClientOptions{})
+
+		messages, err := c.GetMessages(ctx, user)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(messages))
+		assert.Equal(t, int32(3), fs.count("/v1/messages"))
+
+		content, err := c.DownloadMessage(ctx, 7)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("content"), content)
+		assert.Equal(t, int32(3), fs.count("/v1/messages/7/content"))
+		fs.Close()
+	}
+
+	// sending is not idempotent
+	fs := newFaultServer(1, status(http.StatusServiceUnavailable), testHandlers)
+	defer fs.Close()
+	c := fs.client(t, &ClientOptions{})
+	c.SetEngine(passEngine{})
+	msg := common.NewMessage(user, &common.User{Key: "2"})
+	assert.Equal(t, ErrServerUnavailable, c.Send(ctx, msg))
+	assert.Equal(t, int32(1), fs.count("/v1/messages"))
+	assert.Nil(t, c.Send(ctx, msg))
+	assert.Equal(t, int64(7), msg.MessageID)
+
+	// give up after the configured retries
+	fs = newFaultServer(10, status(http.StatusGatewayTimeout), testHandlers)
+	defer fs.Close()
+	c = fs.client(t, &ClientOptions{Retries: 2})
+	_, err := c.GetMessages(ctx, user)
+	assert.Equal(t, ErrServerUnavailable, err)
+	assert.Equal(t, int32(3), fs.count("/v1/messages"))
+
+	c = fs.client(t, &ClientOptions{Retries: -1})
+	_, err = c.DownloadMessage(ctx, 7)
+	assert.Equal(t, ErrServerUnavailable, err)
+	assert.Equal(t, int32(1), fs.count("/v1/messages/7/content"))
+
+	// a message gone when deleting it again was deleted by the lost attempt
+	gone := map[string]http.HandlerFunc{
+		"/v1/messages/7": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"success":false,"error":"message not found","code":"not_found"}`))
+		},
+	}
+	fs = newFaultServer(1, hangUp, gone)
+	defer fs.Close()
+	c = fs.client(t, &ClientOptions{})
+	c.SetEngine(passEngine{})
+	assert.Nil(t, c.DeleteMessage(ctx, user, 7))
+	assert.Equal(t, int32(2), fs.count("/v1/messages/7"))
+	assert.Equal(t, ErrNotFound, c.DeleteMessage(ctx, user, 7))
+}
+
+func TestTypedErrors(t *testing.T) {
+	ctx := context.Background()
+	cases := map[int]error{
+		http.StatusUnauthorized:          ErrUnauthorized,
+		http.StatusForbidden:             ErrUnauthorized,
+		http.StatusNotFound:              ErrNotFound,
+		http.StatusInsufficientStorage:   ErrQuotaExceeded,
+		http.StatusRequestEntityTooLarge: ErrMessageTooLarge,
+	}
+	for code, expected := range cases {
+		fs := newFaultServer(1, func(w http.ResponseWriter, r *http.Request) {
+			// a proxy in the way, no JSON envelope
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(code)
+			w.Write([]byte("<html>error</html>"))
+		}, testHandlers)
+		c := fs.client(t, &ClientOptions{})
+		_, err := c.DownloadMessage(ctx, 7)
+		assert.Equal(t, expected, err, "status %d", code)
+		assert.Equal(t, int32(1), fs.count("/v1/messages/7/content"))
+		fs.Close()
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	block := make(chan bool)
+	defer close(block)
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}
+	fs := newFaultServer(1, slow, testHandlers)
+	defer fs.Close()
+
+	// the slow attempt times out, the retry succeeds
+	c := fs.client(t, &ClientOptions{Timeout: 50 * time.Millisecond})
+	content, err := c.DownloadMessage(context.Background(), 7)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("content"), content)
+
+	// a cancelled context stops retrying
+	atomic.StoreInt32(&fs.failures, 100)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	c = fs.client(t, &ClientOptions{Timeout: time.Second, Retries: 100, RetryBackoff: time.Second})
+	start := time.Now()
+	_, err = c.GetMessages(ctx, &common.User{Key: "1"})
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestCheckSender(t *testing.T) {
+	alice := &common.User{Key: "4F1EC2D4B44966D6"}
+	good := func(trust crypto.Trust) *crypto.Signature {
+		return &crypto.Signature{Status: crypto.SigGood, Fingerprint: "89ABCDEF0123456789ABCDEF4F1EC2D4B44966D6", Trust: trust}
+	}
+
+	assert.Equal(t, common.VerifyTrusted, CheckSender(good(crypto.TrustFully), alice))
+	assert.Equal(t, common.VerifyUntrusted, CheckSender(good(crypto.TrustUndefined), alice))
+	assert.Equal(t, common.VerifyMismatch, CheckSender(good(crypto.TrustUltimate), &common.User{Key: "CCCC3333"}))
+	// a short key ID is too easy to collide with
+	assert.Equal(t, common.VerifyMismatch, CheckSender(good(crypto.TrustUltimate), &common.User{Key: "B44966D6"}))
+	assert.Equal(t, common.VerifyMismatch, CheckSender(good(crypto.TrustUltimate), nil))
+	assert.Equal(t, common.VerifyBadSignature, CheckSender(&crypto.Signature{Status: crypto.SigBad, Fingerprint: "4F1EC2D4B44966D6"}, alice))
+	assert.Equal(t, common.VerifyUnknownKey, CheckSender(&crypto.Signature{Status: crypto.SigUnknownKey}, alice))
+	expired := &crypto.Signature{Status: crypto.SigExpiredKey, Fingerprint: "4F1EC2D4B44966D6", Trust: crypto.TrustFully}
+	assert.Equal(t, common.VerifyExpiredKey, CheckSender(expired, alice))
+	assert.False(t, CheckSender(expired, alice).Authentic())
+	assert.Equal(t, common.VerifyUnsigned, CheckSender(&crypto.Signature{}, alice))
+	assert.Equal(t, common.VerifyUnsigned, CheckSender(nil, alice))
+}
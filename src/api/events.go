@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Subscribe streams the events for user's key until ctx is done or the
+// server hangs up, either of which closes the returned channel. The request
+// is signed with user's key. It is neither timed out nor retried.
+func (c *Client) Subscribe(ctx context.Context, user *common.User) (<-chan *common.Event, error) {
+	if user == nil {
+		return nil, ErrInvalidRequest
+	}
+	body, err := json.Marshal(&common.SubscribeRequest{
+		Key:       user.Key,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	signed, err := c.engine.ClearSign(user.Key, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", c.GetURL("v1/events", nil), bytes.NewReader(signed))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		return nil, unwrap(responseError(res))
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		res.Body.Close()
+		return nil, ErrUnexpectedContentType
+	}
+
+	events := make(chan *common.Event)
+	go func() {
+		defer close(events)
+		defer res.Body.Close()
+		readEvents(ctx, bufio.NewScanner(res.Body), events)
+	}()
+	return events, nil
+}
+
+// readEvents parses a text/event-stream, sending the events it can decode.
+func readEvents(ctx context.Context, scanner *bufio.Scanner, events chan<- *common.Event) {
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// end of event
+			if len(data) == 0 {
+				continue
+			}
+			var ev common.Event
+			err := json.Unmarshal([]byte(strings.Join(data, "\n")), &ev)
+			data = data[:0]
+			if err != nil {
+				continue
+			}
+			select {
+			case events <- &ev:
+			case <-ctx.Done():
+				return
+			}
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		default:
+			// comments, and "event:" which the data repeats
+		}
+	}
+}
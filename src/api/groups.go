@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"net/url"
+	"time"
+)
+
+type GroupResponse struct {
+	Success bool          `json:"success"`
+	Data    *common.Group `json:"data,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Code    string        `json:"code,omitempty"`
+}
+
+type GroupsResponse struct {
+	Success bool            `json:"success"`
+	Data    []*common.Group `json:"data,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Code    string          `json:"code,omitempty"`
+}
+
+// SetGroup creates group, owned by owner, or replaces its members. With
+// remove the group is deleted instead. The request is signed with owner's
+// key; the group as the server has it now is returned.
+func (c *Client) SetGroup(ctx context.Context, owner *common.User, group *common.Group, remove bool) (*common.Group, error) {
+	if owner == nil || group == nil || group.Name == "" {
+		return nil, ErrInvalidRequest
+	}
+	req := &common.GroupRequest{
+		Group:     *group,
+		Remove:    remove,
+		CreatedAt: time.Now(),
+	}
+	req.Owner = owner.Key
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := c.engine.ClearSign(owner.Key, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// setting the same members twice leaves the same group
+	res, d, err := c.do(ctx, &request{
+		method:      "POST",
+		path:        "v1/groups",
+		contentType: "text/plain",
+		body:        signed,
+		idempotent:  !remove,
+	})
+	if err != nil {
+		return nil, unwrap(err)
+	}
+
+	var s GroupResponse
+	if err := decodeJSON(res, d, &s); err != nil {
+		return nil, err
+	}
+	if !s.Success {
+		return nil, serverError(s.Code, s.Error)
+	}
+	return s.Data, nil
+}
+
+// GetGroup returns the group called name, which user must be in.
+func (c *Client) GetGroup(ctx context.Context, user *common.User, name string) (*common.Group, error) {
+	if user == nil || name == "" {
+		return nil, ErrInvalidRequest
+	}
+	query := &url.Values{}
+	query.Set("key", user.Key)
+	res, d, err := c.do(ctx, &request{
+		method:     "GET",
+		path:       "v1/groups/" + url.PathEscape(name),
+		query:      query,
+		idempotent: true,
+	})
+	if err != nil {
+		return nil, unwrap(err)
+	}
+
+	var s GroupResponse
+	if err := decodeJSON(res, d, &s); err != nil {
+		return nil, err
+	}
+	if !s.Success {
+		return nil, serverError(s.Code, s.Error)
+	}
+	return s.Data, nil
+}
+
+// GetGroups returns the groups user owns or is a member of.
+func (c *Client) GetGroups(ctx context.Context, user *common.User) ([]*common.Group, error) {
+	if user == nil {
+		return nil, ErrInvalidRequest
+	}
+	query := &url.Values{}
+	query.Set("key", user.Key)
+	res, d, err := c.do(ctx, &request{
+		method:     "GET",
+		path:       "v1/groups",
+		query:      query,
+		idempotent: true,
+	})
+	if err != nil {
+		return nil, unwrap(err)
+	}
+
+	var s GroupsResponse
+	if err := decodeJSON(res, d, &s); err != nil {
+		return nil, err
+	}
+	if !s.Success {
+		return nil, serverError(s.Code, s.Error)
+	}
+	return s.Data, nil
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// maxKeySize caps a key fetched from a Web Key Directory
+	maxKeySize = 1 << 20
+
+	zbase32Alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+)
+
+// KeyResolver finds the public keys of an email address. Resolve fails
+// with ErrKeyNotFound when there are none.
+type KeyResolver interface {
+	Resolve(ctx context.Context, email string) ([]*common.PublicKey, error)
+}
+
+// KeyringResolver looks keys up in the local keyring.
+type KeyringResolver struct {
+	Engine crypto.Engine
+}
+
+func (r *KeyringResolver) Resolve(ctx context.Context, email string) ([]*common.PublicKey, error) {
+	keys, err := r.Engine.ListPublicKeys("<" + email + ">")
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	found := make([]*common.PublicKey, len(keys))
+	for i, k := range keys {
+		found[i] = keyringKey(k, email)
+	}
+	return found, nil
+}
+
+// ServerResolver asks the talkie server, which knows the keys its users
+// registered with.
+type ServerResolver struct {
+	Client *Client
+}
+
+func (r *ServerResolver) Resolve(ctx context.Context, email string) ([]*common.PublicKey, error) {
+	return r.Client.LookupKeys(ctx, email)
+}
+
+// WKDResolver fetches keys from the Web Key Directory of the email's
+// domain, trying the advanced method then the direct one.
+type WKDResolver struct {
+	HTTPClient *http.Client // http.DefaultClient if nil
+}
+
+func (r *WKDResolver) Resolve(ctx context.Context, email string) ([]*common.PublicKey, error) {
+	urls, err := WKDURLs(email)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := r.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	err = ErrKeyNotFound
+	for _, u := range urls {
+		var data []byte
+		data, err = fetchKey(ctx, httpClient, u)
+		if err == nil {
+			return []*common.PublicKey{{Email: email, Data: data}}, nil
+		}
+	}
+	return nil, err
+}
+
+func fetchKey(ctx context.Context, httpClient *http.Client, u string) ([]byte, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("web key directory: %s", res.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxKeySize))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	return data, nil
+}
+
+// WKDURLs returns where the Web Key Directory keeps the key of email, by
+// the advanced method then the direct one.
+func WKDURLs(email string) ([]string, error) {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return nil, ErrInvalidRequest
+	}
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+	hash := sha1.Sum([]byte(strings.ToLower(local)))
+	hu := zbase32(hash[:]) + "?l=" + url.QueryEscape(local)
+	return []string{
+		"https://openpgpkey." + domain + "/.well-known/openpgpkey/" + domain + "/hu/" + hu,
+		"https://" + domain + "/.well-known/openpgpkey/hu/" + hu,
+	}, nil
+}
+
+func zbase32(b []byte) string {
+	var out []byte
+	var acc uint
+	bits := 0
+	for _, c := range b {
+		acc = acc<<8 | uint(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out = append(out, zbase32Alphabet[acc>>uint(bits)&31])
+		}
+		acc &= 1<<uint(bits) - 1
+	}
+	if bits > 0 {
+		out = append(out, zbase32Alphabet[acc<<uint(5-bits)&31])
+	}
+	return string(out)
+}
+
+// KeyChain asks its resolvers in turn until one finds keys. Of the keys
+// fetched from elsewhere only those that carry the email in a user id are
+// imported into the keyring and returned: a server can't pass off its own
+// key as someone else's, nor slip other keys in along with it.
+type KeyChain struct {
+	engine    crypto.Engine
+	resolvers []KeyResolver
+}
+
+func NewKeyChain(engine crypto.Engine, resolvers ...KeyResolver) *KeyChain {
+	return &KeyChain{
+		engine:    engine,
+		resolvers: resolvers,
+	}
+}
+
+// Resolve returns the keys of email. When no resolver finds any, the error
+// is the last one that went wrong, or ErrKeyNotFound.
+func (c *KeyChain) Resolve(ctx context.Context, email string) ([]*common.PublicKey, error) {
+	lastErr := ErrKeyNotFound
+	for _, r := range c.resolvers {
+		keys, err := r.Resolve(ctx, email)
+		if err != nil {
+			if err != ErrKeyNotFound {
+				lastErr = err
+			}
+			continue
+		}
+		if keys = c.importKeys(email, keys); len(keys) > 0 {
+			return keys, nil
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *KeyChain) importKeys(email string, keys []*common.PublicKey) []*common.PublicKey {
+	var found []*common.PublicKey
+	for _, key := range keys {
+		var imported []crypto.Key
+		if len(key.Data) > 0 {
+			imported, _ = c.engine.ImportMatchingKeys(bytes.NewReader(key.Data), "<"+email+">")
+		} else {
+			imported, _ = c.engine.ListPublicKeys("<" + email + ">")
+		}
+		for _, k := range imported {
+			// a WKD key comes without a fingerprint, the domain vouches for it
+			if key.Fingerprint == "" || crypto.KeyMatches(k.Fingerprint, key.Fingerprint) {
+				found = append(found, keyringKey(k, email))
+			}
+		}
+	}
+	return found
+}
+
+func keyringKey(k crypto.Key, email string) *common.PublicKey {
+	return &common.PublicKey{
+		Key:         k.Fingerprint,
+		Name:        k.Name,
+		Email:       email,
+		Fingerprint: k.Fingerprint,
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWKDURLs(t *testing.T) {
+	// the example of draft-koch-openpgp-webkey-service
+	urls, err := WKDURLs("Joe.Doe@Example.ORG")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"https://openpgpkey.example.org/.well-known/openpgpkey/example.org/hu/iy9q119eutrkn8s1mk4r39qejnbu3n5q?l=Joe.Doe",
+		"https://example.org/.well-known/openpgpkey/hu/iy9q119eutrkn8s1mk4r39qejnbu3n5q?l=Joe.Doe",
+	}, urls)
+
+	_, err = WKDURLs("joe.doe")
+	assert.Equal(t, ErrInvalidRequest, err)
+}
+
+func TestWKDResolver(t *testing.T) {
+	var hosts []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		// no openpgpkey subdomain, only the direct method
+		if r.Host == "example.com" && r.URL.Path == "/.well-known/openpgpkey/hu/iy9q119eutrkn8s1mk4r39qejnbu3n5q" {
+			w.Write([]byte("joe's key"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	// every domain is the test server
+	transport := ts.Client().Transport.(*http.Transport)
+	transport.TLSClientConfig.ServerName = "example.com"
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, ts.Listener.Addr().String())
+	}
+	r := &WKDResolver{HTTPClient: &http.Client{Transport: transport}}
+
+	keys, err := r.Resolve(context.Background(), "Joe.Doe@example.com")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(keys)) {
+		assert.Equal(t, "Joe.Doe@example.com", keys[0].Email)
+		assert.Equal(t, "joe's key", string(keys[0].Data))
+	}
+	assert.Equal(t, []string{"openpgpkey.example.com", "example.com"}, hosts)
+
+	_, err = r.Resolve(context.Background(), "jane@example.com")
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"time"
+)
+
+// sealerKeyTimeout bounds looking up the key of the sender of a sealed
+// message
+const sealerKeyTimeout = 30 * time.Second
+
+var (
+	ErrNotSealed = errors.New("message is not sealed")
+	ErrBadSeal   = errors.New("sealed message is not signed by its sender")
+)
+
+// Seal wraps msg, whose content is the plain audio, into a sealed message.
+// Sender, time, duration and thread go inside the content, which is signed
+// with the sender's key and encrypted to the recipient. The server only
+// learns the recipient and when the message expires.
+func (c *Client) Seal(msg *common.Message) (*common.Message, error) {
+	if msg == nil || msg.From == nil || msg.To == nil {
+		return nil, ErrInvalidRequest
+	}
+	inner, err := json.Marshal(&common.SealedContent{
+		From:      msg.From,
+		CreatedAt: msg.CreatedAt,
+		Duration:  msg.Duration,
+		Payload:   *common.NewPayload(msg),
+	})
+	if err != nil {
+		return nil, err
+	}
+	content, err := c.engine.Encrypt(msg.From.Key, msg.To.Key, bytes.NewReader(inner))
+	if err != nil {
+		return nil, err
+	}
+	return &common.Message{
+		To:               &common.User{Key: msg.To.Key},
+		Content:          content,
+		ExpiresAt:        msg.ExpiresAt,
+		BurnAfterPlaying: msg.BurnAfterPlaying,
+		Sealed:           true,
+	}, nil
+}
+
+// Unseal decrypts the downloaded content of a sealed message for user and
+// checks that it was signed by the sender it names. The sender, time and
+// duration are filled into msg, the audio is returned.
+func (c *Client) Unseal(user *common.User, msg *common.Message) ([]byte, *crypto.Signature, error) {
+	if user == nil || msg == nil {
+		return nil, nil, ErrInvalidRequest
+	}
+	if !msg.Sealed {
+		return nil, nil, ErrNotSealed
+	}
+	d, sig, err := c.engine.DecryptVerify(user.Key, bytes.NewReader(msg.Content))
+	if err != nil {
+		return nil, nil, err
+	}
+	var inner common.SealedContent
+	if err := json.Unmarshal(d, &inner); err != nil {
+		return nil, nil, err
+	}
+	if sig != nil && sig.Status == crypto.SigUnknownKey && inner.From != nil {
+		// only the content tells who sent it, fetch their key and check again
+		c.fetchKey(inner.From)
+		if _, checked, err := c.engine.DecryptVerify(user.Key, bytes.NewReader(msg.Content)); err == nil {
+			sig = checked
+		}
+	}
+	// anyone can claim to be anyone inside the envelope
+	msg.Verification = CheckSender(sig, inner.From)
+	if !msg.Verification.Authentic() {
+		return nil, sig, ErrBadSeal
+	}
+
+	msg.From = inner.From
+	msg.CreatedAt = inner.CreatedAt
+	msg.Duration = inner.Duration
+	inner.Apply(msg)
+	return inner.Content, sig, nil
+}
+
+// fetchKey imports the key of sender: by email from the server or the Web
+// Key Directory of its domain, else from the keyserver.
+func (c *Client) fetchKey(sender *common.User) {
+	ctx, cancel := context.WithTimeout(context.Background(), sealerKeyTimeout)
+	defer cancel()
+	if sender.Email != "" {
+		chain := NewKeyChain(c.engine, &ServerResolver{Client: c}, &WKDResolver{})
+		if keys, err := chain.Resolve(ctx, sender.Email); err == nil && len(keys) > 0 {
+			return
+		}
+	}
+	if sender.Key != "" {
+		c.engine.RecvKey(sender.Key)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	ErrInvalidCAFile = errors.New("no certificates found in CA file")
+	ErrCertNotPinned = errors.New("server certificate does not match the pinned fingerprint")
+)
+
+func (o *ClientOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, ErrInvalidCAFile
+		}
+	}
+
+	if o.PinnedCert != "" {
+		// the pin replaces the usual chain verification, otherwise
+		// self-signed certificates could never be pinned
+		pinned := common.NormalizeFingerprint(o.PinnedCert)
+		roots := config.RootCAs
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || common.CertFingerprint(rawCerts[0]) != pinned {
+				return ErrCertNotPinned
+			}
+			if roots == nil {
+				return nil
+			}
+			// a CA bundle was given too, so the chain has to check out as well
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			_, err = cert.Verify(x509.VerifyOptions{Roots: roots})
+			return err
+		}
+	}
+	return config, nil
+}
+
+// FetchCertFingerprint connects to the server at addr and returns the
+// fingerprint of the certificate it presents, without verifying it. Show it
+// to the user before pinning it.
+func FetchCertFingerprint(addr string) (string, error) {
+	_, host := splitScheme(addr)
+	conn, err := tls.Dial("tcp", host, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", ErrCertNotPinned
+	}
+	return common.CertFingerprint(certs[0].Raw), nil
+}
+
+// splitScheme splits "https://host:port" into its scheme and host:port. The
+// scheme is empty if addr has none.
+func splitScheme(addr string) (string, string) {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		return strings.ToLower(addr[:i]), strings.TrimSuffix(addr[i+3:], "/")
+	}
+	return "", addr
+}
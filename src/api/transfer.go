@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"io"
+	"net/http"
+)
+
+const (
+	DefaultChunkSize = 1 << 20
+
+	// downloads are read in pieces of this size, for progress reports
+	downloadBufferSize = 32 << 10
+)
+
+// Progress is told how many of total bytes were transferred so far.
+type Progress func(done, total int64)
+
+type UploadResponse struct {
+	Success bool           `json:"success"`
+	Data    *common.Upload `json:"data,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	Code    string         `json:"code,omitempty"`
+}
+
+// Upload sends msg in chunks. A chunk that fails is sent again from where
+// the server says the upload stands, so a flaky connection doesn't mean
+// starting over. Servers without resumable uploads get msg through Send.
+func (c *Client) Upload(ctx context.Context, msg *common.Message, progress Progress) error {
+	if msg == nil || (msg.From == nil && !msg.Sealed) || len(msg.Content) == 0 {
+		return ErrInvalidRequest
+	}
+	if err := c.signMessage(msg); err != nil {
+		return err
+	}
+	content := msg.Content
+	size := int64(len(content))
+
+	meta := *msg
+	meta.Content = nil
+	body, err := json.Marshal(&common.UploadRequest{
+		Message: &meta,
+		Size:    size,
+	})
+	if err != nil {
+		return err
+	}
+	// an upload started twice is just left to expire
+	upload, err := c.uploadRequest(ctx, &request{
+		method:      "POST",
+		path:        "v1/uploads",
+		contentType: "application/json",
+		body:        body,
+		idempotent:  true,
+	})
+	if err == ErrNotFound {
+		return c.Send(ctx, msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	id := upload.ID
+	offset := upload.Offset
+	failures := 0
+	for offset < size {
+		if progress != nil {
+			progress(offset, size)
+		}
+		end := offset + DefaultChunkSize
+		if end > size {
+			end = size
+		}
+		upload, err := c.uploadRequest(ctx, &request{
+			method:      "PUT",
+			path:        "v1/uploads/" + id,
+			contentType: "application/octet-stream",
+			header: http.Header{
+				"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", offset, end-1, size)},
+			},
+			body: content[offset:end],
+		})
+		if err == nil {
+			offset = upload.Offset
+			failures = 0
+			continue
+		}
+
+		if err == ErrMessageTooLarge || err == ErrNotFound || ctx.Err() != nil {
+			return err
+		}
+		failures++
+		if failures > c.retries {
+			return err
+		}
+		if c.wait(ctx, failures, err) != nil {
+			return err
+		}
+		// find out how much of the chunk made it
+		status, statusErr := c.uploadRequest(ctx, &request{
+			method:     "GET",
+			path:       "v1/uploads/" + id,
+			idempotent: true,
+		})
+		if statusErr == nil {
+			offset = status.Offset
+		}
+	}
+	if progress != nil {
+		progress(size, size)
+	}
+
+	body, err = json.Marshal(&common.UploadFinish{
+		SHA256: checksum(content),
+	})
+	if err != nil {
+		return err
+	}
+	// finishing again answers with the message the first finish delivered
+	res, d, err := c.do(ctx, &request{
+		method:      "POST",
+		path:        "v1/uploads/" + id + "/finish",
+		contentType: "application/json",
+		body:        body,
+		idempotent:  true,
+	})
+	if err != nil {
+		return unwrap(err)
+	}
+	var s SendResponse
+	if err := decodeJSON(res, d, &s); err != nil {
+		return err
+	}
+	if !s.Success {
+		return serverError(s.Code, s.Error)
+	}
+	msg.MessageID = s.Data
+	return nil
+}
+
+// checksum is the hex encoded SHA-256 of content.
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// uploadRequest sends a request answered with the state of an upload.
+func (c *Client) uploadRequest(ctx context.Context, req *request) (*common.Upload, error) {
+	res, d, err := c.do(ctx, req)
+	if err != nil {
+		return nil, unwrap(err)
+	}
+	var s UploadResponse
+	if err := decodeJSON(res, d, &s); err != nil {
+		return nil, err
+	}
+	if !s.Success || s.Data == nil {
+		return nil, serverError(s.Code, s.Error)
+	}
+	return s.Data, nil
+}
+
+// Download fetches the content of a message. A download that breaks off is
+// resumed with a Range request from where it stopped.
+func (c *Client) Download(ctx context.Context, msgID int64, progress Progress) ([]byte, error) {
+	var content []byte
+	total := int64(-1)
+	failures := 0
+	for {
+		before := len(content)
+		err := c.downloadFrom(ctx, msgID, &content, &total, progress)
+		if err == nil {
+			return content, nil
+		}
+		if len(content) > before {
+			// getting somewhere, keep going
+			failures = 0
+		}
+		if _, broken := err.(*readError); !broken && !retryable(ctx, err) {
+			return nil, unwrap(err)
+		}
+		failures++
+		if failures > c.retries || c.wait(ctx, failures, err) != nil {
+			return nil, unwrap(err)
+		}
+	}
+}
+
+// readError is a response body that broke off.
+type readError struct {
+	err error
+}
+
+func (e *readError) Error() string {
+	return e.err.Error()
+}
+
+// downloadFrom requests the content of msgID after what content holds and
+// appends what arrives to it.
+func (c *Client) downloadFrom(ctx context.Context, msgID int64, content *[]byte, total *int64, progress Progress) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req := &request{
+		method: "GET",
+		path:   fmt.Sprintf("v1/messages/%d/content", msgID),
+	}
+	offset := int64(len(*content))
+	if offset > 0 {
+		req.header = http.Header{
+			"Range": {fmt.Sprintf("bytes=%d-", offset)},
+		}
+	}
+	httpReq, err := c.newHTTPRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		// the whole content, whether we asked for it or not
+		*content = (*content)[:0]
+		*total = res.ContentLength
+	case http.StatusPartialContent:
+		var start, end, size int64
+		if _, err := fmt.Sscanf(res.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size); err != nil || start != offset {
+			return ErrUnexpectedContentType
+		}
+		*total = size
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset > 0 && offset == *total {
+			return nil
+		}
+		return responseError(res)
+	default:
+		return responseError(res)
+	}
+	if res.Header.Get("Content-Type") != "application/octet-stream" {
+		return ErrUnexpectedContentType
+	}
+
+	buf := make([]byte, downloadBufferSize)
+	for {
+		n, err := res.Body.Read(buf)
+		*content = append(*content, buf[:n]...)
+		if n > 0 && progress != nil {
+			progress(int64(len(*content)), *total)
+		}
+		if err == io.EOF {
+			if *total >= 0 && int64(len(*content)) < *total {
+				return &readError{io.ErrUnexpectedEOF}
+			}
+			return nil
+		}
+		if err != nil {
+			return &readError{err}
+		}
+	}
+}
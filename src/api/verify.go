@@ -0,0 +1,47 @@
+package api
+
+import (
+	"bytes"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+)
+
+// CheckSender tells whether sig is a good signature made by sender.
+func CheckSender(sig *crypto.Signature, sender *common.User) common.Verification {
+	switch {
+	case sig == nil || sig.Status == crypto.SigMissing:
+		return common.VerifyUnsigned
+	case sig.Status == crypto.SigUnknownKey:
+		return common.VerifyUnknownKey
+	case sig.Status == crypto.SigBad || sig.Status == crypto.SigRevokedKey:
+		return common.VerifyBadSignature
+	case sender == nil || !crypto.KeyMatches(sig.Fingerprint, sender.Key):
+		return common.VerifyMismatch
+	case sig.Status == crypto.SigExpiredKey:
+		return common.VerifyExpiredKey
+	case sig.Status == crypto.SigGood && sig.Trust >= crypto.TrustMarginal:
+		return common.VerifyTrusted
+	}
+	return common.VerifyUntrusted
+}
+
+// Open decrypts the downloaded content of msg for user, sealed or not, and
+// checks who signed it. How that went is left in msg.Verification; a
+// message that doesn't verify is still returned, it is up to the caller to
+// warn about it.
+func (c *Client) Open(user *common.User, msg *common.Message) ([]byte, *crypto.Signature, error) {
+	if user == nil || msg == nil {
+		return nil, nil, ErrInvalidRequest
+	}
+	if msg.Sealed {
+		return c.Unseal(user, msg)
+	}
+	content, sig, err := c.engine.DecryptVerify(user.Key, bytes.NewReader(msg.Content))
+	if err != nil {
+		return nil, nil, err
+	}
+	msg.Verification = CheckSender(sig, msg.From)
+	payload := common.ParsePayload(content)
+	payload.Apply(msg)
+	return payload.Content, sig, nil
+}
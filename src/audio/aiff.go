@@ -0,0 +1,118 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// DecodeAIFF reads the samples of an AIFF file, mixed down to mono, and
+// their sample rate.
+func DecodeAIFF(content []byte) ([]int32, float64, error) {
+	f := bytes.NewReader(content)
+	id, data, err := readChunk(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	if id.String() != "FORM" {
+		return nil, 0, ErrBadFileFormat
+	}
+	_, err = data.Read(id[:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if id.String() != "AIFF" {
+		return nil, 0, ErrBadFileFormat
+	}
+
+	var c commonChunk
+	var audio io.Reader
+	for {
+		id, chunk, err := readChunk(data)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch id.String() {
+		case "COMM":
+			err = binary.Read(chunk, binary.BigEndian, &c)
+			if err != nil {
+				return nil, 0, err
+			}
+		case "SSND":
+			chunk.Seek(8, 1) //ignore offset and block
+			audio = chunk
+		}
+	}
+	if audio == nil {
+		return nil, 0, ErrBadFileFormat
+	}
+	rate := extendedFloat(c.SampleRate)
+	if rate <= 0 {
+		return nil, 0, ErrBadFileFormat
+	}
+
+	raw, err := ioutil.ReadAll(audio)
+	if err != nil {
+		return nil, 0, err
+	}
+	// a file cut short plays what is there
+	samples, err := pcmSamples(raw, binary.BigEndian, int(c.BitsPerSample), int(c.NumChans), true)
+	if err != nil {
+		return nil, 0, err
+	}
+	if int(c.NumSamples) < len(samples) && c.NumSamples >= 0 {
+		samples = samples[:c.NumSamples]
+	}
+	return samples, rate, nil
+}
+
+// extendedFloat decodes the 80-bit float AIFF keeps its sample rate in.
+func extendedFloat(b [10]byte) float64 {
+	exponent := int(b[0]&0x7f)<<8 | int(b[1])
+	mantissa := binary.BigEndian.Uint64(b[2:])
+	v := math.Ldexp(float64(mantissa), exponent-16383-63)
+	if b[0]&0x80 != 0 {
+		v = -v
+	}
+	return v
+}
+
+// EncodeAIFF writes mono samples taken at rate as an AIFF file, the way
+// RecordAIFF does.
+func EncodeAIFF(samples []int32, rate float64) []byte {
+	var b bytes.Buffer
+	b.WriteString("FORM")
+	binary.Write(&b, binary.BigEndian, int32(4+8+18+8+8+4*len(samples))) //total bytes
+	b.WriteString("AIFF")
+
+	b.WriteString("COMM")
+	binary.Write(&b, binary.BigEndian, int32(18))           //size
+	binary.Write(&b, binary.BigEndian, int16(1))            //channels
+	binary.Write(&b, binary.BigEndian, int32(len(samples))) //number of samples
+	binary.Write(&b, binary.BigEndian, int16(32))           //bits per sample
+	rateBytes := extended(rate)
+	b.Write(rateBytes[:])
+
+	b.WriteString("SSND")
+	binary.Write(&b, binary.BigEndian, int32(8+4*len(samples))) //size
+	binary.Write(&b, binary.BigEndian, int32(0))                //offset
+	binary.Write(&b, binary.BigEndian, int32(0))                //block
+	binary.Write(&b, binary.BigEndian, samples)
+	return b.Bytes()
+}
+
+// extended encodes v, which must be positive, as an 80-bit float.
+func extended(v float64) [10]byte {
+	var b [10]byte
+	frac, exp := math.Frexp(v) // v = frac * 2^exp, 0.5 <= frac < 1
+	exponent := uint16(exp - 1 + 16383)
+	binary.BigEndian.PutUint16(b[0:], exponent)
+	binary.BigEndian.PutUint64(b[2:], uint64(math.Ldexp(frac, 64)))
+	return b
+}
@@ -0,0 +1,80 @@
+package audio
+
+import (
+	"code.google.com/p/portaudio-go/portaudio"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNoDevice = errors.New("no such audio device, list them with `talkie record --devices`")
+)
+
+// InputDevices are the names of the devices that can record.
+func InputDevices() ([]string, error) {
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, d := range devices {
+		if d.MaxInputChannels > 0 {
+			names = append(names, d.Name)
+		}
+	}
+	return names, nil
+}
+
+// findDevice is the device called name, or whose name has name in it, that
+// can record or play.
+func findDevice(name string, input bool) (*portaudio.DeviceInfo, error) {
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, err
+	}
+	var found *portaudio.DeviceInfo
+	for _, d := range devices {
+		if (input && d.MaxInputChannels == 0) || (!input && d.MaxOutputChannels == 0) {
+			continue
+		}
+		if d.Name == name {
+			return d, nil
+		}
+		if found == nil && strings.Contains(strings.ToLower(d.Name), strings.ToLower(name)) {
+			found = d
+		}
+	}
+	if found == nil {
+		return nil, ErrNoDevice
+	}
+	return found, nil
+}
+
+// openStream opens a stream recording channels from the input device
+// called device, or playing them on the output device, into or out of
+// buffer. An empty device is the default one.
+func openStream(device string, input bool, channels int, rate float64, buffer *[]int32) (*portaudio.Stream, error) {
+	frames := len(*buffer) / channels
+	if device == "" {
+		if input {
+			return portaudio.OpenDefaultStream(channels, 0, rate, frames, buffer)
+		}
+		return portaudio.OpenDefaultStream(0, channels, rate, frames, buffer)
+	}
+
+	d, err := findDevice(device, input)
+	if err != nil {
+		return nil, err
+	}
+	var params portaudio.StreamParameters
+	if input {
+		params = portaudio.HighLatencyParameters(d, nil)
+		params.Input.Channels = channels
+	} else {
+		params = portaudio.HighLatencyParameters(nil, d)
+		params.Output.Channels = channels
+	}
+	params.SampleRate = rate
+	params.FramesPerBuffer = frames
+	return portaudio.OpenStream(params, buffer)
+}
@@ -0,0 +1,238 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const (
+	FormatAIFF = "aiff"
+	FormatWAV  = "wav"
+	FormatOpus = "opus"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown audio format, use aiff, wav or opus")
+	ErrNoAudio       = errors.New("there is no audio in it")
+)
+
+// the programs of opus-tools that encode and decode Opus
+var (
+	OpusEncoder = "opusenc"
+	OpusDecoder = "opusdec"
+)
+
+// FormatOf is the format the extension of a file name stands for.
+func FormatOf(name string) (string, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".aiff", ".aif":
+		return FormatAIFF, nil
+	case ".wav":
+		return FormatWAV, nil
+	case ".opus", ".ogg":
+		return FormatOpus, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Sniff tells the format of content from its first bytes, empty if it is
+// none talkie knows.
+func Sniff(content []byte) string {
+	switch {
+	case len(content) >= 12 && string(content[0:4]) == "FORM" && (string(content[8:12]) == "AIFF" || string(content[8:12]) == "AIFC"):
+		return FormatAIFF
+	case len(content) >= 12 && string(content[0:4]) == "RIFF" && string(content[8:12]) == "WAVE":
+		return FormatWAV
+	case len(content) >= 36 && string(content[0:4]) == "OggS" && bytes.Contains(content[:64], []byte("OpusHead")):
+		return FormatOpus
+	}
+	return ""
+}
+
+// Decode reads audio in any of the formats, as mono samples and their rate.
+func Decode(content []byte) ([]int32, float64, error) {
+	switch Sniff(content) {
+	case FormatAIFF:
+		return DecodeAIFF(content)
+	case FormatWAV:
+		return DecodeWAV(content)
+	case FormatOpus:
+		wav, err := runCodec(OpusDecoder, content, "--quiet", "--force-wav", "-", "-")
+		if err != nil {
+			return nil, 0, err
+		}
+		return DecodeWAV(wav)
+	}
+	return nil, 0, ErrUnknownFormat
+}
+
+// Encode writes mono samples taken at rate in format.
+func Encode(samples []int32, rate float64, format string) ([]byte, error) {
+	switch format {
+	case FormatAIFF:
+		return EncodeAIFF(samples, rate), nil
+	case FormatWAV:
+		return EncodeWAV(samples, int(rate)), nil
+	case FormatOpus:
+		return runCodec(OpusEncoder, EncodeWAV(samples, int(rate)), "--quiet", "-", "-")
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Canonical converts content, in any of the formats, to what a message
+// holds: mono AIFF at DefaultSampleRate, like RecordAIFF records.
+func Canonical(content []byte) ([]byte, error) {
+	samples, rate, err := Decode(content)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 || rate <= 0 {
+		return nil, ErrNoAudio
+	}
+	return EncodeAIFF(Resample(samples, rate, DefaultSampleRate), DefaultSampleRate), nil
+}
+
+// runCodec pipes content through program.
+func runCodec(program string, content []byte, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s: %s", program, err.Error(), msg)
+		}
+		return nil, fmt.Errorf("%s: %s", program, err.Error())
+	}
+	return stdout.Bytes(), nil
+}
+
+// DecodeWAV reads the samples of a PCM or float WAV file, mixed down to
+// mono, and their sample rate.
+func DecodeWAV(content []byte) ([]int32, float64, error) {
+	if Sniff(content) != FormatWAV {
+		return nil, 0, ErrBadFileFormat
+	}
+	var format, channels, bits int
+	var rate float64
+	var data []byte
+	for rest := content[12:]; len(rest) >= 8; {
+		id := string(rest[0:4])
+		size := int(binary.LittleEndian.Uint32(rest[4:8]))
+		rest = rest[8:]
+		if size > len(rest) || size < 0 {
+			// a file cut short plays what is there
+			size = len(rest)
+		}
+		chunk := rest[:size]
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, 0, ErrBadFileFormat
+			}
+			format = int(binary.LittleEndian.Uint16(chunk[0:]))
+			channels = int(binary.LittleEndian.Uint16(chunk[2:]))
+			rate = float64(binary.LittleEndian.Uint32(chunk[4:]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:]))
+			// WAVE_FORMAT_EXTENSIBLE names the real format in its sub format
+			if format == 0xfffe && len(chunk) >= 26 {
+				format = int(binary.LittleEndian.Uint16(chunk[24:]))
+			}
+		case "data":
+			data = chunk
+		}
+		// chunks are padded to an even size
+		if size%2 == 1 && size < len(rest) {
+			size++
+		}
+		rest = rest[size:]
+	}
+	if data == nil || rate <= 0 {
+		return nil, 0, ErrBadFileFormat
+	}
+
+	var samples []int32
+	var err error
+	switch format {
+	case 1: // PCM
+		samples, err = pcmSamples(data, binary.LittleEndian, bits, channels, false)
+	case 3: // IEEE float
+		samples, err = floatSamples(data, bits, channels)
+	default:
+		err = ErrBadFileFormat
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return samples, rate, nil
+}
+
+// pcmSamples decodes integer PCM frames of channels, mixing them down to
+// full scale 32-bit mono. 8-bit samples are signed in AIFF, unsigned in WAV.
+func pcmSamples(raw []byte, order binary.ByteOrder, bits, channels int, signed8 bool) ([]int32, error) {
+	width := (bits + 7) / 8
+	if width < 1 || width > 4 || channels < 1 {
+		return nil, ErrBadFileFormat
+	}
+	frame := width * channels
+	samples := make([]int32, len(raw)/frame)
+	for i := range samples {
+		var sum int64
+		for ch := 0; ch < channels; ch++ {
+			b := raw[i*frame+ch*width : i*frame+(ch+1)*width]
+			var v int32
+			switch width {
+			case 1:
+				if signed8 {
+					v = int32(int8(b[0])) << 24
+				} else {
+					v = (int32(b[0]) - 128) << 24
+				}
+			case 2:
+				v = int32(int16(order.Uint16(b))) << 16
+			case 3:
+				if order == binary.BigEndian {
+					v = int32(uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8)
+				} else {
+					v = int32(uint32(b[2])<<24 | uint32(b[1])<<16 | uint32(b[0])<<8)
+				}
+			case 4:
+				v = int32(order.Uint32(b))
+			}
+			sum += int64(v)
+		}
+		samples[i] = int32(sum / int64(channels))
+	}
+	return samples, nil
+}
+
+// floatSamples decodes little endian float frames of channels like
+// pcmSamples.
+func floatSamples(raw []byte, bits, channels int) ([]int32, error) {
+	width := bits / 8
+	if (width != 4 && width != 8) || channels < 1 {
+		return nil, ErrBadFileFormat
+	}
+	frame := width * channels
+	samples := make([]int32, len(raw)/frame)
+	for i := range samples {
+		var sum float64
+		for ch := 0; ch < channels; ch++ {
+			b := raw[i*frame+ch*width:]
+			if width == 4 {
+				sum += float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+			} else {
+				sum += math.Float64frombits(binary.LittleEndian.Uint64(b))
+			}
+		}
+		samples[i] = toSample(sum / float64(channels) * math.MaxInt32)
+	}
+	return samples, nil
+}
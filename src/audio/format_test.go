@@ -0,0 +1,139 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"os/exec"
+	"testing"
+)
+
+// wavFile makes a WAV file of frames, already encoded.
+func wavFile(format, channels, rate, bits int, frames []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, int32(36+len(frames)))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, int32(16))
+	binary.Write(&b, binary.LittleEndian, int16(format))
+	binary.Write(&b, binary.LittleEndian, int16(channels))
+	binary.Write(&b, binary.LittleEndian, int32(rate))
+	binary.Write(&b, binary.LittleEndian, int32(rate*channels*bits/8))
+	binary.Write(&b, binary.LittleEndian, int16(channels*bits/8))
+	binary.Write(&b, binary.LittleEndian, int16(bits))
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, int32(len(frames)))
+	b.Write(frames)
+	return b.Bytes()
+}
+
+func TestFormatOf(t *testing.T) {
+	for name, format := range map[string]string{
+		"a.aiff": FormatAIFF, "a.AIF": FormatAIFF, "b.wav": FormatWAV, "c.opus": FormatOpus, "c.ogg": FormatOpus,
+	} {
+		f, err := FormatOf(name)
+		assert.Nil(t, err)
+		assert.Equal(t, format, f)
+	}
+	_, err := FormatOf("d.mp3")
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestSniff(t *testing.T) {
+	assert.Equal(t, FormatAIFF, Sniff(EncodeAIFF([]int32{1}, DefaultSampleRate)))
+	assert.Equal(t, FormatWAV, Sniff(EncodeWAV([]int32{1}, 16000)))
+	assert.Equal(t, "", Sniff([]byte("ID3 not audio we know")))
+	assert.Equal(t, "", Sniff(nil))
+}
+
+func TestDecodeWAV(t *testing.T) {
+	samples := []int32{0, 1 << 30, -1 << 30, math.MaxInt32 &^ 0xffff}
+	decoded, rate, err := Decode(EncodeWAV(samples, 16000))
+	assert.Nil(t, err)
+	assert.Equal(t, 16000.0, rate)
+	assert.Equal(t, samples, decoded)
+
+	// stereo is mixed down
+	stereo := []int16{1000, 3000, -2000, -4000}
+	var frames bytes.Buffer
+	binary.Write(&frames, binary.LittleEndian, stereo)
+	decoded, rate, err = Decode(wavFile(1, 2, 48000, 16, frames.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, 48000.0, rate)
+	assert.Equal(t, []int32{2000 << 16, -3000 << 16}, decoded)
+
+	// 24 bit
+	decoded, _, err = Decode(wavFile(1, 1, 8000, 24, []byte{0x00, 0x00, 0x40, 0x00, 0x00, 0xc0}))
+	assert.Nil(t, err)
+	assert.Equal(t, []int32{1 << 30, -1 << 30}, decoded)
+
+	// 8 bit is unsigned
+	decoded, _, err = Decode(wavFile(1, 1, 8000, 8, []byte{128, 192}))
+	assert.Nil(t, err)
+	assert.Equal(t, []int32{0, 1 << 30}, decoded)
+
+	// float
+	frames.Reset()
+	binary.Write(&frames, binary.LittleEndian, []float32{0.5, -0.5})
+	decoded, _, err = Decode(wavFile(3, 1, 8000, 32, frames.Bytes()))
+	assert.Nil(t, err)
+	assert.InDelta(t, 1<<30, decoded[0], 2)
+	assert.InDelta(t, -1<<30, decoded[1], 2)
+
+	_, _, err = Decode(wavFile(2, 1, 8000, 4, []byte{1, 2})) // ADPCM
+	assert.Equal(t, ErrBadFileFormat, err)
+	_, _, err = Decode([]byte("not audio"))
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestDecodeAIFF16BitStereo(t *testing.T) {
+	content := EncodeAIFF([]int32{0, 0}, 22050)
+	// make it a 16 bit stereo file of one frame: same size of data
+	binary.BigEndian.PutUint16(content[20:], 2)
+	binary.BigEndian.PutUint32(content[22:], 1)
+	binary.BigEndian.PutUint16(content[26:], 16)
+	binary.BigEndian.PutUint16(content[54:], 1000)
+	binary.BigEndian.PutUint16(content[56:], 3000)
+	decoded, rate, err := Decode(content)
+	assert.Nil(t, err)
+	assert.Equal(t, 22050.0, rate)
+	assert.Equal(t, []int32{2000 << 16}, decoded)
+}
+
+func TestCanonical(t *testing.T) {
+	// a second of 22.05kHz 16 bit stereo WAV
+	frames := make([]byte, 22050*4)
+	for i := 0; i < 22050; i++ {
+		binary.LittleEndian.PutUint16(frames[4*i:], uint16(int16(1000)))
+		binary.LittleEndian.PutUint16(frames[4*i+2:], uint16(int16(3000)))
+	}
+	content, err := Canonical(wavFile(1, 2, 22050, 16, frames))
+	assert.Nil(t, err)
+	assert.Equal(t, FormatAIFF, Sniff(content))
+	samples, rate, err := DecodeAIFF(content)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(DefaultSampleRate), rate)
+	assert.Equal(t, DefaultSampleRate, len(samples))
+	assert.Equal(t, int32(2000<<16), samples[DefaultSampleRate/2])
+
+	_, err = Canonical(wavFile(1, 1, 22050, 16, nil))
+	assert.Equal(t, ErrNoAudio, err)
+	_, err = Canonical([]byte("not audio"))
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestOpus(t *testing.T) {
+	if _, err := exec.LookPath(OpusEncoder); err != nil {
+		t.Skip("needs opus-tools")
+	}
+	samples := sine(440, 1)
+	opus, err := Encode(samples, DefaultSampleRate, FormatOpus)
+	assert.Nil(t, err)
+	assert.Equal(t, FormatOpus, Sniff(opus))
+
+	decoded, rate, err := Decode(opus)
+	assert.Nil(t, err)
+	assert.Equal(t, 48000.0, rate)
+	assert.InDelta(t, 440, crossings(decoded), 5)
+}
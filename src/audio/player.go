@@ -0,0 +1,218 @@
+package audio
+
+import (
+	"code.google.com/p/portaudio-go/portaudio"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+const (
+	MinSpeed = 0.5
+	MaxSpeed = 2.0
+)
+
+var (
+	ErrBadSpeed = errors.New("speed must be between 0.5 and 2")
+)
+
+type PlayerOptions struct {
+	Speed            float64                 // default: 1
+	Callback         func(pos time.Duration) // called as it plays with the position
+	CallbackInterval time.Duration           // default: 100ms
+	Device           string                  // the output device, or part of its name. default: the default one
+}
+
+// Player plays audio that can be paused, resumed, sped up or slowed down
+// and moved around in while it plays. All its methods are safe to call
+// from another goroutine than the one in Play.
+type Player struct {
+	options PlayerOptions
+	samples []int32
+	rate    float64
+
+	mu      sync.Mutex
+	pos     float64 // in samples
+	speed   float64
+	paused  bool
+	stopped bool
+	stretch *stretcher
+}
+
+// NewPlayer makes a player for content, an AIFF, WAV or Opus file.
+func NewPlayer(content []byte, options PlayerOptions) (*Player, error) {
+	samples, rate, err := Decode(content)
+	if err != nil {
+		return nil, err
+	}
+	if options.Speed == 0 {
+		options.Speed = 1
+	}
+	if options.Speed < MinSpeed || options.Speed > MaxSpeed {
+		return nil, ErrBadSpeed
+	}
+	if options.CallbackInterval <= 0 {
+		options.CallbackInterval = 100 * time.Millisecond
+	}
+	return &Player{
+		options: options,
+		samples: samples,
+		rate:    rate,
+		speed:   options.Speed,
+		stretch: newStretcher(),
+	}, nil
+}
+
+// OpenPlayer makes a player for the audio file at p.
+func OpenPlayer(p string, options PlayerOptions) (*Player, error) {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return NewPlayer(content, options)
+}
+
+// Play plays until the end or Stop.
+func (p *Player) Play() error {
+	portaudio.Initialize()
+	defer portaudio.Terminate()
+	out := make([]int32, p.stretch.hop())
+	stream, err := openStream(p.options.Device, false, 1, p.rate, &out)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := stream.Start(); err != nil {
+		return err
+	}
+	defer stream.Stop()
+
+	var reported time.Time
+	for p.read(out) {
+		if err := stream.Write(); err != nil {
+			return err
+		}
+		if p.options.Callback != nil && time.Since(reported) >= p.options.CallbackInterval {
+			p.options.Callback(p.Position())
+			reported = time.Now()
+		}
+	}
+	return nil
+}
+
+// read fills out with what comes next, silence while paused, and tells
+// whether there is anything left to play.
+func (p *Player) read(out []int32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped || int(p.pos) >= len(p.samples) {
+		return false
+	}
+	if p.paused {
+		for i := range out {
+			out[i] = 0
+		}
+		return true
+	}
+
+	if p.speed == 1 {
+		at := int(p.pos)
+		for i := range out {
+			out[i] = int32(sampleAt(p.samples, at+i))
+		}
+		p.pos += float64(len(out))
+		return true
+	}
+
+	frame := make([]float64, len(out))
+	p.stretch.next(p.samples, int(p.pos), frame)
+	for i, v := range frame {
+		out[i] = toSample(v)
+	}
+	p.pos += float64(len(out)) * p.speed
+	return true
+}
+
+func (p *Player) Pause() {
+	p.mu.Lock()
+	p.paused = true
+	p.mu.Unlock()
+}
+
+func (p *Player) Resume() {
+	p.mu.Lock()
+	p.paused = false
+	p.mu.Unlock()
+}
+
+func (p *Player) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// Stop ends Play; the player can't be played again.
+func (p *Player) Stop() {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+}
+
+// Seek moves to pos from the start, kept within the audio.
+func (p *Player) Seek(pos time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pos = pos.Seconds() * p.rate
+	if p.pos < 0 {
+		p.pos = 0
+	}
+	if p.pos > float64(len(p.samples)) {
+		p.pos = float64(len(p.samples))
+	}
+	p.stretch.reset()
+}
+
+// Skip moves d forward, or back if d is negative.
+func (p *Player) Skip(d time.Duration) {
+	p.Seek(p.Position() + d)
+}
+
+// Position is how far into the audio the player is.
+func (p *Player) Position() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.duration(p.pos)
+}
+
+// Duration is how long the audio is, at normal speed.
+func (p *Player) Duration() time.Duration {
+	return p.duration(float64(len(p.samples)))
+}
+
+func (p *Player) duration(samples float64) time.Duration {
+	return time.Duration(samples / p.rate * float64(time.Second))
+}
+
+// SetSpeed plays on at speed times the normal speed, without changing the
+// pitch.
+func (p *Player) SetSpeed(speed float64) error {
+	if speed < MinSpeed || speed > MaxSpeed {
+		return ErrBadSpeed
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if speed != p.speed {
+		p.speed = speed
+		p.stretch.reset()
+	}
+	return nil
+}
+
+func (p *Player) Speed() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.speed
+}
@@ -0,0 +1,136 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+// aiff makes an AIFF file of samples, as RecordAIFF writes them.
+func aiff(samples []int32) []byte {
+	var b bytes.Buffer
+	b.WriteString("FORM")
+	binary.Write(&b, binary.BigEndian, int32(4+8+18+8+8+4*len(samples)))
+	b.WriteString("AIFFCOMM")
+	binary.Write(&b, binary.BigEndian, int32(18))
+	binary.Write(&b, binary.BigEndian, int16(1))
+	binary.Write(&b, binary.BigEndian, int32(len(samples)))
+	binary.Write(&b, binary.BigEndian, int16(32))
+	b.Write([]byte{0x40, 0x0e, 0xac, 0x44, 0, 0, 0, 0, 0, 0})
+	b.WriteString("SSND")
+	binary.Write(&b, binary.BigEndian, int32(8+4*len(samples)))
+	binary.Write(&b, binary.BigEndian, int32(0))
+	binary.Write(&b, binary.BigEndian, int32(0))
+	binary.Write(&b, binary.BigEndian, samples)
+	return b.Bytes()
+}
+
+// sine is seconds of a tone at freq.
+func sine(freq, seconds float64) []int32 {
+	samples := make([]int32, int(seconds*DefaultSampleRate))
+	for i := range samples {
+		samples[i] = int32(math.Sin(2*math.Pi*freq*float64(i)/DefaultSampleRate) * (1 << 28))
+	}
+	return samples
+}
+
+// playAll reads everything p has to play.
+func playAll(p *Player) []int32 {
+	var played []int32
+	out := make([]int32, p.stretch.hop())
+	for p.read(out) {
+		played = append(played, out...)
+	}
+	return played
+}
+
+// crossings counts how often samples go from negative to positive.
+func crossings(samples []int32) int {
+	n := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 0 && samples[i] >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestDecodeAIFF(t *testing.T) {
+	samples := sine(440, 0.1)
+	decoded, rate, err := DecodeAIFF(aiff(samples))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(DefaultSampleRate), rate)
+	assert.Equal(t, samples, decoded)
+
+	// a file cut short plays what is there
+	content := aiff(samples)
+	decoded, _, err = DecodeAIFF(content[:len(content)-40])
+	assert.Nil(t, err)
+	assert.Equal(t, samples[:len(samples)-10], decoded)
+
+	_, _, err = DecodeAIFF([]byte("RIFF0000WAVE"))
+	assert.Equal(t, ErrBadFileFormat, err)
+
+	// what RecordAIFF writes, at any rate
+	assert.Equal(t, aiff(samples), EncodeAIFF(samples, DefaultSampleRate))
+	decoded, rate, err = DecodeAIFF(EncodeAIFF(samples, 16000))
+	assert.Nil(t, err)
+	assert.Equal(t, 16000.0, rate)
+	assert.Equal(t, samples, decoded)
+}
+
+func TestPlayerControls(t *testing.T) {
+	samples := sine(440, 2)
+	p, err := NewPlayer(aiff(samples), PlayerOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Second, p.Duration())
+
+	out := make([]int32, p.stretch.hop())
+	assert.True(t, p.read(out))
+	assert.Equal(t, samples[:len(out)], out)
+
+	// paused, it plays silence and stays where it is
+	p.Pause()
+	pos := p.Position()
+	assert.True(t, p.read(out))
+	assert.Equal(t, make([]int32, len(out)), out)
+	assert.Equal(t, pos, p.Position())
+	p.Resume()
+
+	p.Seek(time.Second)
+	assert.Equal(t, time.Second, p.Position())
+	assert.True(t, p.read(out))
+	assert.Equal(t, samples[DefaultSampleRate:DefaultSampleRate+len(out)], out)
+
+	p.Skip(-5 * time.Second)
+	assert.Equal(t, time.Duration(0), p.Position())
+
+	p.Stop()
+	assert.False(t, p.read(out))
+}
+
+func TestPlayerSpeed(t *testing.T) {
+	samples := sine(440, 2)
+	_, err := NewPlayer(aiff(samples), PlayerOptions{Speed: 3})
+	assert.Equal(t, ErrBadSpeed, err)
+
+	for _, speed := range []float64{0.5, 1, 1.5, 2} {
+		p, err := NewPlayer(aiff(samples), PlayerOptions{Speed: speed})
+		assert.Nil(t, err)
+		played := playAll(p)
+
+		// as much faster or slower, at the same pitch
+		length := float64(len(played)) / float64(len(samples))
+		assert.InDelta(t, 1/speed, length, 0.02, "speed %v", speed)
+		pitch := float64(crossings(played)) / float64(len(played)) * DefaultSampleRate
+		assert.InDelta(t, 440, pitch, 10, "speed %v", speed)
+	}
+
+	p, _ := NewPlayer(aiff(samples), PlayerOptions{})
+	assert.Equal(t, ErrBadSpeed, p.SetSpeed(0.25))
+	assert.Nil(t, p.SetSpeed(2))
+	assert.Equal(t, 2.0, p.Speed())
+}
@@ -3,7 +3,7 @@ package audio
 import (
 	"code.google.com/p/portaudio-go/portaudio"
 	"encoding/binary"
-	_ "fmt"
+	"math"
 	"os"
 	"time"
 )
@@ -13,7 +13,8 @@ func init() {
 }
 
 const (
-	DefaultSampleRate = 44100
+	DefaultSampleRate   = 44100
+	DefaultSilenceLevel = 0.02
 )
 
 type RecordOptions struct {
@@ -24,6 +25,9 @@ type RecordOptions struct {
 	CallbackInterval int     // default: 4410
 	SampleRate       float64 // default: 44100
 	InputChannels    int     // number of input channels. default: 1
+	Device           string  // the input device, or part of its name. default: the default one
+	Trim             bool    // leave out the silence before and after the sound
+	SilenceLevel     float64 // with Trim, samples below this fraction of full scale are silence. default: 0.02
 }
 
 // Record audio into an AIFF file using PortAudio
@@ -39,72 +43,21 @@ func RecordAIFF(options RecordOptions) error {
 	if options.CallbackInterval <= 0 {
 		options.CallbackInterval = int(options.SampleRate / 10)
 	}
-
-	f, err := os.Create(options.FilePath)
-	if err != nil {
-		return err
+	if options.SilenceLevel <= 0 {
+		options.SilenceLevel = DefaultSilenceLevel
 	}
 
-	// form chunk
-	if _, err = f.WriteString("FORM"); err != nil {
-		return err
-	}
-
-	err = binary.Write(f, binary.BigEndian, int32(0)) //total bytes
+	w, err := newAIFFWriter(options.FilePath, options.SampleRate, options.InputChannels)
 	if err != nil {
 		return err
 	}
-	if _, err = f.WriteString("AIFF"); err != nil {
-		return err
+	if options.Trim {
+		w.trim(options.SilenceLevel)
 	}
+	defer w.close()
 
-	// common chunk
-	if _, err = f.WriteString("COMM"); err != nil {
-		return err
-	}
-	binary.Write(f, binary.BigEndian, int32(18))                       //size
-	binary.Write(f, binary.BigEndian, int16(1))                        //channels
-	binary.Write(f, binary.BigEndian, int32(0))                        //number of samples
-	binary.Write(f, binary.BigEndian, int16(32))                       //bits per sample
-	_, err = f.Write([]byte{0x40, 0x0e, 0xac, 0x44, 0, 0, 0, 0, 0, 0}) //80-bit sample rate 44100
-	if err != nil {
-		return err
-	}
-
-	// sound chunk
-	if _, err = f.WriteString("SSND"); err != nil {
-		return err
-	}
-	binary.Write(f, binary.BigEndian, int32(0)) //size
-	binary.Write(f, binary.BigEndian, int32(0)) //offset
-	binary.Write(f, binary.BigEndian, int32(0)) //block
-	nSamples := 0
-	defer func() {
-		// fill in missing sizes
-		totalBytes := 4 + 8 + 18 + 8 + 8 + 4*nSamples
-		if _, err = f.Seek(4, 0); err != nil {
-			panic(err)
-		}
-		if err := binary.Write(f, binary.BigEndian, int32(totalBytes)); err != nil {
-			panic(err)
-		}
-		if _, err = f.Seek(22, 0); err != nil {
-			panic(err)
-		}
-		if err := binary.Write(f, binary.BigEndian, int32(nSamples)); err != nil {
-			panic(err)
-		}
-		if _, err = f.Seek(42, 0); err != nil {
-			panic(err)
-		}
-		if err := binary.Write(f, binary.BigEndian, int32(4*nSamples+8)); err != nil {
-			panic(err)
-		}
-		f.Close()
-	}()
-
-	in := make([]int32, 64)
-	stream, err := portaudio.OpenDefaultStream(options.InputChannels, 0, options.SampleRate, len(in), in)
+	in := make([]int32, 64*options.InputChannels)
+	stream, err := openStream(options.Device, true, options.InputChannels, options.SampleRate, &in)
 	if err != nil {
 		return err
 	}
@@ -114,41 +67,162 @@ func RecordAIFF(options RecordOptions) error {
 		return err
 	}
 
-	cbSamples := 0 // sample count of last callback
+	nFrames := 0  // recorded, trimmed or not
+	cbFrames := 0 // frame count of last callback
+	maxFrames := int(options.MaxDuration.Seconds() * options.SampleRate)
 
 	for {
 		if err := stream.Read(); err != nil {
 			return err
 		}
-		err = binary.Write(f, binary.BigEndian, in)
-		if err != nil {
+		if err := w.write(in); err != nil {
 			return err
 		}
-		nSamples += len(in)
+		nFrames += len(in) / options.InputChannels
 
 		if options.StopSignal != nil {
 			select {
 			case <-options.StopSignal:
-				return nil
+				return w.close()
 			default:
 			}
 		}
 		if options.Callback != nil {
-			if cbSamples == 0 || nSamples-cbSamples > options.CallbackInterval {
-				options.Callback(nSamples)
-				cbSamples = nSamples
+			if cbFrames == 0 || nFrames-cbFrames > options.CallbackInterval {
+				options.Callback(nFrames)
+				cbFrames = nFrames
 			}
 		}
-		if options.MaxDuration > 0 {
-			if float64(nSamples) > float64(options.MaxDuration/time.Second)*float64(options.SampleRate) {
-				break
-			}
+		if maxFrames > 0 && nFrames >= maxFrames {
+			break
 		}
 	}
 
 	if err = stream.Stop(); err != nil {
 		return err
 	}
+	return w.close()
+}
+
+// aiffWriter writes interleaved samples to an AIFF file as they come,
+// leaving out the silence before and after the sound if asked to.
+type aiffWriter struct {
+	f        *os.File
+	channels int
+	level    int32 // louder than this is sound, 0 to keep everything
+	written  int   // samples written
+	sound    int   // samples written up to the end of the last sound
+	closed   bool
+}
+
+// aiffHeaderSize is the size of the chunks before the samples.
+const aiffHeaderSize = 12 + 8 + 18 + 8 + 8
+
+func newAIFFWriter(p string, rate float64, channels int) (*aiffWriter, error) {
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, err
+	}
+	w := &aiffWriter{f: f, channels: channels}
+
+	// form chunk
+	f.WriteString("FORM")
+	binary.Write(f, binary.BigEndian, int32(0)) //total bytes
+	f.WriteString("AIFF")
+
+	// common chunk
+	f.WriteString("COMM")
+	binary.Write(f, binary.BigEndian, int32(18))       //size
+	binary.Write(f, binary.BigEndian, int16(channels)) //channels
+	binary.Write(f, binary.BigEndian, int32(0))        //number of sample frames
+	binary.Write(f, binary.BigEndian, int16(32))       //bits per sample
+	rateBytes := extended(rate)                        //80-bit sample rate
+	f.Write(rateBytes[:])
+
+	// sound chunk
+	f.WriteString("SSND")
+	binary.Write(f, binary.BigEndian, int32(0))       //size
+	binary.Write(f, binary.BigEndian, int32(0))       //offset
+	err = binary.Write(f, binary.BigEndian, int32(0)) //block
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// trim leaves out the silence before and after the sound, what is below
+// level of full scale.
+func (w *aiffWriter) trim(level float64) {
+	w.level = int32(level * math.MaxInt32)
+}
+
+func (w *aiffWriter) write(in []int32) error {
+	if w.level > 0 {
+		last := -1
+		for i, s := range in {
+			if s > w.level || s < -w.level {
+				last = i
+			}
+		}
+		if w.written == 0 {
+			// nothing said yet, start with the frame of the first sound
+			first := -1
+			for i, s := range in {
+				if s > w.level || s < -w.level {
+					first = i
+					break
+				}
+			}
+			if first < 0 {
+				return nil
+			}
+			first -= first % w.channels
+			in = in[first:]
+			last -= first
+		}
+		if last >= 0 {
+			// up to the end of its frame
+			w.sound = w.written + last - last%w.channels + w.channels
+		}
+	}
+	if err := binary.Write(w.f, binary.BigEndian, in); err != nil {
+		return err
+	}
+	w.written += len(in)
+	return nil
+}
+
+// close fills in the sizes, dropping the silence after the sound if
+// trimming, and closes the file.
+func (w *aiffWriter) close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.f.Close()
 
+	n := w.written
+	if w.level > 0 {
+		n = w.sound
+		if err := w.f.Truncate(int64(aiffHeaderSize + 4*n)); err != nil {
+			return err
+		}
+	}
+	for _, field := range []struct {
+		offset int64
+		value  int32
+	}{
+		{4, int32(aiffHeaderSize - 8 + 4*n)}, //total bytes
+		{22, int32(n / w.channels)},          //number of sample frames
+		{42, int32(4*n + 8)},                 //sound chunk size
+	} {
+		if _, err := w.f.Seek(field.offset, 0); err != nil {
+			return err
+		}
+		if err := binary.Write(w.f, binary.BigEndian, field.value); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -3,6 +3,8 @@ package audio
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"testing"
@@ -46,3 +48,41 @@ func TestRecordAIFF(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, stat2.Size() < 10000)
 }
+
+func TestAIFFWriterTrim(t *testing.T) {
+	p := path.Join(os.TempDir(), "test_trim.aiff")
+	defer os.Remove(p)
+
+	loud := int32(math.MaxInt32 / 2)
+	w, err := newAIFFWriter(p, 22050, 2)
+	assert.Nil(t, err)
+	w.trim(DefaultSilenceLevel)
+	assert.Nil(t, w.write([]int32{0, 0, 0, 0}))
+	assert.Nil(t, w.write([]int32{0, 0, 0, loud, loud, 0, 0, 0})) // starts on the right
+	assert.Nil(t, w.write([]int32{0, 0, loud, loud, 0, 0, 0, 0}))
+	assert.Nil(t, w.write([]int32{0, 0, 0, 0}))
+	assert.Nil(t, w.close())
+
+	content := mustRead(t, p)
+	assert.Equal(t, aiffHeaderSize+4*10, len(content))
+	samples, rate, err := DecodeAIFF(content)
+	assert.Nil(t, err)
+	assert.Equal(t, 22050.0, rate)
+	assert.Equal(t, []int32{loud / 2, loud / 2, 0, 0, loud}, samples)
+
+	// without trimming, everything is kept
+	w, err = newAIFFWriter(p, DefaultSampleRate, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, w.write([]int32{0, loud, 0}))
+	assert.Nil(t, w.close())
+	samples, rate, err = DecodeAIFF(mustRead(t, p))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(DefaultSampleRate), rate)
+	assert.Equal(t, []int32{0, loud, 0}, samples)
+}
+
+func mustRead(t *testing.T, p string) []byte {
+	content, err := ioutil.ReadFile(p)
+	assert.Nil(t, err)
+	return content
+}
@@ -0,0 +1,107 @@
+package audio
+
+import (
+	"math"
+)
+
+const (
+	stretchFrame     = 2048 // samples in a frame, the hop between frames is half of it
+	stretchTolerance = 512  // how far from where it should be a frame may be taken
+)
+
+// stretcher changes the speed of mono audio without changing its pitch.
+// It puts frames back half a frame apart, overlapping, but takes them from
+// the input speed times further apart, each where it best continues the
+// one before (WSOLA).
+type stretcher struct {
+	window []float64
+	tail   []float64 // second half of the last frame, to overlap with the next
+	prev   int       // where the last frame was taken from, -1 after a seek
+}
+
+func newStretcher() *stretcher {
+	s := &stretcher{
+		window: make([]float64, stretchFrame),
+		tail:   make([]float64, stretchFrame/2),
+		prev:   -1,
+	}
+	// a periodic Hann window, half a frame apart its copies add up to 1
+	for i := range s.window {
+		s.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/stretchFrame)
+	}
+	return s
+}
+
+// hop is how many samples next puts out at a time.
+func (s *stretcher) hop() int {
+	return stretchFrame / 2
+}
+
+// reset forgets the last frame, after a seek.
+func (s *stretcher) reset() {
+	for i := range s.tail {
+		s.tail[i] = 0
+	}
+	s.prev = -1
+}
+
+// next puts out hop samples of samples taken around at.
+func (s *stretcher) next(samples []int32, at int, out []float64) {
+	hop := s.hop()
+	start := at
+	if s.prev >= 0 {
+		start = s.bestStart(samples, s.prev+hop, at)
+	}
+	s.prev = start
+
+	for i := 0; i < stretchFrame; i++ {
+		v := sampleAt(samples, start+i) * s.window[i]
+		if i < hop {
+			out[i] = s.tail[i] + v
+		} else {
+			s.tail[i-hop] = v
+		}
+	}
+}
+
+// bestStart finds where around at a frame looks most like what follows the
+// last one, at natural, so that the two join without a click.
+func (s *stretcher) bestStart(samples []int32, natural, at int) int {
+	hop := s.hop()
+	best, bestScore := at, math.Inf(-1)
+	for start := at - stretchTolerance; start <= at+stretchTolerance; start++ {
+		var dot, energy float64
+		for i := 0; i < hop; i += 4 {
+			v := sampleAt(samples, start+i)
+			dot += v * sampleAt(samples, natural+i)
+			energy += v * v
+		}
+		score := dot
+		if energy > 0 {
+			score = dot / math.Sqrt(energy)
+		}
+		if score > bestScore {
+			best, bestScore = start, score
+		}
+	}
+	return best
+}
+
+// sampleAt is samples[i] as a float, silence outside them.
+func sampleAt(samples []int32, i int) float64 {
+	if i < 0 || i >= len(samples) {
+		return 0
+	}
+	return float64(samples[i])
+}
+
+// toSample rounds v to a sample, clipping it.
+func toSample(v float64) int32 {
+	switch {
+	case v >= math.MaxInt32:
+		return math.MaxInt32
+	case v <= math.MinInt32:
+		return math.MinInt32
+	}
+	return int32(math.Floor(v + 0.5))
+}
@@ -0,0 +1,65 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// EncodeWAV writes mono samples, as RecordAIFF records them, as a 16-bit
+// PCM WAV file at rate.
+func EncodeWAV(samples []int32, rate int) []byte {
+	var b bytes.Buffer
+	size := 2 * len(samples)
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, int32(36+size))
+	b.WriteString("WAVE")
+
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, int32(16))     //size
+	binary.Write(&b, binary.LittleEndian, int16(1))      //PCM
+	binary.Write(&b, binary.LittleEndian, int16(1))      //channels
+	binary.Write(&b, binary.LittleEndian, int32(rate))   //sample rate
+	binary.Write(&b, binary.LittleEndian, int32(2*rate)) //bytes per second
+	binary.Write(&b, binary.LittleEndian, int16(2))      //block align
+	binary.Write(&b, binary.LittleEndian, int16(16))     //bits per sample
+
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, int32(size))
+	pcm := make([]int16, len(samples))
+	for i, s := range samples {
+		pcm[i] = int16(s >> 16)
+	}
+	binary.Write(&b, binary.LittleEndian, pcm)
+	return b.Bytes()
+}
+
+// Resample converts samples taken at rate from to rate to.
+func Resample(samples []int32, from, to float64) []int32 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+	n := int(float64(len(samples)) * to / from)
+	out := make([]int32, n)
+	step := from / to
+	for i := range out {
+		// average what falls into the output sample when there's less
+		// room, so higher tones don't fold back
+		start := float64(i) * step
+		if step > 1 {
+			var sum float64
+			count := 0
+			for j := int(start); j < int(start+step) && j < len(samples); j++ {
+				sum += float64(samples[j])
+				count++
+			}
+			if count > 0 {
+				out[i] = toSample(sum / float64(count))
+			}
+			continue
+		}
+		j := int(start)
+		frac := start - float64(j)
+		out[i] = toSample(sampleAt(samples, j)*(1-frac) + sampleAt(samples, j+1)*frac)
+	}
+	return out
+}
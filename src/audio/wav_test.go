@@ -0,0 +1,30 @@
+package audio
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEncodeWAV(t *testing.T) {
+	wav := EncodeWAV([]int32{0, 1 << 30, -1 << 30}, 16000)
+	assert.Equal(t, 44+6, len(wav))
+	assert.Equal(t, "RIFF", string(wav[0:4]))
+	assert.Equal(t, "WAVE", string(wav[8:12]))
+	assert.Equal(t, uint32(16000), binary.LittleEndian.Uint32(wav[24:]))
+	assert.Equal(t, uint16(16), binary.LittleEndian.Uint16(wav[34:]))
+	assert.Equal(t, uint32(6), binary.LittleEndian.Uint32(wav[40:]))
+	assert.Equal(t, int16(1<<14), int16(binary.LittleEndian.Uint16(wav[46:])))
+	assert.Equal(t, int16(-1<<14), int16(binary.LittleEndian.Uint16(wav[48:])))
+}
+
+func TestResample(t *testing.T) {
+	samples := sine(440, 1)
+	down := Resample(samples, DefaultSampleRate, 16000)
+	assert.Equal(t, 16000, len(down))
+	assert.InDelta(t, 440, crossings(down), 2)
+
+	up := Resample(down, 16000, DefaultSampleRate)
+	assert.Equal(t, DefaultSampleRate, len(up))
+	assert.InDelta(t, 440, crossings(up), 2)
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"time"
+)
+
+// ArchiveVersion is the version of the archives written, newer ones can't
+// be read.
+const ArchiveVersion = 1
+
+// Archive describes a mailbox exported to a directory, kept there as
+// manifest.json next to the audio of each message.
+type Archive struct {
+	Version    int                `json:"version"`
+	ExportedAt time.Time          `json:"exported_at"`
+	Owner      *User              `json:"owner"`
+	Messages   []*ArchivedMessage `json:"messages"`
+}
+
+// ArchivedMessage is a message in an archive. File is its audio, decrypted;
+// Ciphertext, if it was kept, is the message as it arrived, still encrypted
+// and signed by its sender.
+type ArchivedMessage struct {
+	ID           int64         `json:"id"`
+	From         *User         `json:"from"`
+	To           *User         `json:"to"`
+	Group        string        `json:"group,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Duration     time.Duration `json:"duration"`
+	Played       bool          `json:"played"`
+	Sealed       bool          `json:"sealed,omitempty"`
+	Verification Verification  `json:"verification,omitempty"`
+	UUID         string        `json:"uuid,omitempty"`
+	ThreadID     string        `json:"thread_id,omitempty"`
+	InReplyTo    string        `json:"in_reply_to,omitempty"`
+	RemoteURL    string        `json:"remote_url,omitempty"`
+
+	File       string `json:"file"`
+	SHA256     string `json:"sha256"` // of File
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+// NewArchivedMessage describes msg, opened, in an archive.
+func NewArchivedMessage(msg *Message) *ArchivedMessage {
+	return &ArchivedMessage{
+		ID:           msg.MessageID,
+		From:         msg.From,
+		To:           msg.To,
+		Group:        msg.Group,
+		CreatedAt:    msg.CreatedAt,
+		Duration:     msg.Duration,
+		Played:       msg.Played,
+		Sealed:       msg.Sealed,
+		Verification: msg.Verification,
+		UUID:         msg.UUID,
+		ThreadID:     msg.ThreadID,
+		InReplyTo:    msg.InReplyTo,
+		RemoteURL:    msg.RemoteURL,
+	}
+}
+
+// Message is the archived message, without its content.
+func (a *ArchivedMessage) Message() *Message {
+	return &Message{
+		From:         a.From,
+		To:           a.To,
+		Group:        a.Group,
+		CreatedAt:    a.CreatedAt,
+		Duration:     a.Duration,
+		Played:       a.Played,
+		Sealed:       a.Sealed,
+		Verification: a.Verification,
+		UUID:         a.UUID,
+		ThreadID:     a.ThreadID,
+		InReplyTo:    a.InReplyTo,
+		RemoteURL:    a.RemoteURL,
+	}
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestArchivedMessage(t *testing.T) {
+	msg := &Message{
+		MessageID:    7,
+		From:         &User{Name: "Bob", Email: "bob@example.com", Key: "B0B"},
+		To:           &User{Name: "Alice", Email: "alice@example.com", Key: "A11CE"},
+		Content:      []byte("secret"),
+		CreatedAt:    time.Date(2015, 1, 24, 10, 0, 0, 0, time.UTC),
+		Duration:     3 * time.Second,
+		Played:       true,
+		Verification: VerifyTrusted,
+		UUID:         "u2",
+		ThreadID:     "u1",
+		InReplyTo:    "u1",
+		RemoteURL:    "https://talkie.example.com/messages/7",
+	}
+	a := NewArchivedMessage(msg)
+	a.File = "7.aiff"
+	d, err := json.Marshal(a)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(d), "secret")
+
+	var back ArchivedMessage
+	assert.Nil(t, json.Unmarshal(d, &back))
+	m := back.Message()
+	assert.Equal(t, int64(0), m.MessageID) // the store gives it a new one
+	assert.Nil(t, m.Content)
+	assert.Equal(t, "bob@example.com", m.From.Email)
+	assert.Equal(t, msg.CreatedAt, m.CreatedAt)
+	assert.Equal(t, msg.Duration, m.Duration)
+	assert.True(t, m.Played)
+	assert.Equal(t, VerifyTrusted, m.Verification)
+	assert.Equal(t, "u2", m.UUID)
+	assert.Equal(t, "u1", m.ThreadID)
+	assert.Equal(t, "u1", m.InReplyTo)
+	assert.Equal(t, msg.RemoteURL, m.RemoteURL)
+
+	// only the archive keeps the verification, a server can't vouch for a message
+	d, err = json.Marshal(msg)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(d), "trusted")
+	var listed Message
+	assert.Nil(t, json.Unmarshal([]byte(`{"id":7,"verification":"trusted"}`), &listed))
+	assert.Equal(t, Verification(""), listed.Verification)
+}
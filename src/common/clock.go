@@ -0,0 +1,18 @@
+package common
+
+import (
+	"time"
+)
+
+// Clock tells the current time. Tests swap in a fake one.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var SystemClock Clock = systemClock{}
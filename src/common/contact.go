@@ -0,0 +1,26 @@
+package common
+
+import (
+	"time"
+)
+
+// Contact is an entry of the local address book: a user under an alias of
+// our choosing.
+type Contact struct {
+	ContactID int64     `json:"id"`
+	Alias     string    `json:"alias"`
+	Key       string    `json:"key"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Favorite  bool      `json:"favorite"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// User returns the user the contact stands for.
+func (c *Contact) User() *User {
+	return &User{
+		Key:   c.Key,
+		Name:  c.Name,
+		Email: c.Email,
+	}
+}
@@ -0,0 +1,15 @@
+package common
+
+import (
+	"time"
+)
+
+// Conversation sums up the messages exchanged with one partner, or in one
+// group.
+type Conversation struct {
+	Partner  *User  // nil for a group
+	Group    string // the group, if the conversation is in one
+	Messages int
+	Unread   int // received but not played yet
+	LastAt   time.Time
+}
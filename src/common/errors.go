@@ -0,0 +1,21 @@
+package common
+
+// Error codes talkie-server puts in the "code" field of a failed response.
+const (
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeInternal         = "internal_error"
+	ErrCodeKeyNotFound      = "key_not_found"
+
+	ErrCodeMessageTooLarge = "message_too_large"
+	ErrCodeMailboxFull     = "mailbox_full"
+	ErrCodeQuotaExceeded   = "quota_exceeded"
+	ErrCodeRateLimited     = "rate_limited"
+	ErrCodeSenderBlocked   = "sender_blocked"
+
+	ErrCodeUploadOffset     = "upload_offset_mismatch"
+	ErrCodeChecksumMismatch = "checksum_mismatch"
+	ErrCodeTooManyUploads   = "too_many_uploads"
+)
@@ -0,0 +1,23 @@
+package common
+
+import (
+	"time"
+)
+
+const (
+	EventMessage = "message" // a new message is waiting, sent to its recipient
+	EventReceipt = "receipt" // a message was downloaded, sent to its sender
+)
+
+// Event is pushed by the server to subscribers. Message never carries content.
+type Event struct {
+	Type      string    `json:"type"`
+	Message   *Message  `json:"message,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SubscribeRequest is what the owner of Key signs to receive its events.
+type SubscribeRequest struct {
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+var groupNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// Group is a mailbox on the server that delivers a copy of every message
+// to each of its members. Only its owner changes who is in it.
+type Group struct {
+	GroupID   int64     `json:"id"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"` // key of the owner
+	Members   []*User   `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GroupRequest is what the owner of a group signs to create, change or
+// delete it. Members replace those the group had.
+type GroupRequest struct {
+	Group
+	Remove    bool      `json:"remove,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidGroupName reports whether name may name a group: up to 32 lower
+// case letters, digits, dots, dashes and underscores.
+func ValidGroupName(name string) bool {
+	return groupNamePattern.MatchString(name)
+}
+
+// Includes reports whether key owns the group or is one of its members.
+func (g *Group) Includes(key string) bool {
+	if strings.EqualFold(g.Owner, key) {
+		return true
+	}
+	for _, m := range g.Members {
+		if strings.EqualFold(m.Key, key) {
+			return true
+		}
+	}
+	return false
+}
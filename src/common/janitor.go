@@ -0,0 +1,98 @@
+package common
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	DefaultJanitorInterval = time.Minute
+)
+
+type JanitorOptions struct {
+	Retention time.Duration // purge messages older than this. default: 0, keep forever
+	Interval  time.Duration // time between purges. default: 1 minute
+	Clock     Clock         // default: SystemClock
+}
+
+// Janitor deletes expired messages, played burn-after-playing ones, and
+// messages older than the retention limit, from a Store.
+type Janitor struct {
+	store   Store
+	options JanitorOptions
+	stop    chan int
+}
+
+func NewJanitor(store Store, options *JanitorOptions) *Janitor {
+	var opts JanitorOptions
+	if options != nil {
+		opts = *options
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultJanitorInterval
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	return &Janitor{
+		store:   store,
+		options: opts,
+	}
+}
+
+// Purge deletes all messages that are expired right now and returns how many
+// were removed.
+func (j *Janitor) Purge() (int, error) {
+	now := j.options.Clock.Now()
+	var createdBefore time.Time
+	if j.options.Retention > 0 {
+		createdBefore = now.Add(-j.options.Retention)
+	}
+
+	messages, err := j.store.GetExpiredMessages(now, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, msg := range messages {
+		if err := j.store.DeleteMessage(msg.MessageID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Start runs Purge every Interval in a background goroutine until Stop is called.
+func (j *Janitor) Start() {
+	if j.stop != nil {
+		return
+	}
+	j.stop = make(chan int)
+	go j.run(j.stop)
+}
+
+func (j *Janitor) Stop() {
+	if j.stop != nil {
+		close(j.stop)
+		j.stop = nil
+	}
+}
+
+func (j *Janitor) run(stop chan int) {
+	ticker := time.NewTicker(j.options.Interval)
+	defer ticker.Stop()
+	for {
+		if n, err := j.Purge(); err != nil {
+			log.Printf("janitor: %s", err.Error())
+		} else if n > 0 {
+			log.Printf("janitor: purged %d messages", n)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
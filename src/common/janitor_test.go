@@ -0,0 +1,123 @@
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestJanitorPurgeExpired(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	users, err := createRandomUsers(store, 2)
+	assert.Nil(t, err)
+
+	clock := &fakeClock{now: time.Date(2015, 1, 24, 12, 0, 0, 0, time.UTC)}
+
+	short := &Message{From: users[0], To: users[1], CreatedAt: clock.Now(), ExpiresAt: clock.Now().Add(time.Hour)}
+	long := &Message{From: users[0], To: users[1], CreatedAt: clock.Now(), ExpiresAt: clock.Now().Add(24 * time.Hour)}
+	forever := &Message{From: users[0], To: users[1], CreatedAt: clock.Now()}
+	for _, m := range []*Message{short, long, forever} {
+		assert.Nil(t, store.AddMessage(m))
+	}
+
+	janitor := NewJanitor(store, &JanitorOptions{Clock: clock})
+
+	n, err := janitor.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	clock.Advance(time.Hour)
+	n, err = janitor.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = store.GetMessage(short.MessageID)
+	assert.Equal(t, ErrNoResult, err)
+
+	clock.Advance(30 * 24 * time.Hour)
+	n, err = janitor.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	m, err := store.GetMessage(forever.MessageID)
+	assert.Nil(t, err)
+	assert.NotNil(t, m)
+}
+
+func TestJanitorPurgeBurnt(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	users, err := createRandomUsers(store, 2)
+	assert.Nil(t, err)
+
+	clock := &fakeClock{now: time.Date(2015, 1, 24, 12, 0, 0, 0, time.UTC)}
+
+	burn := &Message{From: users[0], To: users[1], CreatedAt: clock.Now(), BurnAfterPlaying: true}
+	kept := &Message{From: users[0], To: users[1], CreatedAt: clock.Now()}
+	for _, m := range []*Message{burn, kept} {
+		assert.Nil(t, store.AddMessage(m))
+	}
+
+	janitor := NewJanitor(store, &JanitorOptions{Clock: clock})
+	n, err := janitor.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// gone once played
+	assert.Nil(t, store.UpdateMessagePlayed(burn.MessageID, true))
+	assert.Nil(t, store.UpdateMessagePlayed(kept.MessageID, true))
+	n, err = janitor.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = store.GetMessage(burn.MessageID)
+	assert.Equal(t, ErrNoResult, err)
+	_, err = store.GetMessage(kept.MessageID)
+	assert.Nil(t, err)
+}
+
+func TestJanitorRetention(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	users, err := createRandomUsers(store, 2)
+	assert.Nil(t, err)
+
+	clock := &fakeClock{now: time.Date(2015, 1, 24, 12, 0, 0, 0, time.UTC)}
+
+	old := &Message{From: users[0], To: users[1], CreatedAt: clock.Now().Add(-8 * 24 * time.Hour)}
+	recent := &Message{From: users[0], To: users[1], CreatedAt: clock.Now().Add(-time.Hour)}
+	for _, m := range []*Message{old, recent} {
+		assert.Nil(t, store.AddMessage(m))
+	}
+
+	janitor := NewJanitor(store, &JanitorOptions{Clock: clock, Retention: 7 * 24 * time.Hour})
+	n, err := janitor.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = store.GetMessage(old.MessageID)
+	assert.Equal(t, ErrNoResult, err)
+
+	m, err := store.GetMessage(recent.MessageID)
+	assert.Nil(t, err)
+	assert.NotNil(t, m)
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"time"
+)
+
+// KeyRegistration registers a user together with its public key. Request
+// is a clearsigned RegisterRequest, signed by that key.
+type KeyRegistration struct {
+	PublicKey []byte `json:"public_key"`
+	Request   string `json:"request"`
+}
+
+// RegisterRequest is what a user signs to register its key.
+type RegisterRequest struct {
+	User      User      `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PublicKey is a key found for an email address.
+type PublicKey struct {
+	Key         string `json:"key"` // short key ID, as in User.Key
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Fingerprint string `json:"fingerprint"`
+	Data        []byte `json:"data,omitempty"` // the key as gpg exports it, armored or not
+}
+
+// User returns the user the key belongs to.
+func (k *PublicKey) User() *User {
+	return &User{
+		Key:   k.Key,
+		Name:  k.Name,
+		Email: k.Email,
+	}
+}
@@ -0,0 +1,15 @@
+package common
+
+import (
+	"time"
+)
+
+// KeyPin is the key first seen for an email, trusted on first use. A key
+// that differs from the pin is refused until the user verifies it.
+type KeyPin struct {
+	Email       string    `json:"email"`
+	Fingerprint string    `json:"fingerprint"`
+	FirstSeen   time.Time `json:"first_seen"`
+	// Verified is set once the user compared safety numbers out of band
+	Verified bool `json:"verified"`
+}
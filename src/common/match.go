@@ -0,0 +1,102 @@
+package common
+
+import (
+	"sort"
+	"strings"
+)
+
+// Where a Candidate was found, most trusted first.
+const (
+	SourceContact = iota
+	SourceKnown   // users talked to before
+	SourceKeyring
+	SourceDirectory // the talkie server or a Web Key Directory
+)
+
+// How well a query matches a field.
+const (
+	MatchNone      = 0
+	MatchSubstring = 20
+	MatchWord      = 40 // the start of a word, such as a last name
+	MatchPrefix    = 60
+	MatchExact     = 100
+)
+
+// Candidate is a user a recipient query may mean.
+type Candidate struct {
+	User   *User
+	Alias  string // contact alias, if any
+	Source int
+	Score  int
+}
+
+// MatchScore rates how well query matches the best of fields, ignoring case.
+// Key IDs match fingerprints by suffix, so pass keys as fields too.
+func MatchScore(query string, fields ...string) int {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return MatchNone
+	}
+	best := MatchNone
+	for _, field := range fields {
+		field = strings.ToLower(field)
+		score := MatchNone
+		switch i := strings.Index(field, query); {
+		case field == "":
+		case field == query:
+			score = MatchExact
+		case i == 0:
+			score = MatchPrefix
+		case i > 0 && strings.ContainsRune(" .@-_<", rune(field[i-1])):
+			score = MatchWord
+		case i > 0:
+			score = MatchSubstring
+		}
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// RankCandidates drops candidates without a score and duplicates of a key,
+// keeping the best one, and sorts the rest best first. Ties go to the more
+// trusted source, then by name, so the order is always the same.
+func RankCandidates(candidates []*Candidate) []*Candidate {
+	byKey := make(map[string]*Candidate)
+	var ranked []*Candidate
+	for _, c := range candidates {
+		if c.Score <= MatchNone || c.User == nil {
+			continue
+		}
+		key := strings.ToUpper(c.User.Key)
+		if prev, ok := byKey[key]; ok {
+			if better(c, prev) {
+				*prev = *c
+			}
+			continue
+		}
+		byKey[key] = c
+		ranked = append(ranked, c)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return better(ranked[i], ranked[j])
+	})
+	return ranked
+}
+
+func better(a, b *Candidate) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if a.Source != b.Source {
+		return a.Source < b.Source
+	}
+	if a.Alias != b.Alias {
+		return a.Alias < b.Alias
+	}
+	if a.User.Name != b.User.Name {
+		return a.User.Name < b.User.Name
+	}
+	return a.User.Key < b.User.Key
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatchScore(t *testing.T) {
+	assert.Equal(t, MatchExact, MatchScore("Bob", "bob"))
+	assert.Equal(t, MatchPrefix, MatchScore("bob", "Bobby Tables"))
+	assert.Equal(t, MatchWord, MatchScore("tab", "Bobby Tables"))
+	assert.Equal(t, MatchWord, MatchScore("example", "bob@example.com"))
+	assert.Equal(t, MatchSubstring, MatchScore("obb", "Bobby Tables"))
+	assert.Equal(t, MatchNone, MatchScore("carol", "Bobby Tables", "bob@example.com"))
+	assert.Equal(t, MatchNone, MatchScore("", "bob"))
+	// the best field counts
+	assert.Equal(t, MatchExact, MatchScore("bob@example.com", "Bobby Tables", "bob@example.com"))
+}
+
+func TestRankCandidates(t *testing.T) {
+	bob := &User{Key: "BBBB2222", Name: "Bob", Email: "bob@example.com"}
+	bobby := &User{Key: "BBBB3333", Name: "Bobby Tables", Email: "bobby@example.com"}
+	rob := &User{Key: "CCCC4444", Name: "Rob Bobson", Email: "rob@example.com"}
+
+	ranked := RankCandidates([]*Candidate{
+		{User: rob, Source: SourceKeyring, Score: MatchWord},
+		{User: bobby, Source: SourceKeyring, Score: MatchPrefix},
+		{User: bob, Source: SourceKeyring, Score: MatchPrefix},
+		// the same key as a contact
+		{User: bob, Alias: "bob", Source: SourceContact, Score: MatchExact},
+		{User: &User{Key: "DDDD5555", Name: "Dave"}, Source: SourceKnown, Score: MatchNone},
+	})
+	if assert.Equal(t, 3, len(ranked)) {
+		assert.Equal(t, "bob", ranked[0].Alias)
+		assert.Equal(t, MatchExact, ranked[0].Score)
+		assert.Equal(t, bobby, ranked[1].User)
+		assert.Equal(t, rob, ranked[2].User)
+	}
+
+	// ties are broken the same way whatever the order
+	a := RankCandidates([]*Candidate{
+		{User: bobby, Source: SourceKeyring, Score: MatchPrefix},
+		{User: bob, Source: SourceKeyring, Score: MatchPrefix},
+	})
+	b := RankCandidates([]*Candidate{
+		{User: bob, Source: SourceKeyring, Score: MatchPrefix},
+		{User: bobby, Source: SourceKeyring, Score: MatchPrefix},
+	})
+	assert.Equal(t, a[0].User, b[0].User)
+	assert.Equal(t, bob, a[0].User)
+}
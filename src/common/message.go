@@ -5,15 +5,59 @@ import (
 )
 
 type Message struct {
-	MessageID int64         `json:"id"`
-	From      *User         `json:"from"`
-	To        *User         `json:"to"`
-	Content   []byte        `json:"content,omitempty"`
-	CreatedAt time.Time     `json:"created_at"`
-	Duration  time.Duration `json:"duration"`
-	Played    bool          `json:"played"`
-	Path      string        `json:"path"`
-	RemoteURL string        `json:"remote_url"`
+	MessageID        int64         `json:"id"`
+	From             *User         `json:"from"`
+	To               *User         `json:"to"`
+	Content          []byte        `json:"content,omitempty"`
+	CreatedAt        time.Time     `json:"created_at"`
+	Duration         time.Duration `json:"duration"`
+	Played           bool          `json:"played"`
+	Path             string        `json:"path"`
+	RemoteURL        string        `json:"remote_url"`
+	ExpiresAt        time.Time     `json:"expires_at"`          // zero means never
+	BurnAfterPlaying bool          `json:"burn_after_playing"`  // self-destruct once the recipient played it
+	Sealed           bool          `json:"sealed,omitempty"`    // sender and metadata are inside Content, see SealedContent
+	Verification     Verification  `json:"-"`                   // checked by the recipient, never taken from the server
+	Group            string        `json:"group,omitempty"`     // sent to this group rather than to To alone
+	Signature        string        `json:"signature,omitempty"` // SendRequest clearsigned by From, for the server only
+
+	// where the message belongs in a conversation; these travel inside the
+	// encrypted content, see Payload, and are only known to the two ends
+	UUID      string `json:"-"`
+	ThreadID  string `json:"-"`
+	InReplyTo string `json:"-"` // UUID of the message this one answers
+
+	// what was said, encrypted to the recipient; only ever kept locally
+	Transcript []byte `json:"-"`
+}
+
+// DeleteRequest is what the recipient of a message signs to delete it from
+// its mailbox.
+type DeleteRequest struct {
+	Key       string    `json:"key"`
+	MessageID int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SendRequest is what the sender of a message signs for the server to know
+// who it comes from. It names the recipient or group and the SHA-256 of the
+// content, so it is good for that message only.
+type SendRequest struct {
+	From      string    `json:"from"`
+	To        string    `json:"to,omitempty"`
+	Group     string    `json:"group,omitempty"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReceiptRequest is what the recipient of a message signs once it has
+// played the message, to send its sender a receipt and burn the message if
+// it asked for that.
+type ReceiptRequest struct {
+	Key       string    `json:"key"`
+	MessageID int64     `json:"id"`
+	Played    bool      `json:"played"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 func NewMessage(from, to *User) *Message {
@@ -22,3 +66,8 @@ func NewMessage(from, to *User) *Message {
 		To:   to,
 	}
 }
+
+// Expired reports whether the message is past its expiry time at now.
+func (m *Message) Expired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt)
+}
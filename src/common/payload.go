@@ -0,0 +1,44 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+// Payload is what the encrypted content of a message holds: the audio,
+// and where the message belongs in a conversation. Older clients encrypt
+// the bare audio instead.
+type Payload struct {
+	UUID      string `json:"uuid,omitempty"`
+	ThreadID  string `json:"thread_id,omitempty"`
+	InReplyTo string `json:"in_reply_to,omitempty"`
+	Content   []byte `json:"content"` // the audio
+}
+
+// NewPayload returns the payload of msg, whose content is the plain audio.
+func NewPayload(msg *Message) *Payload {
+	return &Payload{
+		UUID:      msg.UUID,
+		ThreadID:  msg.ThreadID,
+		InReplyTo: msg.InReplyTo,
+		Content:   msg.Content,
+	}
+}
+
+// ParsePayload reads decrypted content, a Payload or bare audio.
+func ParsePayload(d []byte) *Payload {
+	// audio never starts with a brace, AIFF starts with FORM
+	if len(d) > 0 && d[0] == '{' {
+		var p Payload
+		if err := json.Unmarshal(d, &p); err == nil {
+			return &p
+		}
+	}
+	return &Payload{Content: d}
+}
+
+// Apply fills the conversation fields of msg from the payload.
+func (p *Payload) Apply(msg *Message) {
+	msg.UUID = p.UUID
+	msg.ThreadID = p.ThreadID
+	msg.InReplyTo = p.InReplyTo
+}
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParsePayload(t *testing.T) {
+	msg := &Message{UUID: "u2", ThreadID: "u1", InReplyTo: "u1", Content: []byte("FORM....AIFF")}
+	d, err := json.Marshal(NewPayload(msg))
+	assert.Nil(t, err)
+
+	var m Message
+	p := ParsePayload(d)
+	p.Apply(&m)
+	assert.Equal(t, msg.Content, p.Content)
+	assert.Equal(t, "u2", m.UUID)
+	assert.Equal(t, "u1", m.ThreadID)
+	assert.Equal(t, "u1", m.InReplyTo)
+
+	// older clients send the bare audio
+	p = ParsePayload([]byte("FORM....AIFF"))
+	assert.Equal(t, []byte("FORM....AIFF"), p.Content)
+	assert.Equal(t, "", p.ThreadID)
+	p = ParsePayload([]byte("{not json"))
+	assert.Equal(t, []byte("{not json"), p.Content)
+}
@@ -0,0 +1,15 @@
+package common
+
+import (
+	"time"
+)
+
+// SealedContent is what the content of a sealed message holds once
+// decrypted. The server only gets to see the recipient, everything about
+// the sender stays inside the signed and encrypted payload.
+type SealedContent struct {
+	From      *User         `json:"from"`
+	CreatedAt time.Time     `json:"created_at"`
+	Duration  time.Duration `json:"duration"`
+	Payload
+}
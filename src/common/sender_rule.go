@@ -0,0 +1,45 @@
+package common
+
+import (
+	"strings"
+	"time"
+)
+
+// SealedSender is the sender of sealed messages in sender rules: the server
+// can't tell who sent them, so they are blocked or allowed all together.
+const SealedSender = "sealed"
+
+// SenderRule lets the owner of a mailbox block a sender, or allow it when the
+// owner only accepts messages from an allow list.
+type SenderRule struct {
+	Owner  string `json:"owner"`
+	Sender string `json:"sender"`
+	Allow  bool   `json:"allow"`
+}
+
+// SenderRuleRequest is what a mailbox owner signs to change its rules.
+type SenderRuleRequest struct {
+	SenderRule
+	Remove    bool      `json:"remove,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SenderAllowed reports whether rules let sender deliver a message. Block
+// rules always win; once any allow rule exists only allowed senders get through.
+// Keys are compared whatever their case.
+func SenderAllowed(rules []*SenderRule, sender string) bool {
+	allowList := false
+	allowed := false
+	for _, r := range rules {
+		matches := sender != "" && strings.EqualFold(r.Sender, sender)
+		if r.Allow {
+			allowList = true
+			if matches {
+				allowed = true
+			}
+		} else if matches {
+			return false
+		}
+	}
+	return !allowList || allowed
+}
@@ -1,16 +1,49 @@
 package common
 
+import (
+	"time"
+)
+
 type Store interface {
 	AddUser(user *User) error
 	FindUser(userID int64) (*User, error)
 	FindUserByName(name string) ([]*User, error)
 	FindUserByKey(key string) (*User, error)
+	FindUsersByEmail(email string) ([]*User, error)
+	SearchUsers(query string) ([]*User, error)
+	RenameKey(oldKey, newKey string) error
 	GetUserMessages(key string) ([]*Message, error)
+	GetMailboxUsage(key string) (count int, size int64, err error)
 
 	AddMessage(msg *Message) error
 	UpdateMessagePlayed(msgID int64, played bool) error
+	UpdateMessageVerification(msgID int64, v Verification) error
+	UpdateMessageTranscript(msgID int64, transcript []byte) error
 	DeleteMessage(msgID int64) error
 	GetMessage(msgID int64) (*Message, error)
+	GetThread(threadID string) ([]*Message, error)
+	FindMessageByRemoteURL(url string) (*Message, error)
+	GetConversations(key string) ([]*Conversation, error)
+	GetExpiredMessages(now time.Time, createdBefore time.Time) ([]*Message, error)
+
+	SetSenderRule(rule *SenderRule) error
+	DeleteSenderRule(owner, sender string) error
+	GetSenderRules(owner string) ([]*SenderRule, error)
+
+	SetKeyPin(pin *KeyPin) error
+	FindKeyPin(email string) (*KeyPin, error)
+
+	SetGroup(group *Group) error
+	DeleteGroup(name string) error
+	FindGroup(name string) (*Group, error)
+	GetGroupsOf(key string) ([]*Group, error)
+
+	AddContact(contact *Contact) error
+	UpdateContact(contact *Contact) error
+	DeleteContact(alias string) error
+	FindContact(alias string) (*Contact, error)
+	GetContacts() ([]*Contact, error)
+	SearchContacts(query string) ([]*Contact, error)
 
 	Close()
 }
@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
+	"math"
 	"os"
 	"path"
 	"strings"
@@ -38,27 +40,124 @@ var (
 		"duration" INTEGER,
 		"content" TEXT,
 		"created_at" TEXT,
-		"played" INTEGER
+		"played" INTEGER,
+		"expires_at" INTEGER DEFAULT 0,
+		"burn_after_playing" INTEGER DEFAULT 0,
+		"sealed" INTEGER DEFAULT 0,
+		"verification" TEXT DEFAULT '',
+		"group" TEXT DEFAULT '',
+		"uuid" TEXT DEFAULT '',
+		"thread_id" TEXT DEFAULT '',
+		"in_reply_to" TEXT DEFAULT '',
+		"remote_url" TEXT DEFAULT '',
+		"transcript" TEXT DEFAULT ''
+		);`
+	createSenderRulesTableStmt = `CREATE TABLE IF NOT EXISTS sender_rules (
+		"owner" TEXT NOT NULL,
+		"sender" TEXT NOT NULL,
+		"allow" INTEGER NOT NULL
+		); CREATE UNIQUE INDEX IF NOT EXISTS sender_rules_idx1 ON sender_rules(owner, sender);`
+	createContactsTableStmt = `CREATE TABLE IF NOT EXISTS contacts (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"alias" TEXT NOT NULL COLLATE NOCASE,
+		"key" TEXT NOT NULL,
+		"name" TEXT,
+		"email" TEXT,
+		"favorite" INTEGER DEFAULT 0,
+		"created_at" TEXT
+		); CREATE UNIQUE INDEX IF NOT EXISTS contacts_idx1 ON contacts(alias);`
+	createGroupsTableStmt = `CREATE TABLE IF NOT EXISTS groups (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"name" TEXT NOT NULL COLLATE NOCASE,
+		"owner" TEXT NOT NULL,
+		"created_at" TEXT
+		); CREATE UNIQUE INDEX IF NOT EXISTS groups_idx1 ON groups(name);
+		CREATE TABLE IF NOT EXISTS group_members (
+		"group_id" INTEGER NOT NULL,
+		"key" TEXT NOT NULL
+		); CREATE UNIQUE INDEX IF NOT EXISTS group_members_idx1 ON group_members(group_id, key);`
+	createKeyPinsTableStmt = `CREATE TABLE IF NOT EXISTS key_pins (
+		"email" TEXT NOT NULL PRIMARY KEY COLLATE NOCASE,
+		"fingerprint" TEXT NOT NULL,
+		"first_seen" TEXT,
+		"verified" INTEGER DEFAULT 0
 		);`
 
+	// columns added after the first release, created on old databases by migrate()
+	messagesColumns = []struct{ name, decl string }{
+		{"expires_at", "INTEGER DEFAULT 0"},
+		{"burn_after_playing", "INTEGER DEFAULT 0"},
+		{"sealed", "INTEGER DEFAULT 0"},
+		{"verification", "TEXT DEFAULT ''"},
+		{"group", "TEXT DEFAULT ''"},
+		{"uuid", "TEXT DEFAULT ''"},
+		{"thread_id", "TEXT DEFAULT ''"},
+		{"in_reply_to", "TEXT DEFAULT ''"},
+		{"remote_url", "TEXT DEFAULT ''"},
+		{"transcript", "TEXT DEFAULT ''"},
+	}
+
 	insertUserStmt         = `INSERT OR REPLACE INTO users (name, email, key) VALUES (?, ?, ?)`
 	updateUserStmt         = `INSERT OR REPLACE INTO users (name, email, key) VALUES (?, ?, ?)`
 	selectUserStmt         = `SELECT id, name, email, key FROM users WHERE id = ?`
 	selectUserByKeyStmt    = `SELECT id, name, email, key FROM users WHERE key = ?`
-	selectUserMessagesStmt = `SELECT id, "from", "to", "duration", "created_at", "played" FROM messages WHERE "to" = ?`
+	selectUserMessagesStmt = `SELECT id, "from", "to", "duration", "created_at", "played", "expires_at", "burn_after_playing", "sealed", "verification", "group", "uuid", "thread_id", "in_reply_to", "remote_url" FROM messages WHERE "to" = ?`
+	selectMailboxUsageStmt = `SELECT COUNT(*), IFNULL(SUM(LENGTH(RTRIM("content", '=')) * 3 / 4), 0) FROM messages WHERE "to" = ? AND NOT IFNULL("played", 0)`
 	selectUserByNameStmt   = `SELECT id, name, email, key FROM users WHERE name = ?`
-	deleteUserStmt         = `DELETE users WHERE id = ?`
-	deleteUserByKeyStmt    = `DELETE users WHERE key = ?`
+	selectUsersByEmailStmt = `SELECT id, name, email, key FROM users WHERE email = ? COLLATE NOCASE`
+	searchUsersStmt        = `SELECT id, name, email, key FROM users WHERE name LIKE ?1 ESCAPE '\' OR email LIKE ?1 ESCAPE '\' OR key LIKE ?1 ESCAPE '\' ORDER BY name, key`
+	deleteUserStmt         = `DELETE FROM users WHERE id = ?`
+	deleteUserByKeyStmt    = `DELETE FROM users WHERE key = ?`
+
+	// what RenameKey updates, ?1 is the new key and ?2 the old one
+	renameKeyStmts = []string{
+		`UPDATE messages SET "from" = ?1 WHERE "from" = ?2`,
+		`UPDATE messages SET "to" = ?1 WHERE "to" = ?2`,
+		`UPDATE contacts SET "key" = ?1 WHERE "key" = ?2`,
+		`UPDATE groups SET "owner" = ?1 WHERE "owner" = ?2`,
+		`UPDATE OR IGNORE group_members SET "key" = ?1 WHERE "key" = ?2`,
+		`UPDATE OR IGNORE sender_rules SET "owner" = ?1 WHERE "owner" = ?2`,
+	}
 
-	insertMessageStmt       = `INSERT OR REPLACE INTO messages ("from", "to", "duration", "content", "created_at", "played") VALUES (?, ?, ?, ?, ?, ?)`
-	selectMessageStmt       = `SELECT id, "from", "to", "duration", "content", "created_at", "played" FROM messages WHERE id = ?`
-	deleteMessageStmt       = `DELETE messages WHERE id = ?`
-	updateMessagePlayedStmt = `UPDATE messages SET played = ? WHERE id = ?`
+	insertMessageStmt         = `INSERT OR REPLACE INTO messages ("from", "to", "duration", "content", "created_at", "played", "expires_at", "burn_after_playing", "sealed", "verification", "group", "uuid", "thread_id", "in_reply_to", "remote_url") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	selectMessageStmt         = `SELECT id, "from", "to", "duration", "content", "created_at", "played", "expires_at", "burn_after_playing", "sealed", "verification", "group", "uuid", "thread_id", "in_reply_to", "remote_url", "transcript" FROM messages WHERE id = ?`
+	selectThreadStmt          = `SELECT id, "from", "to", "duration", "created_at", "played", "expires_at", "burn_after_playing", "sealed", "verification", "group", "uuid", "thread_id", "in_reply_to", "remote_url" FROM messages WHERE "thread_id" = ? ORDER BY julianday("created_at"), id`
+	selectMessageByURLStmt    = `SELECT id, "from", "to", "duration", "created_at", "played", "expires_at", "burn_after_playing", "sealed", "verification", "group", "uuid", "thread_id", "in_reply_to", "remote_url" FROM messages WHERE "remote_url" = ?`
+	selectConversationsStmt   = `SELECT CASE WHEN "group" != '' THEN '' WHEN "from" = ?1 THEN "to" ELSE "from" END AS partner, "group", COUNT(*), SUM("to" = ?1 AND NOT "played"), MAX(julianday("created_at")) AS last FROM messages WHERE "from" = ?1 OR "to" = ?1 GROUP BY partner, "group" ORDER BY last DESC`
+	deleteMessageStmt         = `DELETE FROM messages WHERE id = ?`
+	updateMessagePlayedStmt   = `UPDATE messages SET played = ? WHERE id = ?`
+	updateVerificationStmt    = `UPDATE messages SET verification = ? WHERE id = ?`
+	updateTranscriptStmt      = `UPDATE messages SET transcript = ? WHERE id = ?`
+	insertSenderRuleStmt      = `INSERT OR REPLACE INTO sender_rules ("owner", "sender", "allow") VALUES (?, ?, ?)`
+	deleteSenderRuleStmt      = `DELETE FROM sender_rules WHERE "owner" = ? AND "sender" = ?`
+	selectSenderRulesStmt     = `SELECT "owner", "sender", "allow" FROM sender_rules WHERE "owner" = ?`
+	insertKeyPinStmt          = `INSERT OR REPLACE INTO key_pins ("email", "fingerprint", "first_seen", "verified") VALUES (?, ?, ?, ?)`
+	selectKeyPinStmt          = `SELECT "email", "fingerprint", "first_seen", "verified" FROM key_pins WHERE "email" = ?`
+	insertGroupStmt           = `INSERT INTO groups ("name", "owner", "created_at") VALUES (?, ?, ?)`
+	updateGroupStmt           = `UPDATE groups SET "owner" = ? WHERE id = ?`
+	deleteGroupStmt           = `DELETE FROM groups WHERE id = ?`
+	selectGroupStmt           = `SELECT id, "name", "owner", "created_at" FROM groups WHERE "name" = ?`
+	selectGroupsOfStmt        = `SELECT id, "name", "owner", "created_at" FROM groups WHERE "owner" = ?1 OR id IN (SELECT group_id FROM group_members WHERE "key" = ?1) ORDER BY "name"`
+	insertGroupMemberStmt     = `INSERT OR IGNORE INTO group_members ("group_id", "key") VALUES (?, ?)`
+	deleteGroupMembersStmt    = `DELETE FROM group_members WHERE group_id = ?`
+	selectGroupMembersStmt    = `SELECT "key" FROM group_members WHERE group_id = ? ORDER BY rowid`
+	insertContactStmt         = `INSERT INTO contacts ("alias", "key", "name", "email", "favorite", "created_at") VALUES (?, ?, ?, ?, ?, ?)`
+	updateContactStmt         = `UPDATE contacts SET "alias" = ?, "key" = ?, "name" = ?, "email" = ?, "favorite" = ? WHERE id = ?`
+	deleteContactStmt         = `DELETE FROM contacts WHERE "alias" = ?`
+	selectContactStmt         = `SELECT id, "alias", "key", "name", "email", "favorite", "created_at" FROM contacts WHERE "alias" = ?`
+	selectContactsStmt        = `SELECT id, "alias", "key", "name", "email", "favorite", "created_at" FROM contacts ORDER BY "favorite" DESC, "alias"`
+	searchContactsStmt        = `SELECT id, "alias", "key", "name", "email", "favorite", "created_at" FROM contacts WHERE "alias" LIKE ?1 ESCAPE '\' OR "name" LIKE ?1 ESCAPE '\' OR "email" LIKE ?1 ESCAPE '\' OR "key" LIKE ?1 ESCAPE '\' ORDER BY "favorite" DESC, "alias"`
+	selectExpiredMessagesStmt = `SELECT id, "from", "to", "duration", "created_at", "played", "expires_at", "burn_after_playing", "sealed", "verification", "group", "uuid", "thread_id", "in_reply_to", "remote_url" FROM messages WHERE ("expires_at" > 0 AND "expires_at" <= ?) OR julianday("created_at") <= julianday(?) OR ("burn_after_playing" AND IFNULL("played", 0))`
 
 	ErrDBNotOpen      = errors.New("db not open")
 	ErrNoResult       = errors.New("no result")
 	ErrInvalidUser    = errors.New("invalid user")
 	ErrInvalidMessage = errors.New("invalid message")
+	ErrInvalidRule    = errors.New("invalid rule")
+	ErrInvalidKeyPin  = errors.New("invalid key pin")
+	ErrInvalidContact = errors.New("invalid contact")
+	ErrInvalidGroup   = errors.New("invalid group")
+	ErrContactExists  = errors.New("contact alias already taken")
 )
 
 func NewStoreSqlite(options *SqliteStoreOptions) *StoreSqlite {
@@ -77,10 +176,56 @@ func NewStoreSqlite(options *SqliteStoreOptions) *StoreSqlite {
 	if _, err = db.Exec(createMessagesTableStmt); err != nil {
 		panic(err)
 	}
+	if _, err = db.Exec(createSenderRulesTableStmt); err != nil {
+		panic(err)
+	}
+	if _, err = db.Exec(createKeyPinsTableStmt); err != nil {
+		panic(err)
+	}
+	if _, err = db.Exec(createContactsTableStmt); err != nil {
+		panic(err)
+	}
+	if _, err = db.Exec(createGroupsTableStmt); err != nil {
+		panic(err)
+	}
 
-	return &StoreSqlite{
+	s := &StoreSqlite{
 		db: db,
 	}
+	if err = s.migrate(); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// migrate adds columns missing from tables created by an older version.
+func (s *StoreSqlite) migrate() error {
+	rows, err := s.db.Query(`PRAGMA table_info(messages)`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, typ string
+		var dflt interface{}
+		if err := rows.Scan(&cid, &name, &typ, &notnull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	rows.Close()
+
+	for _, col := range messagesColumns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE messages ADD COLUMN "%s" %s`, col.name, col.decl)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *StoreSqlite) Close() {
@@ -113,6 +258,27 @@ func (s *StoreSqlite) AddUser(user *User) error {
 	return nil
 }
 
+// RenameKey makes the messages, contacts, groups and rules of oldKey those of
+// newKey, e.g. when a user once known by a key ID is known by its
+// fingerprint.
+func (s *StoreSqlite) RenameKey(oldKey, newKey string) error {
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range renameKeyStmts {
+		if _, err := tx.Exec(stmt, newKey, oldKey); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (s *StoreSqlite) FindUser(userID int64) (*User, error) {
 	if s.db == nil {
 		return nil, ErrDBNotOpen
@@ -193,6 +359,27 @@ func (s *StoreSqlite) GetUserMessages(key string) ([]*Message, error) {
 	return messages, nil
 }
 
+// GetMailboxUsage returns the number of messages waiting for key, those not
+// played yet, and the total size of their content in bytes.
+func (s *StoreSqlite) GetMailboxUsage(key string) (int, int64, error) {
+	if s.db == nil {
+		return 0, 0, ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(selectMailboxUsageStmt)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+
+	// content is stored base64 encoded, the query counts the decoded bytes
+	var count int
+	var size int64
+	if err := stmt.QueryRow(key).Scan(&count, &size); err != nil {
+		return 0, 0, err
+	}
+	return count, size, nil
+}
+
 func (s *StoreSqlite) AddMessage(msg *Message) error {
 	if msg == nil {
 		return ErrInvalidMessage
@@ -207,7 +394,17 @@ func (s *StoreSqlite) AddMessage(msg *Message) error {
 	defer stmt.Close()
 
 	content := base64.StdEncoding.EncodeToString(msg.Content)
-	result, err := stmt.Exec(msg.From.Key, msg.To.Key, msg.Duration.Seconds(), content, msg.CreatedAt.Format(time.RFC3339), msg.Played)
+	var expiresAt int64
+	if !msg.ExpiresAt.IsZero() {
+		expiresAt = msg.ExpiresAt.Unix()
+	}
+	// a sealed message doesn't tell who sent it
+	var from string
+	if msg.From != nil {
+		from = msg.From.Key
+	}
+	result, err := stmt.Exec(from, msg.To.Key, msg.Duration.Seconds(), content, msg.CreatedAt.Format(time.RFC3339), msg.Played, expiresAt, msg.BurnAfterPlaying, msg.Sealed, string(msg.Verification), msg.Group,
+		msg.UUID, msg.ThreadID, msg.InReplyTo, msg.RemoteURL)
 	if err != nil {
 		return err
 	}
@@ -260,6 +457,91 @@ func (s *StoreSqlite) GetMessage(msgID int64) (*Message, error) {
 	return nil, ErrNoResult
 }
 
+// GetThread returns the messages of a thread, oldest first. Content is
+// not loaded.
+func (s *StoreSqlite) GetThread(threadID string) ([]*Message, error) {
+	if threadID == "" {
+		return nil, ErrInvalidMessage
+	}
+	return s.queryMessages(selectThreadStmt, threadID)
+}
+
+// FindMessageByRemoteURL returns the local copy of a message downloaded
+// from url. Content is not loaded.
+func (s *StoreSqlite) FindMessageByRemoteURL(url string) (*Message, error) {
+	if url == "" {
+		return nil, ErrInvalidMessage
+	}
+	messages, err := s.queryMessages(selectMessageByURLStmt, url)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrNoResult
+	}
+	return messages[0], nil
+}
+
+// GetConversations sums up the messages key sent and received by partner
+// or group, the latest conversation first.
+func (s *StoreSqlite) GetConversations(key string) ([]*Conversation, error) {
+	if s.db == nil {
+		return nil, ErrDBNotOpen
+	}
+	rows, err := s.db.Query(selectConversationsStmt, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []*Conversation
+	for rows.Next() {
+		var partner string
+		var last float64
+		c := Conversation{}
+		if err := rows.Scan(&partner, &c.Group, &c.Messages, &c.Unread, &last); err != nil {
+			return nil, err
+		}
+		if c.Group == "" {
+			if c.Partner, err = s.FindUserByKey(partner); err != nil || c.Partner == nil {
+				c.Partner = &User{Key: partner}
+			}
+		}
+		c.LastAt = julianTime(last)
+		conversations = append(conversations, &c)
+	}
+	return conversations, rows.Err()
+}
+
+// julianTime converts a julian day number, as sqlite's julianday() has it,
+// to a time.
+func julianTime(day float64) time.Time {
+	const unixEpoch = 2440587.5
+	// created_at has whole seconds, the float may be a hair off them
+	return time.Unix(int64(math.Floor((day-unixEpoch)*86400+0.5)), 0).UTC()
+}
+
+func (s *StoreSqlite) queryMessages(query string, args ...interface{}) ([]*Message, error) {
+	if s.db == nil {
+		return nil, ErrDBNotOpen
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg := Message{}
+		if err := s.scanMessageFromRows(rows, &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, &msg)
+	}
+	return messages, rows.Err()
+}
+
 func (s *StoreSqlite) UpdateMessagePlayed(msgID int64, played bool) error {
 	if s.db == nil {
 		return ErrDBNotOpen
@@ -276,6 +558,470 @@ func (s *StoreSqlite) UpdateMessagePlayed(msgID int64, played bool) error {
 	return nil
 }
 
+// UpdateMessageVerification records how checking the signature of a
+// received message went.
+func (s *StoreSqlite) UpdateMessageVerification(msgID int64, v Verification) error {
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(updateVerificationStmt)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(string(v), msgID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateMessageTranscript keeps transcript, as the caller encrypted it,
+// with the message.
+func (s *StoreSqlite) UpdateMessageTranscript(msgID int64, transcript []byte) error {
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(updateTranscriptStmt)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(base64.StdEncoding.EncodeToString(transcript), msgID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetExpiredMessages returns messages whose expiry time is at or before now,
+// plus any created at or before createdBefore, and burn-after-playing ones
+// that were played. A zero createdBefore disables the age check. Content is
+// not loaded.
+func (s *StoreSqlite) GetExpiredMessages(now time.Time, createdBefore time.Time) ([]*Message, error) {
+	if s.db == nil {
+		return nil, ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(selectExpiredMessagesStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var before interface{}
+	if !createdBefore.IsZero() {
+		before = createdBefore.Format(time.RFC3339)
+	}
+	rows, err := stmt.Query(now.Unix(), before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg := Message{}
+		err := s.scanMessageFromRows(rows, &msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &msg)
+	}
+	return messages, nil
+}
+
+func (s *StoreSqlite) SetSenderRule(rule *SenderRule) error {
+	if rule == nil || rule.Owner == "" || rule.Sender == "" {
+		return ErrInvalidRule
+	}
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(insertSenderRuleStmt)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(rule.Owner, rule.Sender, rule.Allow); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *StoreSqlite) DeleteSenderRule(owner, sender string) error {
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(deleteSenderRuleStmt)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(owner, sender); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *StoreSqlite) GetSenderRules(owner string) ([]*SenderRule, error) {
+	if s.db == nil {
+		return nil, ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(selectSenderRulesStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*SenderRule
+	for rows.Next() {
+		rule := SenderRule{}
+		if err := rows.Scan(&rule.Owner, &rule.Sender, &rule.Allow); err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule)
+	}
+	return rules, nil
+}
+
+// SetKeyPin pins the key of pin.Email, replacing any earlier pin.
+func (s *StoreSqlite) SetKeyPin(pin *KeyPin) error {
+	if pin == nil || pin.Email == "" || pin.Fingerprint == "" {
+		return ErrInvalidKeyPin
+	}
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(insertKeyPinStmt)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(pin.Email, pin.Fingerprint, pin.FirstSeen.Format(time.RFC3339), pin.Verified); err != nil {
+		return err
+	}
+	return nil
+}
+
+// FindKeyPin returns the pin of email, whatever its case, or ErrNoResult.
+func (s *StoreSqlite) FindKeyPin(email string) (*KeyPin, error) {
+	if s.db == nil {
+		return nil, ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(selectKeyPinStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, ErrNoResult
+	}
+
+	var pin KeyPin
+	var firstSeen string
+	if err := rows.Scan(&pin.Email, &pin.Fingerprint, &firstSeen, &pin.Verified); err != nil {
+		return nil, err
+	}
+	pin.FirstSeen, _ = time.Parse(time.RFC3339, firstSeen)
+	return &pin, nil
+}
+
+// SetGroup creates group, or replaces the owner and members of the group
+// with its name.
+func (s *StoreSqlite) SetGroup(group *Group) error {
+	if group == nil || !ValidGroupName(group.Name) || group.Owner == "" {
+		return ErrInvalidGroup
+	}
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	existing, err := s.FindGroup(group.Name)
+	if err != nil && err != ErrNoResult {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if existing != nil {
+		group.GroupID, group.CreatedAt = existing.GroupID, existing.CreatedAt
+		if _, err := tx.Exec(updateGroupStmt, group.Owner, group.GroupID); err != nil {
+			return err
+		}
+	} else {
+		if group.CreatedAt.IsZero() {
+			group.CreatedAt = time.Now()
+		}
+		result, err := tx.Exec(insertGroupStmt, group.Name, group.Owner, group.CreatedAt.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		group.GroupID, _ = result.LastInsertId()
+	}
+
+	if _, err := tx.Exec(deleteGroupMembersStmt, group.GroupID); err != nil {
+		return err
+	}
+	for _, m := range group.Members {
+		if m == nil || m.Key == "" {
+			return ErrInvalidGroup
+		}
+		if _, err := tx.Exec(insertGroupMemberStmt, group.GroupID, m.Key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *StoreSqlite) DeleteGroup(name string) error {
+	group, err := s.FindGroup(name)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(deleteGroupMembersStmt, group.GroupID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(deleteGroupStmt, group.GroupID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FindGroup returns the group with name, whatever its case, or ErrNoResult.
+func (s *StoreSqlite) FindGroup(name string) (*Group, error) {
+	groups, err := s.queryGroups(selectGroupStmt, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, ErrNoResult
+	}
+	return groups[0], nil
+}
+
+// GetGroupsOf returns the groups key owns or is a member of.
+func (s *StoreSqlite) GetGroupsOf(key string) ([]*Group, error) {
+	return s.queryGroups(selectGroupsOfStmt, key)
+}
+
+func (s *StoreSqlite) queryGroups(query string, args ...interface{}) ([]*Group, error) {
+	if s.db == nil {
+		return nil, ErrDBNotOpen
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*Group
+	for rows.Next() {
+		var group Group
+		var createdAt string
+		if err := rows.Scan(&group.GroupID, &group.Name, &group.Owner, &createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		group.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		groups = append(groups, &group)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// members once the rows are closed, sqlite may have a single connection
+	for _, group := range groups {
+		if group.Members, err = s.groupMembers(group.GroupID); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// groupMembers returns the members of a group, with the name and email of
+// those that registered.
+func (s *StoreSqlite) groupMembers(groupID int64) ([]*User, error) {
+	rows, err := s.db.Query(selectGroupMembersStmt, groupID)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*User, len(keys))
+	for i, key := range keys {
+		if members[i], err = s.FindUserByKey(key); err != nil || members[i] == nil {
+			members[i] = &User{Key: key}
+		}
+	}
+	return members, nil
+}
+
+// AddContact adds contact to the address book, unless its alias is taken.
+func (s *StoreSqlite) AddContact(contact *Contact) error {
+	if contact == nil || contact.Alias == "" || contact.Key == "" {
+		return ErrInvalidContact
+	}
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	if _, err := s.FindContact(contact.Alias); err == nil {
+		return ErrContactExists
+	}
+	stmt, err := s.db.Prepare(insertContactStmt)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if contact.CreatedAt.IsZero() {
+		contact.CreatedAt = time.Now()
+	}
+	result, err := stmt.Exec(contact.Alias, contact.Key, contact.Name, contact.Email, contact.Favorite, contact.CreatedAt.Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	contact.ContactID, _ = result.LastInsertId()
+	return nil
+}
+
+// UpdateContact saves contact, which may have a new alias unless it is
+// taken by another contact.
+func (s *StoreSqlite) UpdateContact(contact *Contact) error {
+	if contact == nil || contact.ContactID == 0 || contact.Alias == "" || contact.Key == "" {
+		return ErrInvalidContact
+	}
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	if other, err := s.FindContact(contact.Alias); err == nil && other.ContactID != contact.ContactID {
+		return ErrContactExists
+	}
+	stmt, err := s.db.Prepare(updateContactStmt)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(contact.Alias, contact.Key, contact.Name, contact.Email, contact.Favorite, contact.ContactID)
+	return err
+}
+
+func (s *StoreSqlite) DeleteContact(alias string) error {
+	if s.db == nil {
+		return ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(deleteContactStmt)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(alias)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNoResult
+	}
+	return nil
+}
+
+// FindContact returns the contact with alias, whatever its case, or
+// ErrNoResult.
+func (s *StoreSqlite) FindContact(alias string) (*Contact, error) {
+	contacts, err := s.queryContacts(selectContactStmt, alias)
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, ErrNoResult
+	}
+	return contacts[0], nil
+}
+
+// GetContacts returns the whole address book, favorites first.
+func (s *StoreSqlite) GetContacts() ([]*Contact, error) {
+	return s.queryContacts(selectContactsStmt)
+}
+
+// SearchContacts returns the contacts whose alias, name, email or key
+// contains query, favorites first.
+func (s *StoreSqlite) SearchContacts(query string) ([]*Contact, error) {
+	return s.queryContacts(searchContactsStmt, likePattern(query))
+}
+
+// likePattern matches text containing query with LIKE ... ESCAPE '\', taking
+// wildcards in query literally.
+func likePattern(query string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+}
+
+func (s *StoreSqlite) queryContacts(query string, args ...interface{}) ([]*Contact, error) {
+	if s.db == nil {
+		return nil, ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []*Contact
+	for rows.Next() {
+		var contact Contact
+		var name, email, createdAt sql.NullString
+		if err := rows.Scan(&contact.ContactID, &contact.Alias, &contact.Key, &name, &email, &contact.Favorite, &createdAt); err != nil {
+			return nil, err
+		}
+		contact.Name, contact.Email = name.String, email.String
+		contact.CreatedAt, _ = time.Parse(time.RFC3339, createdAt.String)
+		contacts = append(contacts, &contact)
+	}
+	return contacts, rows.Err()
+}
+
 func (s *StoreSqlite) FindUserByName(name string) ([]*User, error) {
 	if s.db == nil {
 		return nil, ErrDBNotOpen
@@ -293,7 +1039,66 @@ func (s *StoreSqlite) FindUserByName(name string) ([]*User, error) {
 	defer rows.Close()
 
 	var users []*User
-	if rows.Next() {
+	for rows.Next() {
+		user := User{}
+		err := s.scanUserFromRows(rows, &user)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// SearchUsers returns the users whose name, email or key contains query.
+func (s *StoreSqlite) SearchUsers(query string) ([]*User, error) {
+	if s.db == nil {
+		return nil, ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(searchUsersStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(likePattern(query))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user := User{}
+		err := s.scanUserFromRows(rows, &user)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// FindUsersByEmail returns the users registered with email, whatever its
+// case.
+func (s *StoreSqlite) FindUsersByEmail(email string) ([]*User, error) {
+	if s.db == nil {
+		return nil, ErrDBNotOpen
+	}
+	stmt, err := s.db.Prepare(selectUsersByEmailStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
 		user := User{}
 		err := s.scanUserFromRows(rows, &user)
 		if err != nil {
@@ -301,7 +1106,7 @@ func (s *StoreSqlite) FindUserByName(name string) ([]*User, error) {
 		}
 		users = append(users, &user)
 	}
-	return users, ErrNoResult
+	return users, rows.Err()
 }
 
 func (s *StoreSqlite) scanUserFromRows(rows *sql.Rows, user *User) error {
@@ -340,8 +1145,8 @@ func (s *StoreSqlite) scanMessageFromRows(rows *sql.Rows, msg *Message) error {
 		return ErrInvalidMessage
 	}
 
-	var from, to, content, createdAt string
-	var duration int64
+	var from, to, content, createdAt, verification, group, transcript string
+	var duration, expiresAt int64
 	var params []interface{}
 	columns, err := rows.Columns()
 	if err != nil {
@@ -364,6 +1169,26 @@ func (s *StoreSqlite) scanMessageFromRows(rows *sql.Rows, msg *Message) error {
 			params = append(params, &msg.Played)
 		case "content":
 			params = append(params, &content)
+		case "expires_at":
+			params = append(params, &expiresAt)
+		case "burn_after_playing":
+			params = append(params, &msg.BurnAfterPlaying)
+		case "sealed":
+			params = append(params, &msg.Sealed)
+		case "verification":
+			params = append(params, &verification)
+		case "group":
+			params = append(params, &group)
+		case "uuid":
+			params = append(params, &msg.UUID)
+		case "thread_id":
+			params = append(params, &msg.ThreadID)
+		case "in_reply_to":
+			params = append(params, &msg.InReplyTo)
+		case "remote_url":
+			params = append(params, &msg.RemoteURL)
+		case "transcript":
+			params = append(params, &transcript)
 		}
 	}
 	err = rows.Scan(params...)
@@ -371,10 +1196,25 @@ func (s *StoreSqlite) scanMessageFromRows(rows *sql.Rows, msg *Message) error {
 		return err
 	}
 
-	msg.From, err = s.FindUserByKey(from)
-	msg.To, err = s.FindUserByKey(to)
+	// users that never registered are still known by their key
+	if from == "" {
+		msg.From = nil
+	} else if msg.From, err = s.FindUserByKey(from); err != nil || msg.From == nil {
+		msg.From = &User{Key: from}
+	}
+	if msg.To, err = s.FindUserByKey(to); err != nil || msg.To == nil {
+		msg.To = &User{Key: to}
+	}
 	msg.Content, _ = base64.StdEncoding.DecodeString(content)
+	if transcript != "" {
+		msg.Transcript, _ = base64.StdEncoding.DecodeString(transcript)
+	}
 	msg.Duration = time.Duration(duration) * time.Second
+	msg.Verification = Verification(verification)
+	msg.Group = group
+	if expiresAt > 0 {
+		msg.ExpiresAt = time.Unix(expiresAt, 0)
+	}
 	msg.CreatedAt, err = time.Parse(time.RFC3339, createdAt)
 	if err != nil {
 		msg.CreatedAt = time.Unix(0, 0)
@@ -89,6 +89,34 @@ func TestAddUser(t *testing.T) {
 	assert.Equal(t, u.Name, u1.Name)
 	assert.Equal(t, u.Email, u1.Email)
 
+	users, err := store.FindUserByName("Tester")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(users)) {
+		assert.Equal(t, u.Key, users[0].Key)
+	}
+	users, err = store.FindUserByName("Nobody")
+	assert.Nil(t, err)
+	assert.Empty(t, users)
+
+	users, err = store.SearchUsers("test")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(users))
+	users, err = store.SearchUsers("678")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(users))
+	users, err = store.SearchUsers("_")
+	assert.Nil(t, err)
+	assert.Empty(t, users)
+
+	users, err = store.FindUsersByEmail("Tester@Example.com")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(users)) {
+		assert.Equal(t, u.Key, users[0].Key)
+	}
+	users, err = store.FindUsersByEmail("nobody@example.com")
+	assert.Nil(t, err)
+	assert.Empty(t, users)
+
 	// u.Name = "Tester2"
 	// err = store.UpdateUser(u)
 	// assert.Nil(t, err)
@@ -136,6 +164,45 @@ func TestAddMessage(t *testing.T) {
 	m2, err := store.GetMessage(m.MessageID)
 	assert.Nil(t, err)
 	assert.True(t, m2.Played)
+	assert.Equal(t, Verification(""), m2.Verification)
+
+	err = store.UpdateMessageVerification(m.MessageID, VerifyMismatch)
+	assert.Nil(t, err)
+
+	m3, err := store.GetMessage(m.MessageID)
+	assert.Nil(t, err)
+	assert.Equal(t, VerifyMismatch, m3.Verification)
+	assert.Nil(t, m3.Transcript)
+
+	err = store.UpdateMessageTranscript(m.MessageID, []byte("encrypted text"))
+	assert.Nil(t, err)
+
+	m4, err := store.GetMessage(m.MessageID)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("encrypted text"), m4.Transcript)
+}
+
+func TestAddSealedMessage(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	users, err := createRandomUsers(store, 1)
+	assert.Nil(t, err)
+
+	m := &Message{
+		To:      users[0],
+		Content: []byte("sealed"),
+		Sealed:  true,
+	}
+	assert.Nil(t, store.AddMessage(m))
+
+	m1, err := store.GetMessage(m.MessageID)
+	assert.Nil(t, err)
+	assert.Nil(t, m1.From)
+	assert.True(t, m1.Sealed)
+	assert.Equal(t, users[0].Key, m1.To.Key)
+	assert.Equal(t, m.Content, m1.Content)
 }
 
 func TestUserMessages(t *testing.T) {
@@ -170,3 +237,302 @@ func TestUserMessages(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 10, len(m2))
 }
+
+func TestRenameKey(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	users, err := createRandomUsers(store, 2)
+	assert.Nil(t, err)
+	m := &Message{From: users[0], To: users[1], Content: []byte("hi")}
+	assert.Nil(t, store.AddMessage(m))
+	assert.Nil(t, store.AddContact(&Contact{Alias: "them", Key: users[1].Key}))
+
+	fpr := "0123456789ABCDEF0123456789ABCDEF" + users[1].Key
+	assert.Nil(t, store.RenameKey(users[1].Key, fpr))
+	messages, err := store.GetUserMessages(fpr)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+	messages, err = store.GetUserMessages(users[1].Key)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(messages))
+	c, err := store.FindContact("them")
+	assert.Nil(t, err)
+	assert.Equal(t, fpr, c.Key)
+}
+
+func TestMailboxUsage(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	users, err := createRandomUsers(store, 2)
+	assert.Nil(t, err)
+
+	count, size, err := store.GetMailboxUsage(users[1].Key)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, int64(0), size)
+
+	var last *Message
+	for i := 0; i < 3; i++ {
+		// sizes that take base64 padding
+		last = &Message{
+			From:    users[0],
+			To:      users[1],
+			Content: make([]byte, 300+i),
+		}
+		assert.Nil(t, store.AddMessage(last))
+	}
+
+	count, size, err = store.GetMailboxUsage(users[1].Key)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, int64(903), size)
+
+	// played messages no longer count
+	assert.Nil(t, store.UpdateMessagePlayed(last.MessageID, true))
+	count, size, err = store.GetMailboxUsage(users[1].Key)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int64(601), size)
+
+	count, _, err = store.GetMailboxUsage(users[0].Key)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestSenderRules(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	rules, err := store.GetSenderRules("AAAA1111")
+	assert.Nil(t, err)
+	assert.True(t, SenderAllowed(rules, "BBBB2222"))
+
+	err = store.SetSenderRule(&SenderRule{Owner: "AAAA1111", Sender: "BBBB2222"})
+	assert.Nil(t, err)
+	rules, err = store.GetSenderRules("AAAA1111")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rules))
+	assert.False(t, SenderAllowed(rules, "BBBB2222"))
+	assert.False(t, SenderAllowed(rules, "bbbb2222"))
+	assert.True(t, SenderAllowed(rules, "CCCC3333"))
+
+	// switching to an allow list shuts out everybody else
+	err = store.SetSenderRule(&SenderRule{Owner: "AAAA1111", Sender: "BBBB2222", Allow: true})
+	assert.Nil(t, err)
+	rules, err = store.GetSenderRules("AAAA1111")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rules))
+	assert.True(t, SenderAllowed(rules, "BBBB2222"))
+	assert.False(t, SenderAllowed(rules, "CCCC3333"))
+
+	err = store.DeleteSenderRule("AAAA1111", "BBBB2222")
+	assert.Nil(t, err)
+	rules, err = store.GetSenderRules("AAAA1111")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rules))
+
+	assert.Equal(t, ErrInvalidRule, store.SetSenderRule(&SenderRule{Owner: "AAAA1111"}))
+}
+
+func TestKeyPins(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	_, err := store.FindKeyPin("alice@example.com")
+	assert.Equal(t, ErrNoResult, err)
+
+	firstSeen := time.Date(2015, 1, 24, 12, 0, 0, 0, time.UTC)
+	err = store.SetKeyPin(&KeyPin{Email: "alice@example.com", Fingerprint: "0123456789ABCDEF0123456789ABCDEFAAAA1111", FirstSeen: firstSeen})
+	assert.Nil(t, err)
+	pin, err := store.FindKeyPin("Alice@Example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789ABCDEF0123456789ABCDEFAAAA1111", pin.Fingerprint)
+	assert.True(t, firstSeen.Equal(pin.FirstSeen))
+	assert.False(t, pin.Verified)
+
+	// verifying a new key replaces the pin
+	err = store.SetKeyPin(&KeyPin{Email: "alice@example.com", Fingerprint: "0123456789ABCDEF0123456789ABCDEFAAAA2222", FirstSeen: firstSeen, Verified: true})
+	assert.Nil(t, err)
+	pin, err = store.FindKeyPin("alice@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789ABCDEF0123456789ABCDEFAAAA2222", pin.Fingerprint)
+	assert.True(t, pin.Verified)
+
+	assert.Equal(t, ErrInvalidKeyPin, store.SetKeyPin(&KeyPin{Email: "alice@example.com"}))
+}
+
+func TestContacts(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	alice := &Contact{Alias: "alice", Key: "AAAA1111", Name: "Alice Liddell", Email: "alice@example.com"}
+	assert.Nil(t, store.AddContact(alice))
+	assert.True(t, alice.ContactID > 0)
+	bob := &Contact{Alias: "bob", Key: "BBBB2222", Name: "Bob 100%", Email: "bob@example.com", Favorite: true}
+	assert.Nil(t, store.AddContact(bob))
+	assert.Equal(t, ErrContactExists, store.AddContact(&Contact{Alias: "Alice", Key: "CCCC3333"}))
+	assert.Equal(t, ErrInvalidContact, store.AddContact(&Contact{Alias: "carol"}))
+
+	c, err := store.FindContact("ALICE")
+	assert.Nil(t, err)
+	assert.Equal(t, "AAAA1111", c.Key)
+	assert.Equal(t, "alice@example.com", c.Email)
+	assert.False(t, c.CreatedAt.IsZero())
+
+	// favorites first
+	contacts, err := store.GetContacts()
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(contacts)) {
+		assert.Equal(t, "bob", contacts[0].Alias)
+		assert.Equal(t, "alice", contacts[1].Alias)
+	}
+
+	contacts, err = store.SearchContacts("liddell")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(contacts))
+	contacts, err = store.SearchContacts("example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(contacts))
+	// wildcards are taken literally
+	contacts, err = store.SearchContacts("%")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(contacts)) {
+		assert.Equal(t, "bob", contacts[0].Alias)
+	}
+
+	c.Alias = "bob"
+	assert.Equal(t, ErrContactExists, store.UpdateContact(c))
+	c.Alias = "ally"
+	assert.Nil(t, store.UpdateContact(c))
+	_, err = store.FindContact("alice")
+	assert.Equal(t, ErrNoResult, err)
+	c, err = store.FindContact("ally")
+	assert.Nil(t, err)
+	assert.Equal(t, "AAAA1111", c.Key)
+
+	assert.Nil(t, store.DeleteContact("ally"))
+	assert.Equal(t, ErrNoResult, store.DeleteContact("ally"))
+	contacts, err = store.GetContacts()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(contacts))
+}
+
+func TestGroups(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	bob := &User{Name: "Bob", Email: "bob@example.com", Key: "BBBB2222"}
+	assert.Nil(t, store.AddUser(bob))
+
+	team := &Group{
+		Name:    "team",
+		Owner:   "AAAA1111",
+		Members: []*User{{Key: "BBBB2222"}, {Key: "CCCC3333"}},
+	}
+	assert.Nil(t, store.SetGroup(team))
+	assert.True(t, team.GroupID > 0)
+	assert.Equal(t, ErrInvalidGroup, store.SetGroup(&Group{Name: "Not A Name", Owner: "AAAA1111"}))
+
+	g, err := store.FindGroup("TEAM")
+	assert.Nil(t, err)
+	assert.Equal(t, "AAAA1111", g.Owner)
+	if assert.Equal(t, 2, len(g.Members)) {
+		// registered members come with their name
+		assert.Equal(t, "Bob", g.Members[0].Name)
+		assert.Equal(t, "CCCC3333", g.Members[1].Key)
+	}
+	assert.True(t, g.Includes("AAAA1111"))
+	assert.True(t, g.Includes("cccc3333"))
+	assert.False(t, g.Includes("DDDD4444"))
+
+	// members are replaced
+	team.Members = []*User{{Key: "DDDD4444"}}
+	assert.Nil(t, store.SetGroup(team))
+	g, err = store.FindGroup("team")
+	assert.Nil(t, err)
+	assert.Equal(t, team.GroupID, g.GroupID)
+	if assert.Equal(t, 1, len(g.Members)) {
+		assert.Equal(t, "DDDD4444", g.Members[0].Key)
+	}
+
+	assert.Nil(t, store.SetGroup(&Group{Name: "family", Owner: "DDDD4444"}))
+	groups, err := store.GetGroupsOf("DDDD4444")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(groups)) {
+		assert.Equal(t, "family", groups[0].Name)
+		assert.Equal(t, "team", groups[1].Name)
+	}
+	groups, err = store.GetGroupsOf("BBBB2222")
+	assert.Nil(t, err)
+	assert.Empty(t, groups)
+
+	assert.Nil(t, store.DeleteGroup("team"))
+	_, err = store.FindGroup("team")
+	assert.Equal(t, ErrNoResult, err)
+	assert.Equal(t, ErrNoResult, store.DeleteGroup("team"))
+}
+
+func TestThreads(t *testing.T) {
+	store := createStore("")
+	assert.NotNil(t, store)
+	defer store.Close()
+
+	alice := &User{Name: "Alice", Key: "AAAA1111"}
+	bob := &User{Name: "Bob", Key: "BBBB2222"}
+	carol := &User{Name: "Carol", Key: "CCCC3333"}
+	assert.Nil(t, store.AddUser(bob))
+
+	now := time.Now()
+	messages := []*Message{
+		{From: alice, To: bob, CreatedAt: now.Add(-3 * time.Hour), UUID: "u1", ThreadID: "u1", Played: true},
+		{From: bob, To: alice, CreatedAt: now.Add(-2 * time.Hour), UUID: "u2", ThreadID: "u1", InReplyTo: "u1"},
+		{From: carol, To: alice, CreatedAt: now.Add(-time.Hour), UUID: "u3", ThreadID: "u3", RemoteURL: "https://example.com/v1/messages/7"},
+		{From: carol, To: alice, CreatedAt: now.Add(-30 * time.Minute), UUID: "u4", ThreadID: "u3", InReplyTo: "u3"},
+		{From: bob, To: alice, CreatedAt: now, Group: "team", UUID: "u5", ThreadID: "u5"},
+	}
+	for _, m := range messages {
+		assert.Nil(t, store.AddMessage(m))
+	}
+
+	thread, err := store.GetThread("u1")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(thread)) {
+		assert.Equal(t, "u1", thread[0].UUID)
+		assert.Equal(t, "u1", thread[1].InReplyTo)
+		assert.Equal(t, "Bob", thread[1].From.Name)
+	}
+	_, err = store.GetThread("")
+	assert.Equal(t, ErrInvalidMessage, err)
+
+	m, err := store.FindMessageByRemoteURL("https://example.com/v1/messages/7")
+	assert.Nil(t, err)
+	assert.Equal(t, "u3", m.UUID)
+	_, err = store.FindMessageByRemoteURL("https://example.com/v1/messages/8")
+	assert.Equal(t, ErrNoResult, err)
+
+	conversations, err := store.GetConversations(alice.Key)
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(conversations)) {
+		assert.Equal(t, "team", conversations[0].Group)
+		assert.Nil(t, conversations[0].Partner)
+		assert.Equal(t, 1, conversations[0].Unread)
+
+		assert.Equal(t, "CCCC3333", conversations[1].Partner.Key)
+		assert.Equal(t, 2, conversations[1].Messages)
+		assert.Equal(t, 2, conversations[1].Unread)
+		assert.Equal(t, now.Add(-30*time.Minute).Unix(), conversations[1].LastAt.Unix())
+
+		// bob is known by name, only what alice received counts as unread
+		assert.Equal(t, "Bob", conversations[2].Partner.Name)
+		assert.Equal(t, 2, conversations[2].Messages)
+		assert.Equal(t, 1, conversations[2].Unread)
+	}
+}
@@ -0,0 +1,20 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// CertFingerprint is the SHA-256 fingerprint of a DER encoded certificate
+// in lower case hex, the form used for certificate pinning.
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint accepts fingerprints as printed by openssl
+// ("AB:CD:...") and returns them in the form of CertFingerprint.
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(fingerprint), ":", "", -1))
+}
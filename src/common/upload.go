@@ -0,0 +1,26 @@
+package common
+
+import (
+	"time"
+)
+
+// UploadRequest starts a resumable upload. Message describes the message
+// without its content, which follows in chunks and adds up to Size bytes.
+type UploadRequest struct {
+	Message *Message `json:"message"`
+	Size    int64    `json:"size"`
+}
+
+// Upload is the state of a resumable upload: Offset bytes of Size arrived.
+type Upload struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UploadFinish completes an upload. SHA256 is the hex encoded hash of the
+// whole content.
+type UploadFinish struct {
+	SHA256 string `json:"sha256"`
+}
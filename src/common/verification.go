@@ -0,0 +1,20 @@
+package common
+
+// Verification tells whether a received message was really signed by the
+// sender it names. The empty value means it was not checked yet.
+type Verification string
+
+const (
+	VerifyTrusted      Verification = "trusted"     // good signature by the sender, whose key is trusted
+	VerifyUntrusted    Verification = "untrusted"   // good signature by the sender, key not certified
+	VerifyMismatch     Verification = "mismatch"    // good signature, but by someone else
+	VerifyBadSignature Verification = "bad"         // forged, damaged, or made with a revoked key
+	VerifyUnknownKey   Verification = "unknown_key" // signed with a key not in the keyring
+	VerifyExpiredKey   Verification = "expired_key" // good signature by the sender, with a key that has expired
+	VerifyUnsigned     Verification = "unsigned"
+)
+
+// Authentic reports whether the message surely comes from its sender.
+func (v Verification) Authentic() bool {
+	return v == VerifyTrusted || v == VerifyUntrusted
+}
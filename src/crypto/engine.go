@@ -0,0 +1,335 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Engine is the set of PGP operations talkie needs. GPGEngine implements it
+// with the gpg command line tool; tests can swap in a fake.
+type Engine interface {
+	ListPublicKeys(search string) ([]Key, error)
+	ListSecretKeys(search string) ([]Key, error)
+	RecvKey(key string) error
+	ImportKey(src io.Reader) error
+	ExportKey(key string) ([]byte, error)
+	Encrypt(uid, recipient string, src io.Reader) ([]byte, error)
+	EncryptTo(uid string, recipients []string, src io.Reader) ([]byte, error)
+	Decrypt(uid string, src io.Reader) ([]byte, error)
+	DecryptVerify(uid string, src io.Reader) ([]byte, *Signature, error)
+	ClearSign(uid string, src io.Reader) ([]byte, error)
+	Verify(src io.Reader) (string, []byte, error)
+	VerifyWithKey(key []byte, src io.Reader) (string, []byte, error)
+	ImportMatchingKeys(src io.Reader, search string) ([]Key, error)
+}
+
+type GPGEngineOptions struct {
+	// Home is the gpg home directory holding the keyrings. Empty means
+	// gpg's default, $GNUPGHOME or ~/.gnupg.
+	Home string
+
+	// Passphrase unlocks secret keys, DefaultPassphraseProvider if nil.
+	Passphrase PassphraseProvider
+}
+
+// GPGEngine runs gpg on the keyrings in its home directory.
+type GPGEngine struct {
+	home       string
+	passphrase PassphraseProvider
+}
+
+func NewGPGEngine() *GPGEngine {
+	return &GPGEngine{}
+}
+
+// NewGPGEngineWithOptions is like NewGPGEngine but configured by options.
+// The home directory is created if it doesn't exist yet.
+func NewGPGEngineWithOptions(options *GPGEngineOptions) (*GPGEngine, error) {
+	if options == nil {
+		options = &GPGEngineOptions{}
+	}
+	if options.Home != "" {
+		// gpg refuses a home directory others can read
+		if err := os.MkdirAll(options.Home, 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &GPGEngine{
+		home:       options.Home,
+		passphrase: options.Passphrase,
+	}, nil
+}
+
+// Home is the gpg home directory, empty for gpg's default.
+func (e *GPGEngine) Home() string {
+	return e.home
+}
+
+func (e *GPGEngine) command(args ...string) *exec.Cmd {
+	if e.home != "" {
+		args = append([]string{"--homedir", e.home}, args...)
+	}
+	return exec.Command(GPGPath, args...)
+}
+
+// secretKeyCommand prepares gpg to use uid's secret key. If the passphrase
+// provider has the passphrase it goes to gpg through a pipe on fd 3 with
+// loopback pinentry, which the caller closes with closeExtraFiles once gpg
+// is done.
+func (e *GPGEngine) secretKeyCommand(uid string, args ...string) (*exec.Cmd, error) {
+	p := e.passphrase
+	if p == nil {
+		p = DefaultPassphraseProvider
+	}
+	passphrase, err := p.Passphrase(uid)
+	if err == ErrUseAgent {
+		return e.command(append([]string{"-u", uid}, args...)...), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(w, passphrase+"\n")
+	w.Close()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	gpg := e.command(append([]string{"--batch", "--pinentry-mode", "loopback", "--passphrase-fd", "3", "-u", uid}, args...)...)
+	gpg.ExtraFiles = []*os.File{r}
+	return gpg, nil
+}
+
+func closeExtraFiles(gpg *exec.Cmd) {
+	for _, f := range gpg.ExtraFiles {
+		f.Close()
+	}
+}
+
+func (e *GPGEngine) listKeys(list, search string) ([]Key, error) {
+	var buf bytes.Buffer
+	args := []string{"--with-colons", "--fixed-list-mode", list}
+	if search = strings.TrimSpace(search); search != "" {
+		args = append(args, search)
+	}
+	gpg := e.command(args...)
+	gpg.Stdout = &buf
+	// gpg fails when nothing matches search, which is just no keys
+	gpg.Run()
+
+	return parseKeys(&buf), nil
+}
+
+func (e *GPGEngine) ListPublicKeys(search string) ([]Key, error) {
+	return e.listKeys("--list-public-keys", search)
+}
+
+func (e *GPGEngine) ListSecretKeys(search string) ([]Key, error) {
+	return e.listKeys("--list-secret-keys", search)
+}
+
+func (e *GPGEngine) RecvKey(key string) error {
+	gpg := e.command("--batch", "--yes", "--recv-keys", "--display-charset", "utf-8", key)
+	return gpg.Run()
+}
+
+// ImportKey adds the keys in src, armored or not, to the keyrings.
+func (e *GPGEngine) ImportKey(src io.Reader) error {
+	gpg := e.command("--batch", "--yes", "--import")
+	gpg.Stdin = src
+	return gpg.Run()
+}
+
+// ExportKey returns the armored public key of key.
+func (e *GPGEngine) ExportKey(key string) ([]byte, error) {
+	var buf bytes.Buffer
+	gpg := e.command("--batch", "--armor", "--export", key)
+	gpg.Stdout = &buf
+	if err := gpg.Run(); err != nil {
+		return nil, err
+	}
+	// gpg exports nothing, successfully, for a key it doesn't have
+	if buf.Len() == 0 {
+		return nil, ErrKeyNotFound
+	}
+	return buf.Bytes(), nil
+}
+
+// Encrypt signs src with uid's secret key and encrypts it to recipient.
+func (e *GPGEngine) Encrypt(uid, recipient string, src io.Reader) ([]byte, error) {
+	return e.EncryptTo(uid, []string{recipient}, src)
+}
+
+// EncryptTo signs src with uid's secret key and encrypts it once, in a
+// message each of recipients can decrypt.
+func (e *GPGEngine) EncryptTo(uid string, recipients []string, src io.Reader) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	var out bytes.Buffer
+	args := []string{"--trust-model", "always", "-se"}
+	for _, r := range recipients {
+		args = append(args, "-r", r)
+	}
+	gpg, err := e.secretKeyCommand(uid, append(args, "-o", "-")...)
+	if err != nil {
+		return nil, err
+	}
+	defer closeExtraFiles(gpg)
+	gpg.Stdin = src
+	gpg.Stdout = &out
+	if err := gpg.Run(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts src with uid's secret key, whoever signed it.
+func (e *GPGEngine) Decrypt(uid string, src io.Reader) ([]byte, error) {
+	content, _, err := e.DecryptVerify(uid, src)
+	return content, err
+}
+
+// DecryptVerify decrypts a message with uid's secret key and checks the
+// signature on it. A missing or bad signature is not an error, it is told
+// by the returned Signature.
+func (e *GPGEngine) DecryptVerify(uid string, src io.Reader) ([]byte, *Signature, error) {
+	var out, status bytes.Buffer
+	gpg, err := e.secretKeyCommand(uid, "--status-fd", "2", "-o", "-", "--decrypt")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeExtraFiles(gpg)
+	gpg.Stdin = src
+	gpg.Stdout = &out
+	gpg.Stderr = &status
+	// gpg fails on a bad signature even though it decrypted the message
+	err = gpg.Run()
+	if !strings.Contains(status.String(), "[GNUPG:] DECRYPTION_OKAY") {
+		if err == nil {
+			err = ErrDecryptionFailed
+		}
+		return nil, nil, err
+	}
+	return out.Bytes(), parseStatus(status.String()), nil
+}
+
+// ClearSign signs src with uid's secret key, returning a cleartext signed
+// message.
+func (e *GPGEngine) ClearSign(uid string, src io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	gpg, err := e.secretKeyCommand(uid, "--clearsign")
+	if err != nil {
+		return nil, err
+	}
+	defer closeExtraFiles(gpg)
+	gpg.Stdin = src
+	gpg.Stdout = &buf
+	if err := gpg.Run(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Verify checks a cleartext signed message and returns the fingerprint of
+// the signing key along with the signed content.
+func (e *GPGEngine) Verify(src io.Reader) (string, []byte, error) {
+	var out, status bytes.Buffer
+	gpg := e.command("--batch", "--status-fd", "2", "-o", "-", "--decrypt")
+	gpg.Stdin = src
+	gpg.Stdout = &out
+	gpg.Stderr = &status
+	if err := gpg.Run(); err != nil {
+		return "", nil, ErrBadSignature
+	}
+
+	if sig := parseStatus(status.String()); sig.Good() {
+		return sig.Fingerprint, out.Bytes(), nil
+	}
+	return "", nil, ErrBadSignature
+}
+
+// scratch is an engine on a temporary keyring holding only the keys in key,
+// and the function that removes it.
+func scratch(key []byte) (*GPGEngine, func(), error) {
+	home, err := ioutil.TempDir("", "talkie-gnupg")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		exec.Command("gpgconf", "--homedir", home, "--kill", "all").Run()
+		os.RemoveAll(home)
+	}
+	e := &GPGEngine{home: home}
+	if err := e.ImportKey(bytes.NewReader(key)); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return e, cleanup, nil
+}
+
+// VerifyWithKey is Verify against the keys in key, armored or not, instead
+// of the keyring, which it leaves alone.
+func (e *GPGEngine) VerifyWithKey(key []byte, src io.Reader) (string, []byte, error) {
+	s, cleanup, err := scratch(key)
+	if err != nil {
+		return "", nil, ErrBadSignature
+	}
+	defer cleanup()
+	return s.Verify(src)
+}
+
+// ImportMatchingKeys adds to the keyrings only the keys in src matching
+// search, a fingerprint or "<email>", and returns them. The other keys in
+// src never reach the keyrings.
+func (e *GPGEngine) ImportMatchingKeys(src io.Reader, search string) ([]Key, error) {
+	d, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	s, cleanup, err := scratch(d)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	keys, _ := s.ListPublicKeys(search)
+	var matching []Key
+	for _, k := range keys {
+		// gpg also takes a search for part of a user id or a key ID
+		if strings.HasPrefix(search, "<") || KeyMatches(k.Fingerprint, search) {
+			matching = append(matching, k)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	for _, k := range matching {
+		data, err := s.ExportKey(k.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.ImportKey(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	return matching, nil
+}
+
+// Search looks key up on the keyserver.
+func (e *GPGEngine) Search(key string) ([]Key, error) {
+	var buf bytes.Buffer
+	gpg := e.command("--batch", "--display-charset", "utf-8", "--search", key)
+	gpg.Stdout = &buf
+	gpg.Run()
+
+	return parseBuffer(&buf)
+}
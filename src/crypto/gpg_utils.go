@@ -2,21 +2,22 @@ package crypto
 
 import (
 	"bytes"
-	"code.google.com/p/go-uuid/uuid"
-	"fmt"
+	"errors"
 	"io"
-	"io/ioutil"
 	"os"
-	"os/exec"
-	"path"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var (
 	GPGPath = "gpg"
+
+	ErrBadSignature     = errors.New("bad signature")
+	ErrDecryptionFailed = errors.New("decryption failed")
+	ErrKeyNotFound      = errors.New("key not found")
 )
 
 func init() {
@@ -34,11 +35,12 @@ func init() {
 }
 
 type Key struct {
-	Name      string
-	Email     string
-	PublicKey string
-	SecretKey string
-	CreatedAt time.Time
+	Name        string
+	Email       string
+	PublicKey   string // short key ID
+	SecretKey   string
+	Fingerprint string
+	CreatedAt   time.Time
 }
 
 func parseBuffer(buf *bytes.Buffer) ([]Key, error) {
@@ -80,120 +82,124 @@ func parseBuffer(buf *bytes.Buffer) ([]Key, error) {
 	return list, nil
 }
 
-func GPGListPublicKeys(search string) ([]Key, error) {
-	var buf bytes.Buffer
-	var gpg *exec.Cmd
-
-	search = strings.TrimSpace(search)
-	if len(search) > 0 {
-		gpg = exec.Command(GPGPath, "--list-public-keys", "--display-charset", "utf-8", search)
-	} else {
-		gpg = exec.Command(GPGPath, "--list-public-keys", "--display-charset", "utf-8")
+// parseKeys reads the keys gpg lists with --with-colons.
+func parseKeys(buf *bytes.Buffer) []Key {
+	var list []Key
+	var key *Key
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 10 {
+			continue
+		}
+		switch fields[0] {
+		case "pub", "sec":
+			if key != nil && key.Email != "" {
+				list = append(list, *key)
+			}
+			key = &Key{}
+			if id := fields[4]; len(id) > 8 {
+				key.PublicKey = id[len(id)-8:]
+			} else {
+				key.PublicKey = id
+			}
+			if t, err := strconv.ParseInt(fields[5], 10, 64); err == nil {
+				key.CreatedAt = time.Unix(t, 0)
+			}
+		case "fpr":
+			// the first one belongs to the primary key, the others to subkeys
+			if key != nil && key.Fingerprint == "" {
+				key.Fingerprint = fields[9]
+			}
+		case "uid":
+			if key == nil || key.Email != "" {
+				continue
+			}
+			uid := strings.Replace(fields[9], "\\x3a", ":", -1)
+			if m := uidPattern.FindStringSubmatch(uid); m != nil {
+				key.Name = strings.TrimSpace(m[1])
+				key.Email = strings.TrimSpace(m[2])
+			}
+		}
+	}
+	if key != nil && key.Email != "" {
+		list = append(list, *key)
 	}
-	gpg.Stdout = &buf
-	gpg.Run()
+	return list
+}
+
+var uidPattern = regexp.MustCompile("(.*)<(.*@.*)>")
+
+// the engine used by the GPG functions, on gpg's default keyrings
+var defaultEngine = NewGPGEngine()
 
-	return parseBuffer(&buf)
+func GPGListPublicKeys(search string) ([]Key, error) {
+	return defaultEngine.ListPublicKeys(search)
 }
 
 func GPGRecvKey(key string) error {
-	gpg := exec.Command(GPGPath, "--batch", "--yes", "--recv-keys", "--display-charset", "utf-8", key)
-	return gpg.Run()
+	return defaultEngine.RecvKey(key)
 }
 
 func GPGListSecretKeys(search string) ([]Key, error) {
-	var buf bytes.Buffer
-	var gpg *exec.Cmd
-
-	search = strings.TrimSpace(search)
-	if len(search) > 0 {
-		gpg = exec.Command(GPGPath, "--list-secret-keys", "--display-charset", "utf-8", search)
-	} else {
-		gpg = exec.Command(GPGPath, "--list-secret-keys", "--display-charset", "utf-8")
-	}
-	gpg.Stdout = &buf
-	gpg.Run()
-
-	return parseBuffer(&buf)
+	return defaultEngine.ListSecretKeys(search)
 }
 
+// GPGEncrypt signs src with uid's secret key and encrypts it to recipient.
 func GPGEncrypt(uid, recipient string, src io.Reader) ([]byte, error) {
-	srcfile := path.Join(os.TempDir(), uuid.NewUUID().String())
-	dstfile := fmt.Sprintf("%s.gpg", srcfile)
-	defer func() {
-		os.RemoveAll(srcfile)
-		os.RemoveAll(dstfile)
-	}()
-
-	// save data to srcfile
-	wd, err := os.Create(srcfile)
-	if err != nil {
-		return nil, err
-	}
-	_, err = io.Copy(wd, src)
-	if err != nil {
-		return nil, err
-	}
-	wd.Close()
+	return defaultEngine.Encrypt(uid, recipient, src)
+}
 
-	// encrypt using gpg
-	gpg := exec.Command(GPGPath, "--trust-model", "always", "-u", uid, "-se", "-r", recipient, "-o", dstfile, srcfile)
-	gpg.Stdin = os.Stdin
-	if err = gpg.Run(); err != nil {
-		return nil, err
-	}
+// GPGDecrypt decrypts src with uid's secret key, whoever signed it.
+func GPGDecrypt(uid string, src io.Reader) ([]byte, error) {
+	return defaultEngine.Decrypt(uid, src)
+}
 
-	// read dstfile
-	rd, err := os.Open(dstfile)
-	if err != nil {
-		return nil, err
-	}
-	defer rd.Close()
-	return ioutil.ReadAll(rd)
+func GPGSearch(key string) ([]Key, error) {
+	return defaultEngine.Search(key)
 }
 
-func GPGDecrypt(uid string, src io.Reader) ([]byte, error) {
-	dstfile := path.Join(os.TempDir(), uuid.NewUUID().String())
-	srcfile := fmt.Sprintf("%s.gpg", dstfile)
-	defer func() {
-		os.RemoveAll(srcfile)
-		os.RemoveAll(dstfile)
-	}()
-
-	// save data to srcfile
-	wd, err := os.Create(srcfile)
-	if err != nil {
-		return nil, err
-	}
-	_, err = io.Copy(wd, src)
-	if err != nil {
-		return nil, err
-	}
-	wd.Close()
+// GPGClearSign signs src with uid's secret key, returning a cleartext
+// signed message.
+func GPGClearSign(uid string, src io.Reader) ([]byte, error) {
+	return defaultEngine.ClearSign(uid, src)
+}
 
-	// decrypt using gpg
-	gpg := exec.Command(GPGPath, "-u", uid, "-o", dstfile, srcfile)
-	err = gpg.Run()
-	if err != nil {
-		return nil, err
-	}
+// GPGVerify checks a cleartext signed message and returns the fingerprint of
+// the signing key along with the signed content.
+func GPGVerify(src io.Reader) (string, []byte, error) {
+	return defaultEngine.Verify(src)
+}
 
-	// read dstfile
-	rd, err := os.Open(dstfile)
-	if err != nil {
-		return nil, err
-	}
-	defer rd.Close()
-	return ioutil.ReadAll(rd)
+// GPGDecryptVerify decrypts a message with uid's secret key and checks the
+// signature on it.
+func GPGDecryptVerify(uid string, src io.Reader) ([]byte, *Signature, error) {
+	return defaultEngine.DecryptVerify(uid, src)
 }
 
-func GPGSearch(key string) ([]Key, error) {
-	var buf bytes.Buffer
+// KeyMatches reports whether fingerprint belongs to key, which may be a long
+// key ID or a full fingerprint. A short key ID is easily forged by a key made
+// to collide with it, so it never matches.
+func KeyMatches(fingerprint, key string) bool {
+	key = NormalizeKey(key)
+	return len(key) >= 16 && strings.HasSuffix(strings.ToUpper(fingerprint), key)
+}
 
-	// search key from gpg keyserver
-	gpg := exec.Command(GPGPath, "--batch", "--display-charset", "utf-8", "--search", key)
-	gpg.Stdout = &buf
-	gpg.Run()
+// IsFingerprint reports whether key is a full fingerprint rather than a key
+// ID.
+func IsFingerprint(key string) bool {
+	key = NormalizeKey(key)
+	if len(key) < 40 {
+		return false
+	}
+	for _, c := range key {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
 
-	return parseBuffer(&buf)
+// NormalizeKey is key in the form gpg prints it, upper case without "0x".
+func NormalizeKey(key string) string {
+	return strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(key), "0x"))
 }
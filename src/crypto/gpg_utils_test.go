@@ -4,43 +4,265 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
-	// "os"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
 	"testing"
 )
 
-func TestGPGListSecretKeys(t *testing.T) {
-	list, err := GPGListSecretKeys("")
-	assert.Nil(t, err)
-	assert.True(t, len(list) > 0)
+// newTestEngine generates a key for name in a keyring of its own, so tests
+// neither need nor touch the keys of whoever runs them.
+func newTestEngine(t *testing.T, name, passphrase string) (*GPGEngine, Key, func()) {
+	home, err := ioutil.TempDir("", "talkie-gnupg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		os.RemoveAll(home)
+	}
+	engine, err := NewGPGEngineWithOptions(&GPGEngineOptions{
+		Home:       home,
+		Passphrase: StaticPassphrase(passphrase),
+	})
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	protection := "%no-protection"
+	if passphrase != "" {
+		protection = "Passphrase: " + passphrase
+	}
+	params := fmt.Sprintf(`Key-Type: eddsa
+Key-Curve: ed25519
+Subkey-Type: ecdh
+Subkey-Curve: cv25519
+Name-Real: %s
+Name-Email: %s@example.com
+Expire-Date: 0
+%s
+%%commit
+`, name, name, protection)
+	gpg := engine.command("--batch", "--pinentry-mode", "loopback", "--gen-key")
+	gpg.Stdin = bytes.NewBufferString(params)
+	if out, err := gpg.CombinedOutput(); err != nil {
+		cleanup()
+		t.Fatalf("gpg --gen-key: %s\n%s", err, out)
+	}
 
-	for i := range list {
-		fmt.Printf("%v\n", list[i])
-		assert.NotEmpty(t, list[i].Name)
-		assert.NotEmpty(t, list[i].Email)
+	keys, err := engine.ListSecretKeys("")
+	if err != nil || len(keys) != 1 {
+		cleanup()
+		t.Fatalf("generated %d keys: %v", len(keys), err)
 	}
+	return engine, keys[0], cleanup
+}
+
+// exchangeKeys lets each engine know the public key of the other.
+func exchangeKeys(t *testing.T, a *GPGEngine, aKey Key, b *GPGEngine, bKey Key) {
+	for _, x := range []struct {
+		from, to *GPGEngine
+		key      Key
+	}{{a, b, aKey}, {b, a, bKey}} {
+		d, err := x.from.ExportKey(x.key.Fingerprint)
+		assert.Nil(t, err)
+		assert.Nil(t, x.to.ImportKey(bytes.NewReader(d)))
+	}
+}
+
+func TestGPGListSecretKeys(t *testing.T) {
+	engine, key, cleanup := newTestEngine(t, "alice", "")
+	defer cleanup()
+
+	assert.Equal(t, "alice", key.Name)
+	assert.Equal(t, "alice@example.com", key.Email)
+	assert.Equal(t, 8, len(key.PublicKey))
+	assert.Equal(t, 40, len(key.Fingerprint))
+	assert.False(t, KeyMatches(key.Fingerprint, key.PublicKey))
+	assert.True(t, KeyMatches(key.Fingerprint, key.Fingerprint[24:]))
+	assert.True(t, KeyMatches(key.Fingerprint, strings.ToLower(key.Fingerprint)))
+	assert.True(t, IsFingerprint(key.Fingerprint))
+	assert.False(t, IsFingerprint(key.Fingerprint[24:]))
+	assert.False(t, key.CreatedAt.IsZero())
+
+	list, err := engine.ListSecretKeys("nobody@example.com")
+	assert.Nil(t, err)
+	assert.Empty(t, list)
 }
 
 func TestGPGListPublicKeys(t *testing.T) {
-	list, err := GPGListPublicKeys("")
+	alice, aliceKey, cleanup := newTestEngine(t, "alice", "")
+	defer cleanup()
+	bob, bobKey, cleanup2 := newTestEngine(t, "bob", "")
+	defer cleanup2()
+
+	list, err := alice.ListPublicKeys("")
 	assert.Nil(t, err)
-	assert.True(t, len(list) > 0)
+	assert.Equal(t, 1, len(list))
 
-	for i := range list {
-		fmt.Printf("%v\n", list[i])
-		assert.NotEmpty(t, list[i].Name)
-		assert.NotEmpty(t, list[i].Email)
+	exchangeKeys(t, alice, aliceKey, bob, bobKey)
+	list, err = alice.ListPublicKeys("bob@example.com")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(list)) {
+		assert.Equal(t, bobKey.Fingerprint, list[0].Fingerprint)
 	}
+	_, err = alice.ExportKey("nobody@example.com")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// a key imported by bob stays in bob's keyring
+	list, err = bob.ListSecretKeys("")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(list))
 }
 
 func TestGPGEncrypt(t *testing.T) {
+	alice, aliceKey, cleanup := newTestEngine(t, "alice", "")
+	defer cleanup()
+	bob, bobKey, cleanup2 := newTestEngine(t, "bob", "")
+	defer cleanup2()
+	exchangeKeys(t, alice, aliceKey, bob, bobKey)
+
 	src := []byte("hello")
-	uid := "B44966D6"
-	recipient := "B44966D6"
-	dst, err := GPGEncrypt(uid, recipient, bytes.NewBuffer(src))
+	dst, err := alice.Encrypt(aliceKey.PublicKey, bobKey.PublicKey, bytes.NewBuffer(src))
 	assert.Nil(t, err)
 	assert.NotEmpty(t, dst)
 
-	src2, err := GPGDecrypt(uid, bytes.NewBuffer(dst))
+	src2, sig, err := bob.DecryptVerify(bobKey.PublicKey, bytes.NewBuffer(dst))
 	assert.Nil(t, err)
 	assert.Equal(t, src, src2)
+	assert.True(t, sig.Good())
+	assert.Equal(t, aliceKey.Fingerprint, sig.Fingerprint)
+	// bob never certified alice's key
+	assert.Equal(t, TrustUndefined, sig.Trust)
+
+	// only bob can read it
+	_, err = alice.Decrypt(aliceKey.PublicKey, bytes.NewBuffer(dst))
+	assert.NotNil(t, err)
+}
+
+func TestGPGEncryptTo(t *testing.T) {
+	alice, aliceKey, cleanup := newTestEngine(t, "alice", "")
+	defer cleanup()
+	bob, bobKey, cleanup2 := newTestEngine(t, "bob", "")
+	defer cleanup2()
+	carol, carolKey, cleanup3 := newTestEngine(t, "carol", "")
+	defer cleanup3()
+	exchangeKeys(t, alice, aliceKey, bob, bobKey)
+	exchangeKeys(t, alice, aliceKey, carol, carolKey)
+
+	// one message both can read
+	dst, err := alice.EncryptTo(aliceKey.PublicKey, []string{bobKey.PublicKey, carolKey.PublicKey}, bytes.NewBufferString("hello"))
+	assert.Nil(t, err)
+	for _, x := range []struct {
+		engine *GPGEngine
+		key    Key
+	}{{bob, bobKey}, {carol, carolKey}} {
+		src, sig, err := x.engine.DecryptVerify(x.key.PublicKey, bytes.NewReader(dst))
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(src))
+		assert.True(t, sig.Good())
+	}
+
+	_, err = alice.EncryptTo(aliceKey.PublicKey, nil, bytes.NewBufferString("hello"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestGPGClearSign(t *testing.T) {
+	alice, aliceKey, cleanup := newTestEngine(t, "alice", "")
+	defer cleanup()
+	bob, bobKey, cleanup2 := newTestEngine(t, "bob", "")
+	defer cleanup2()
+
+	signed, err := alice.ClearSign(aliceKey.PublicKey, bytes.NewBufferString("hello\n"))
+	assert.Nil(t, err)
+
+	// bob doesn't know alice's key yet
+	_, _, err = bob.Verify(bytes.NewReader(signed))
+	assert.Equal(t, ErrBadSignature, err)
+
+	exchangeKeys(t, alice, aliceKey, bob, bobKey)
+	signer, content, err := bob.Verify(bytes.NewReader(signed))
+	assert.Nil(t, err)
+	assert.Equal(t, aliceKey.Fingerprint, signer)
+	assert.Equal(t, "hello\n", string(content))
+}
+
+func TestGPGVerifyWithKey(t *testing.T) {
+	alice, aliceKey, cleanup := newTestEngine(t, "alice", "")
+	defer cleanup()
+	bob, bobKey, cleanup2 := newTestEngine(t, "bob", "")
+	defer cleanup2()
+
+	signed, err := alice.ClearSign(aliceKey.PublicKey, bytes.NewBufferString("hello\n"))
+	assert.Nil(t, err)
+	aliceData, err := alice.ExportKey(aliceKey.Fingerprint)
+	assert.Nil(t, err)
+	bobData, err := bob.ExportKey(bobKey.Fingerprint)
+	assert.Nil(t, err)
+
+	// checked against the key given, not the keyring, which is left alone
+	signer, content, err := bob.VerifyWithKey(aliceData, bytes.NewReader(signed))
+	assert.Nil(t, err)
+	assert.Equal(t, aliceKey.Fingerprint, signer)
+	assert.Equal(t, "hello\n", string(content))
+	keys, _ := bob.ListPublicKeys(aliceKey.Fingerprint)
+	assert.Equal(t, 0, len(keys))
+
+	_, _, err = alice.VerifyWithKey(bobData, bytes.NewReader(signed))
+	assert.Equal(t, ErrBadSignature, err)
+}
+
+func TestGPGImportMatchingKeys(t *testing.T) {
+	alice, aliceKey, cleanup := newTestEngine(t, "alice", "")
+	defer cleanup()
+	bob, bobKey, cleanup2 := newTestEngine(t, "bob", "")
+	defer cleanup2()
+	carol, carolKey, cleanup3 := newTestEngine(t, "carol", "")
+	defer cleanup3()
+
+	bobData, err := bob.ExportKey(bobKey.Fingerprint)
+	assert.Nil(t, err)
+	carolData, err := carol.ExportKey(carolKey.Fingerprint)
+	assert.Nil(t, err)
+	both := append(append([]byte{}, bobData...), carolData...)
+
+	_, err = alice.ImportMatchingKeys(bytes.NewReader(both), "<dave@example.com>")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// only bob's key gets in
+	keys, err := alice.ImportMatchingKeys(bytes.NewReader(both), "<bob@example.com>")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(keys)) {
+		assert.Equal(t, bobKey.Fingerprint, keys[0].Fingerprint)
+	}
+	listed, _ := alice.ListPublicKeys("")
+	var fingerprints []string
+	for _, k := range listed {
+		fingerprints = append(fingerprints, k.Fingerprint)
+	}
+	assert.ElementsMatch(t, []string{aliceKey.Fingerprint, bobKey.Fingerprint}, fingerprints)
+
+	keys, err = alice.ImportMatchingKeys(bytes.NewReader(both), carolKey.Fingerprint)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(keys))
+}
+
+func TestGPGPassphrase(t *testing.T) {
+	engine, key, cleanup := newTestEngine(t, "carol", "open sesame")
+	defer cleanup()
+
+	_, err := engine.ClearSign(key.PublicKey, bytes.NewBufferString("hello\n"))
+	assert.Nil(t, err)
+
+	// a fresh agent, so the right passphrase isn't cached
+	exec.Command("gpgconf", "--homedir", engine.Home(), "--kill", "gpg-agent").Run()
+	wrong, err := NewGPGEngineWithOptions(&GPGEngineOptions{
+		Home:       engine.Home(),
+		Passphrase: StaticPassphrase("wrong"),
+	})
+	assert.Nil(t, err)
+	_, err = wrong.ClearSign(key.PublicKey, bytes.NewBufferString("hello\n"))
+	assert.NotNil(t, err)
 }
@@ -0,0 +1,155 @@
+package crypto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/nklizhe/gopass"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PassphraseEnv is where EnvPassphrase looks by default.
+const PassphraseEnv = "TALKIE_PASSPHRASE"
+
+var (
+	ErrUseAgent     = errors.New("passphrase is left to gpg-agent")
+	ErrNoPassphrase = errors.New("no passphrase available")
+
+	ErrInvalidPassphraseSource = errors.New("passphrase source must be agent, tty, env, env:<name> or fd:<n>")
+)
+
+// PassphraseProvider unlocks secret keys. gpg gets the passphrase it returns
+// through --pinentry-mode loopback; ErrUseAgent leaves asking to gpg-agent.
+type PassphraseProvider interface {
+	Passphrase(uid string) (string, error)
+}
+
+// DefaultPassphraseProvider is used by the GPG functions and engines
+// without a provider of their own.
+var DefaultPassphraseProvider PassphraseProvider = AgentPassphrase{}
+
+// AgentPassphrase lets gpg-agent and its pinentry ask, as gpg does by itself.
+type AgentPassphrase struct{}
+
+func (AgentPassphrase) Passphrase(uid string) (string, error) {
+	return "", ErrUseAgent
+}
+
+// TerminalPassphrase asks on the terminal, once per key.
+type TerminalPassphrase struct {
+	mutex sync.Mutex
+	known map[string]string
+}
+
+func NewTerminalPassphrase() *TerminalPassphrase {
+	return &TerminalPassphrase{
+		known: make(map[string]string),
+	}
+}
+
+func (p *TerminalPassphrase) Passphrase(uid string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if passphrase, ok := p.known[uid]; ok {
+		return passphrase, nil
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase for key %s: ", uid)
+	passphrase := string(gopass.GetPasswd())
+	if passphrase == "" {
+		return "", ErrNoPassphrase
+	}
+	p.known[uid] = passphrase
+	return passphrase, nil
+}
+
+// EnvPassphrase reads the passphrase from the environment variable Name, or
+// PassphraseEnv if Name is empty. Meant for scripts and daemons.
+type EnvPassphrase struct {
+	Name string
+}
+
+func (p EnvPassphrase) Passphrase(uid string) (string, error) {
+	name := p.Name
+	if name == "" {
+		name = PassphraseEnv
+	}
+	passphrase := os.Getenv(name)
+	if passphrase == "" {
+		return "", ErrNoPassphrase
+	}
+	return passphrase, nil
+}
+
+// FDPassphrase reads the passphrase from the first line of an open file
+// descriptor, like gpg's --passphrase-fd. The descriptor is read once.
+type FDPassphrase struct {
+	FD uintptr
+
+	once       sync.Once
+	passphrase string
+	err        error
+}
+
+func NewFDPassphrase(fd uintptr) *FDPassphrase {
+	return &FDPassphrase{
+		FD: fd,
+	}
+}
+
+func (p *FDPassphrase) Passphrase(uid string) (string, error) {
+	p.once.Do(func() {
+		f := os.NewFile(p.FD, "passphrase")
+		if f == nil {
+			p.err = ErrNoPassphrase
+			return
+		}
+		defer f.Close()
+		line, err := bufio.NewReader(f).ReadString('\n')
+		p.passphrase = strings.TrimRight(line, "\r\n")
+		if p.passphrase == "" {
+			p.err = ErrNoPassphrase
+			if err != nil {
+				p.err = err
+			}
+		}
+	})
+	return p.passphrase, p.err
+}
+
+// StaticPassphrase always answers with itself, for tests.
+type StaticPassphrase string
+
+func (p StaticPassphrase) Passphrase(uid string) (string, error) {
+	return string(p), nil
+}
+
+// ParsePassphraseSource returns the provider for a command line setting:
+//
+//	agent       leave it to gpg-agent and its pinentry (default)
+//	tty         ask on the terminal
+//	env[:NAME]  read $TALKIE_PASSPHRASE, or $NAME
+//	fd:N        read the first line of file descriptor N
+func ParsePassphraseSource(source string) (PassphraseProvider, error) {
+	kind, arg := source, ""
+	if i := strings.Index(source, ":"); i >= 0 {
+		kind, arg = source[:i], source[i+1:]
+	}
+	switch kind {
+	case "", "agent":
+		return AgentPassphrase{}, nil
+	case "tty":
+		return NewTerminalPassphrase(), nil
+	case "env":
+		return EnvPassphrase{Name: arg}, nil
+	case "fd":
+		fd, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			return nil, ErrInvalidPassphraseSource
+		}
+		return NewFDPassphrase(uintptr(fd)), nil
+	}
+	return nil, ErrInvalidPassphraseSource
+}
@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPassphraseProviders(t *testing.T) {
+	_, err := AgentPassphrase{}.Passphrase("B44966D6")
+	assert.Equal(t, ErrUseAgent, err)
+
+	passphrase, err := StaticPassphrase("secret").Passphrase("B44966D6")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", passphrase)
+
+	os.Setenv("TALKIE_TEST_PASSPHRASE", "from env")
+	defer os.Unsetenv("TALKIE_TEST_PASSPHRASE")
+	passphrase, err = EnvPassphrase{Name: "TALKIE_TEST_PASSPHRASE"}.Passphrase("B44966D6")
+	assert.Nil(t, err)
+	assert.Equal(t, "from env", passphrase)
+	_, err = EnvPassphrase{Name: "TALKIE_TEST_NO_PASSPHRASE"}.Passphrase("B44966D6")
+	assert.Equal(t, ErrNoPassphrase, err)
+
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	w.WriteString("from fd\nignored\n")
+	w.Close()
+	fd := NewFDPassphrase(r.Fd())
+	passphrase, err = fd.Passphrase("B44966D6")
+	assert.Nil(t, err)
+	assert.Equal(t, "from fd", passphrase)
+	// read once, asked many times
+	passphrase, err = fd.Passphrase("B44966D6")
+	assert.Nil(t, err)
+	assert.Equal(t, "from fd", passphrase)
+}
+
+func TestParsePassphraseSource(t *testing.T) {
+	p, err := ParsePassphraseSource("agent")
+	assert.Nil(t, err)
+	assert.Equal(t, AgentPassphrase{}, p)
+
+	p, err = ParsePassphraseSource("env:TALKIE_TEST_PASSPHRASE")
+	assert.Nil(t, err)
+	assert.Equal(t, EnvPassphrase{Name: "TALKIE_TEST_PASSPHRASE"}, p)
+
+	p, err = ParsePassphraseSource("fd:3")
+	assert.Nil(t, err)
+	assert.Equal(t, uintptr(3), p.(*FDPassphrase).FD)
+
+	_, err = ParsePassphraseSource("fd:three")
+	assert.Equal(t, ErrInvalidPassphraseSource, err)
+	_, err = ParsePassphraseSource("sticky-note")
+	assert.Equal(t, ErrInvalidPassphraseSource, err)
+}
+
+func TestSecretKeyCommand(t *testing.T) {
+	gpg, err := (&GPGEngine{passphrase: AgentPassphrase{}}).secretKeyCommand("B44966D6", "--clearsign")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{GPGPath, "-u", "B44966D6", "--clearsign"}, gpg.Args)
+	assert.Empty(t, gpg.ExtraFiles)
+
+	engine := &GPGEngine{home: "/tmp/talkie-gnupg", passphrase: StaticPassphrase("secret")}
+	gpg, err = engine.secretKeyCommand("B44966D6", "--clearsign")
+	assert.Nil(t, err)
+	defer closeExtraFiles(gpg)
+	assert.Equal(t, []string{GPGPath, "--homedir", "/tmp/talkie-gnupg", "--batch", "--pinentry-mode", "loopback", "--passphrase-fd", "3", "-u", "B44966D6", "--clearsign"}, gpg.Args)
+	if assert.Equal(t, 1, len(gpg.ExtraFiles)) {
+		d, err := ioutil.ReadAll(gpg.ExtraFiles[0])
+		assert.Nil(t, err)
+		assert.Equal(t, "secret\n", string(d))
+	}
+
+	engine = &GPGEngine{passphrase: EnvPassphrase{Name: "TALKIE_TEST_NO_PASSPHRASE"}}
+	_, err = engine.secretKeyCommand("B44966D6", "--clearsign")
+	assert.Equal(t, ErrNoPassphrase, err)
+}
@@ -0,0 +1,44 @@
+package crypto
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"strings"
+)
+
+// SafetyNumber is what two people compare out of band, in person or on a
+// call, to make sure each has the other's key. It is 12 groups of 5 digits
+// derived from both fingerprints, the same on either side.
+func SafetyNumber(a, b string) string {
+	a, b = normalizeFingerprint(a), normalizeFingerprint(b)
+	if b < a {
+		a, b = b, a
+	}
+	sum := sha512.Sum512([]byte(a + b))
+
+	groups := make([]string, 12)
+	for i := range groups {
+		var n uint64
+		for _, c := range sum[i*5 : i*5+5] {
+			n = n<<8 | uint64(c)
+		}
+		groups[i] = fmt.Sprintf("%05d", n%100000)
+	}
+	return strings.Join(groups, " ")
+}
+
+// FormatFingerprint splits a fingerprint in groups of 4 hex digits, the way
+// gpg prints it.
+func FormatFingerprint(fingerprint string) string {
+	fingerprint = normalizeFingerprint(fingerprint)
+	var groups []string
+	for len(fingerprint) > 4 {
+		groups = append(groups, fingerprint[:4])
+		fingerprint = fingerprint[4:]
+	}
+	return strings.Join(append(groups, fingerprint), " ")
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Replace(fingerprint, " ", "", -1))
+}
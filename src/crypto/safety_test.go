@@ -0,0 +1,23 @@
+package crypto
+
+import (
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestSafetyNumber(t *testing.T) {
+	alice := "0123456789ABCDEF0123456789ABCDEFAAAA1111"
+	bob := "0123456789ABCDEF0123456789ABCDEFBBBB2222"
+
+	n := SafetyNumber(alice, bob)
+	assert.Regexp(t, regexp.MustCompile(`^\d{5}( \d{5}){11}$`), n)
+	// both sides see the same number, however they write fingerprints
+	assert.Equal(t, n, SafetyNumber("0123 4567 89ab cdef 0123  4567 89ab cdef bbbb 2222", alice))
+	assert.NotEqual(t, n, SafetyNumber(alice, "0123456789ABCDEF0123456789ABCDEFCCCC3333"))
+}
+
+func TestFormatFingerprint(t *testing.T) {
+	assert.Equal(t, "0123 4567 89AB CDEF 0123 4567 89AB CDEF AAAA 1111", FormatFingerprint("0123456789abcdef0123456789abcdefaaaa1111"))
+	assert.Equal(t, "AAAA 1111", FormatFingerprint("AAAA1111"))
+}
@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"strings"
+)
+
+// SignatureStatus is what gpg made of the signature on a message.
+type SignatureStatus int
+
+const (
+	SigMissing    SignatureStatus = iota // not signed at all
+	SigGood                              // good signature
+	SigBad                               // signature doesn't match the content
+	SigUnknownKey                        // signing key is not in the keyring
+	SigExpiredKey                        // good signature, but the key has expired
+	SigRevokedKey                        // good signature, but the key was revoked
+)
+
+var signatureStatusNames = map[SignatureStatus]string{
+	SigMissing:    "missing",
+	SigGood:       "good",
+	SigBad:        "bad",
+	SigUnknownKey: "unknown key",
+	SigExpiredKey: "expired key",
+	SigRevokedKey: "revoked key",
+}
+
+func (s SignatureStatus) String() string {
+	return signatureStatusNames[s]
+}
+
+// the status lines telling how checking a signature went
+var statusLines = map[string]SignatureStatus{
+	"GOODSIG":   SigGood,
+	"BADSIG":    SigBad,
+	"ERRSIG":    SigUnknownKey,
+	"EXPKEYSIG": SigExpiredKey,
+	"REVKEYSIG": SigRevokedKey,
+}
+
+// Trust is how much the keyring trusts the signing key to belong to who it
+// says, as in gpg's TRUST_ status lines.
+type Trust int
+
+const (
+	TrustUndefined Trust = iota
+	TrustNever
+	TrustMarginal
+	TrustFully
+	TrustUltimate
+)
+
+var trustNames = map[string]Trust{
+	"TRUST_UNDEFINED": TrustUndefined,
+	"TRUST_NEVER":     TrustNever,
+	"TRUST_MARGINAL":  TrustMarginal,
+	"TRUST_FULLY":     TrustFully,
+	"TRUST_ULTIMATE":  TrustUltimate,
+}
+
+func (t Trust) String() string {
+	switch t {
+	case TrustNever:
+		return "never"
+	case TrustMarginal:
+		return "marginal"
+	case TrustFully:
+		return "full"
+	case TrustUltimate:
+		return "ultimate"
+	}
+	return "undefined"
+}
+
+// Signature is the outcome of checking the signature on a message.
+type Signature struct {
+	Status      SignatureStatus
+	Fingerprint string // of the primary signing key, or its key ID if that's all gpg knows
+	Trust       Trust
+}
+
+// Good reports whether the message was signed by a key that was valid when
+// it signed.
+func (s *Signature) Good() bool {
+	return s != nil && s.Status == SigGood
+}
+
+// parseStatus reads gpg's --status-fd output.
+func parseStatus(status string) *Signature {
+	sig := &Signature{}
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "[GNUPG:]" {
+			continue
+		}
+		switch fields[1] {
+		case "VALIDSIG":
+			// VALIDSIG <fingerprint> <date> ... [<primary key fingerprint>]
+			if len(fields) > 11 {
+				sig.Fingerprint = fields[11]
+			} else if len(fields) > 2 {
+				sig.Fingerprint = fields[2]
+			}
+		default:
+			if status, ok := statusLines[fields[1]]; ok {
+				// <key ID> <user ID>, VALIDSIG has the full fingerprint
+				if sig.Fingerprint == "" && len(fields) > 2 {
+					sig.Fingerprint = fields[2]
+				}
+				sig.Status = status
+			} else if trust, ok := trustNames[fields[1]]; ok {
+				sig.Trust = trust
+			}
+		}
+	}
+	return sig
+}
@@ -0,0 +1,30 @@
+package crypto
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	good := `[GNUPG:] DECRYPTION_OKAY
+[GNUPG:] GOODSIG 4F1EC2D4B44966D6 Tester <tester@example.com>
+[GNUPG:] VALIDSIG 0123456789ABCDEF0123456789ABCDEF01234567 2015-01-24 1422100000 0 4 0 1 8 00 89ABCDEF0123456789ABCDEF4F1EC2D4B44966D6
+[GNUPG:] TRUST_ULTIMATE`
+	sig := parseStatus(good)
+	assert.True(t, sig.Good())
+	assert.Equal(t, "89ABCDEF0123456789ABCDEF4F1EC2D4B44966D6", sig.Fingerprint)
+	assert.Equal(t, TrustUltimate, sig.Trust)
+
+	sig = parseStatus("[GNUPG:] DECRYPTION_OKAY\n[GNUPG:] BADSIG 4F1EC2D4B44966D6 Tester <tester@example.com>")
+	assert.False(t, sig.Good())
+	assert.Equal(t, SigBad, sig.Status)
+	assert.Equal(t, "4F1EC2D4B44966D6", sig.Fingerprint)
+
+	sig = parseStatus("[GNUPG:] ERRSIG 4F1EC2D4B44966D6 1 8 00 1422100000 9")
+	assert.Equal(t, SigUnknownKey, sig.Status)
+
+	sig = parseStatus("[GNUPG:] DECRYPTION_OKAY")
+	assert.Equal(t, SigMissing, sig.Status)
+	assert.Equal(t, "", sig.Fingerprint)
+	assert.Equal(t, TrustUndefined, sig.Trust)
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+var (
+	ErrNoRoute          = errors.New("no such resource")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+)
+
+// responseAPIError replies with the /v1 error envelope:
+// {"success": false, "error": "<message>", "code": "<code>"}
+func responseAPIError(w http.ResponseWriter, err *apiError) {
+	if err.retryAfter > 0 {
+		responseRateLimited(w, err.retryAfter)
+		return
+	}
+	responseErrorCode(w, err.status, err.code, err)
+}
+
+// responseCreated replies 201 with obj as data.
+func responseCreated(w http.ResponseWriter, obj interface{}) {
+	res := &Response{
+		Success: true,
+		Data:    obj,
+	}
+	d, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(d)
+}
+
+// POST /v1/users
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRegisterBodySize)
+
+	// either a plain user, whose key the server fetches from the keyserver,
+	// or a KeyRegistration carrying the key itself
+	var req struct {
+		common.User
+		common.KeyRegistration
+	}
+	if err := parseJSON(r.Body, &req); err != nil {
+		responseAPIError(w, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err))
+		return
+	}
+
+	user := &req.User
+	var apiErr *apiError
+	if req.Request != "" {
+		user, apiErr = s.registerKey(&req.KeyRegistration)
+	} else {
+		apiErr = s.registerUser(user)
+	}
+	if apiErr != nil {
+		responseAPIError(w, apiErr)
+		return
+	}
+	responseCreated(w, user)
+}
+
+// GET /v1/keys?email=<email>
+func (s *Server) getKeys(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("email")
+	if email == "" {
+		responseAPIError(w, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidUser))
+		return
+	}
+	keys, apiErr := s.lookupKeys(email)
+	if apiErr != nil {
+		responseAPIError(w, apiErr)
+		return
+	}
+
+	responseSuccess(w, &keys)
+}
+
+// GET /v1/messages?key=<key>[&encrypt=1]
+func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if key == "" {
+		responseAPIError(w, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidUser))
+		return
+	}
+	messages, err := s.mailboxMessages(key)
+	if err != nil {
+		responseAPIError(w, internalError(err))
+		return
+	}
+
+	res := &Response{
+		Success: true,
+		Data:    &messages,
+	}
+	if err := s.writeMessages(w, key, r.FormValue("encrypt") == "1", res); err != nil {
+		responseAPIError(w, internalError(err))
+	}
+}
+
+// POST /v1/messages
+func (s *Server) createMessage(w http.ResponseWriter, r *http.Request) {
+	msg, err := s.parseMessage(w, r)
+	if err == nil {
+		err = s.sendMessage(msg)
+	}
+	if err != nil {
+		responseAPIError(w, err)
+		return
+	}
+	responseCreated(w, msg.MessageID)
+}
+
+// GET /v1/messages/{id}
+func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) {
+	msg, err := s.findMessage(mux.Vars(r)["id"])
+	if err != nil {
+		responseAPIError(w, err)
+		return
+	}
+	msg.Content = nil
+	responseSuccess(w, msg)
+}
+
+// DELETE /v1/messages/{id} with a clearsigned DeleteRequest
+func (s *Server) removeMessage(w http.ResponseWriter, r *http.Request) {
+	if err := s.deleteMessage(mux.Vars(r)["id"], http.MaxBytesReader(w, r.Body, maxRuleBodySize)); err != nil {
+		responseAPIError(w, err)
+		return
+	}
+	responseSuccess(w, nil)
+}
+
+// GET /v1/messages/{id}/content
+func (s *Server) getMessageContent(w http.ResponseWriter, r *http.Request) {
+	msg, err := s.findMessage(mux.Vars(r)["id"])
+	if err != nil {
+		responseAPIError(w, err)
+		return
+	}
+	s.writeContent(w, r, msg)
+}
+
+// POST /v1/messages/{id}/receipt with a clearsigned ReceiptRequest
+func (s *Server) createReceipt(w http.ResponseWriter, r *http.Request) {
+	if err := s.acknowledgeMessage(mux.Vars(r)["id"], http.MaxBytesReader(w, r.Body, maxRuleBodySize)); err != nil {
+		responseAPIError(w, err)
+		return
+	}
+	responseSuccess(w, nil)
+}
+
+// POST /v1/rules
+func (s *Server) createRule(w http.ResponseWriter, r *http.Request) {
+	rule, err := s.applySenderRule(http.MaxBytesReader(w, r.Body, maxRuleBodySize))
+	if err != nil {
+		responseAPIError(w, err)
+		return
+	}
+	responseCreated(w, rule)
+}
+
+// POST /v1/groups with a clearsigned GroupRequest
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := s.applyGroup(http.MaxBytesReader(w, r.Body, maxRegisterBodySize))
+	if err != nil {
+		responseAPIError(w, err)
+		return
+	}
+	responseCreated(w, group)
+}
+
+// GET /v1/groups?key=<key>
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if key == "" {
+		responseAPIError(w, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidUser))
+		return
+	}
+	groups, err := s.store.GetGroupsOf(key)
+	if err != nil {
+		responseAPIError(w, internalError(err))
+		return
+	}
+	responseSuccess(w, &groups)
+}
+
+// GET /v1/groups/{name}?key=<key>
+func (s *Server) getGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := s.store.FindGroup(mux.Vars(r)["name"])
+	// only those in the group learn who else is
+	if err == common.ErrNoResult || (err == nil && !group.Includes(r.FormValue("key"))) {
+		responseAPIError(w, newAPIError(http.StatusNotFound, common.ErrCodeNotFound, ErrGroupNotFound))
+		return
+	}
+	if err != nil {
+		responseAPIError(w, internalError(err))
+		return
+	}
+	responseSuccess(w, group)
+}
+
+// GET /v1/openapi.json
+func openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPIDocument))
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// comment lines sent this often keep idle streams from being cut by proxies
+	eventHeartbeat = 30 * time.Second
+
+	// events queued per subscriber, more are dropped for a slow reader
+	eventBacklog = 16
+)
+
+var (
+	ErrStreamingUnsupported = errors.New("streaming unsupported")
+)
+
+// eventHub hands events to the subscribers of a key.
+type eventHub struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan *common.Event]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subscribers: make(map[string]map[chan *common.Event]bool),
+	}
+}
+
+func (h *eventHub) subscribe(key string) chan *common.Event {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ch := make(chan *common.Event, eventBacklog)
+	if h.subscribers == nil {
+		// closed
+		close(ch)
+		return ch
+	}
+	if h.subscribers[key] == nil {
+		h.subscribers[key] = make(map[chan *common.Event]bool)
+	}
+	h.subscribers[key][ch] = true
+	return ch
+}
+
+func (h *eventHub) unsubscribe(key string, ch chan *common.Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if subs, ok := h.subscribers[key]; ok && subs[ch] {
+		delete(subs, ch)
+		if len(subs) == 0 {
+			delete(h.subscribers, key)
+		}
+		close(ch)
+	}
+}
+
+func (h *eventHub) publish(key string, ev *common.Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for ch := range h.subscribers[key] {
+		select {
+		case ch <- ev:
+		default:
+			// never block delivery on a slow subscriber
+		}
+	}
+}
+
+// close ends every subscription.
+func (h *eventHub) close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, subs := range h.subscribers {
+		for ch := range subs {
+			close(ch)
+		}
+	}
+	h.subscribers = nil
+}
+
+// publish tells the subscribers of key about msg, without its content.
+func (s *Server) publish(key, eventType string, msg *common.Message) {
+	m := *msg
+	m.Content = nil
+	s.events.publish(key, &common.Event{
+		Type:      eventType,
+		Message:   &m,
+		CreatedAt: s.config.Clock.Now(),
+	})
+}
+
+// POST /v1/events
+//
+// The body is a SubscribeRequest clearsigned by the subscribed key. The
+// response is a text/event-stream which lasts until either side hangs up.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	var req common.SubscribeRequest
+	signer, apiErr := s.verifyRequest(http.MaxBytesReader(w, r.Body, maxRuleBodySize), &req)
+	if apiErr == nil && req.Key == "" {
+		apiErr = newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidUser)
+	}
+	if apiErr == nil {
+		apiErr = s.checkSigner(signer, req.Key, req.CreatedAt)
+	}
+	if apiErr != nil {
+		responseAPIError(w, apiErr)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		responseAPIError(w, internalError(ErrStreamingUnsupported))
+		return
+	}
+
+	ch := s.events.subscribe(req.Key)
+	defer s.events.unsubscribe(req.Key, ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, ": subscribed\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			d, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, d); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprintf(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const maxGroupMembers = 100
+
+var (
+	ErrGroupNotFound  = errors.New("group not found")
+	ErrGroupTaken     = errors.New("group belongs to someone else")
+	ErrTooManyMembers = errors.New("too many group members")
+	ErrNotMember      = errors.New("only members can send to the group")
+	ErrSealedGroup    = errors.New("messages to a group can't be sealed")
+	ErrNoRecipients   = errors.New("nobody else in the group")
+)
+
+// applyGroup verifies a signed GroupRequest and creates, changes or
+// deletes the group. Only the owner of a group may touch it.
+func (s *Server) applyGroup(body io.Reader) (*common.Group, *apiError) {
+	var req common.GroupRequest
+	signer, apiErr := s.verifyRequest(body, &req)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.checkSigner(signer, req.Owner, req.CreatedAt); apiErr != nil {
+		return nil, apiErr
+	}
+	if !common.ValidGroupName(req.Name) {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, common.ErrInvalidGroup)
+	}
+	if len(req.Members) > maxGroupMembers {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrTooManyMembers)
+	}
+
+	existing, err := s.store.FindGroup(req.Name)
+	if err != nil && err != common.ErrNoResult {
+		return nil, internalError(err)
+	}
+	if existing != nil && !strings.EqualFold(existing.Owner, req.Owner) {
+		return nil, newAPIError(http.StatusForbidden, common.ErrCodeForbidden, ErrGroupTaken)
+	}
+
+	if req.Remove {
+		if existing == nil {
+			return nil, newAPIError(http.StatusNotFound, common.ErrCodeNotFound, ErrGroupNotFound)
+		}
+		if err := s.store.DeleteGroup(req.Name); err != nil {
+			return nil, internalError(err)
+		}
+		return existing, nil
+	}
+
+	group := &req.Group
+	// the members are known by their keys, the store has the rest
+	for i, m := range group.Members {
+		if m == nil || m.Key == "" {
+			return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, common.ErrInvalidGroup)
+		}
+		group.Members[i] = &common.User{Key: m.Key}
+	}
+	if err := s.store.SetGroup(group); err != nil {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err)
+	}
+	if group, err = s.store.FindGroup(group.Name); err != nil {
+		return nil, internalError(err)
+	}
+	return group, nil
+}
+
+// checkGroup returns the group msg goes to, if its sender may send to it.
+func (s *Server) checkGroup(msg *common.Message) (*common.Group, *apiError) {
+	if msg.Sealed {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrSealedGroup)
+	}
+	if msg.From == nil {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidMessage)
+	}
+	group, err := s.store.FindGroup(msg.Group)
+	if err == common.ErrNoResult {
+		return nil, newAPIError(http.StatusNotFound, common.ErrCodeNotFound, ErrGroupNotFound)
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	if !group.Includes(msg.From.Key) {
+		return nil, newAPIError(http.StatusForbidden, common.ErrCodeForbidden, ErrNotMember)
+	}
+	return group, nil
+}
+
+// deliverGroupMessage puts a copy of msg in the mailbox of everyone in its
+// group but the sender. Those whose mailbox is full or who block the sender
+// go without; the message fails only if nobody gets it. msg gets the ID of
+// the first copy.
+func (s *Server) deliverGroupMessage(msg *common.Message) *apiError {
+	group, apiErr := s.checkGroup(msg)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	recipients := append([]*common.User{{Key: group.Owner}}, group.Members...)
+	seen := map[string]bool{strings.ToUpper(msg.From.Key): true}
+	apiErr = newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrNoRecipients)
+	var firstID int64
+	for _, to := range recipients {
+		if seen[strings.ToUpper(to.Key)] {
+			continue
+		}
+		seen[strings.ToUpper(to.Key)] = true
+
+		m := *msg
+		m.MessageID = 0
+		m.To = to
+		if err := s.checkDelivery(&m, int64(len(m.Content))); err != nil {
+			apiErr = err
+			continue
+		}
+		if err := s.store.AddMessage(&m); err != nil {
+			return internalError(err)
+		}
+		s.publish(to.Key, common.EventMessage, &m)
+		if firstID == 0 {
+			firstID = m.MessageID
+		}
+	}
+	if firstID == 0 {
+		return apiErr
+	}
+	msg.MessageID = firstID
+	return nil
+}
@@ -1,27 +1,79 @@
-package main
+package server
 
 import (
 	"fmt"
-	"github.com/gorilla/mux"
+	"github.com/gophergala/gopher_talkie/src/common"
 	"net/http"
+	"strings"
 )
 
+// HTTPServer listens on host:port and hands every request to handler.
 type HTTPServer struct {
-	host   string
-	port   int
-	router *mux.Router
+	host    string
+	port    int
+	handler http.Handler
 }
 
-func NewHTTPServer(host string, port int) *HTTPServer {
-	r := mux.NewRouter()
+func NewHTTPServer(host string, port int, handler http.Handler) *HTTPServer {
 	return &HTTPServer{
-		host:   host,
-		port:   port,
-		router: r,
+		host:    host,
+		port:    port,
+		handler: handler,
 	}
 }
 
 func (s *HTTPServer) ListenAndServer() error {
-	http.Handle("/", s.router)
-	return http.ListenAndServe(fmt.Sprintf("%s:%d", s.host, s.port), nil)
+	return http.ListenAndServe(fmt.Sprintf("%s:%d", s.host, s.port), s.handler)
+}
+
+// ListenAndServeTLS serves HTTPS with the PEM encoded certificate and key.
+func (s *HTTPServer) ListenAndServeTLS(certFile, keyFile string) error {
+	return http.ListenAndServeTLS(fmt.Sprintf("%s:%d", s.host, s.port), certFile, keyFile, s.handler)
+}
+
+func (s *Server) routes() {
+	r := s.router
+
+	r.HandleFunc("/v1/users", s.limit(limitByIP(s.registerLimiter, s.createUser))).Methods("POST")
+	r.HandleFunc("/v1/keys", limitByIP(s.ipLimiter, s.getKeys)).Methods("GET")
+	r.HandleFunc("/v1/messages", s.limit(s.listMessages)).Methods("GET")
+	r.HandleFunc("/v1/messages", s.limit(s.createMessage)).Methods("POST")
+	r.HandleFunc("/v1/messages/{id}", s.limit(s.getMessage)).Methods("GET")
+	r.HandleFunc("/v1/messages/{id}", s.limit(s.removeMessage)).Methods("DELETE")
+	r.HandleFunc("/v1/messages/{id}/content", s.limit(s.getMessageContent)).Methods("GET")
+	r.HandleFunc("/v1/messages/{id}/receipt", s.limit(s.createReceipt)).Methods("POST")
+	r.HandleFunc("/v1/rules", s.limit(s.createRule)).Methods("POST")
+	r.HandleFunc("/v1/groups", s.limit(s.createGroup)).Methods("POST")
+	r.HandleFunc("/v1/groups", s.limit(s.listGroups)).Methods("GET")
+	r.HandleFunc("/v1/groups/{name}", s.limit(s.getGroup)).Methods("GET")
+	r.HandleFunc("/v1/uploads", s.limit(s.createUpload)).Methods("POST")
+	r.HandleFunc("/v1/uploads/{id}", s.limit(s.getUpload)).Methods("GET")
+	r.HandleFunc("/v1/uploads/{id}", s.limit(s.putUploadChunk)).Methods("PUT")
+	r.HandleFunc("/v1/uploads/{id}/finish", s.limit(s.finishUpload)).Methods("POST")
+	r.HandleFunc("/v1/events", limitByIP(s.ipLimiter, s.streamEvents)).Methods("POST")
+	r.HandleFunc("/v1/openapi.json", openAPI).Methods("GET")
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v1/") {
+			http.NotFound(w, r)
+			return
+		}
+		responseAPIError(w, newAPIError(http.StatusNotFound, common.ErrCodeNotFound, ErrNoRoute))
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responseAPIError(w, newAPIError(http.StatusMethodNotAllowed, common.ErrCodeMethodNotAllowed, ErrMethodNotAllowed))
+	})
+
+	// compatibility with clients from before /v1
+	r.HandleFunc("/register", s.limit(limitByIP(s.registerLimiter, s.register)))
+	r.HandleFunc("/send", s.limit(s.send))
+	r.HandleFunc("/messages", s.limit(s.messages))
+	r.HandleFunc("/m", s.limit(s.download))
+	r.HandleFunc("/rules", s.limit(s.rules))
+}
+
+// limit applies the per address rate limit to h. Signed requests are
+// limited per key too, once the signature says whose key it is: see
+// verifyRequest.
+func (s *Server) limit(h http.HandlerFunc) http.HandlerFunc {
+	return limitByIP(s.ipLimiter, h)
 }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrKeyEmailMismatch = errors.New("key has no user id with that email")
+	ErrKeyNotFound      = errors.New("no key for that email")
+)
+
+// registerKey registers the user of a KeyRegistration with the key it
+// carries. The request must be signed by that key, checked against the
+// uploaded key alone, and only then is the signing key imported, none of
+// the others uploaded along with it. The key must have a user id with the
+// user's email: the server hands the key out to whoever looks that email
+// up. Nothing proves the email belongs to the user though, anyone can put
+// any email in a user id of their key; clients pin the key they first get.
+func (s *Server) registerKey(reg *common.KeyRegistration) (*common.User, *apiError) {
+	if len(reg.PublicKey) == 0 {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidUser)
+	}
+	signer, content, err := s.engine.VerifyWithKey(reg.PublicKey, strings.NewReader(reg.Request))
+	if err != nil {
+		return nil, newAPIError(http.StatusForbidden, common.ErrCodeForbidden, err)
+	}
+	var req common.RegisterRequest
+	if apiErr := s.signedRequest(signer, content, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	user := &req.User
+	if user.Key == "" || user.Email == "" {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidUser)
+	}
+	if apiErr := s.checkSigner(signer, user.Key, req.CreatedAt); apiErr != nil {
+		return nil, apiErr
+	}
+	if _, err := s.engine.ImportMatchingKeys(bytes.NewReader(reg.PublicKey), signer); err != nil {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err)
+	}
+	if findKey(s.engine, signer, user.Email) == nil {
+		return nil, newAPIError(http.StatusForbidden, common.ErrCodeForbidden, ErrKeyEmailMismatch)
+	}
+
+	if err := s.store.AddUser(user); err != nil {
+		return nil, internalError(err)
+	}
+	return user, nil
+}
+
+// lookupKeys returns the keys of the users registered with email. Users
+// registered by key ID alone claimed the email without proving it, so only
+// keys that carry the email themselves are handed out.
+func (s *Server) lookupKeys(email string) ([]*common.PublicKey, *apiError) {
+	users, err := s.store.FindUsersByEmail(email)
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	var keys []*common.PublicKey
+	for _, user := range users {
+		key := findKey(s.engine, user.Key, email)
+		if key == nil {
+			continue
+		}
+		data, err := s.engine.ExportKey(key.Fingerprint)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, &common.PublicKey{
+			Key:         key.Fingerprint,
+			Name:        key.Name,
+			Email:       email,
+			Fingerprint: key.Fingerprint,
+			Data:        data,
+		})
+	}
+	if len(keys) == 0 {
+		return nil, newAPIError(http.StatusNotFound, common.ErrCodeKeyNotFound, ErrKeyNotFound)
+	}
+	return keys, nil
+}
+
+// findKey returns the key in the keyring matching id that has a user id
+// with email.
+func findKey(engine crypto.Engine, id, email string) *crypto.Key {
+	keys, _ := engine.ListPublicKeys("<" + email + ">")
+	for i := range keys {
+		if crypto.KeyMatches(keys[i].Fingerprint, id) {
+			return &keys[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"github.com/gophergala/gopher_talkie/src/common"
+	"net/http"
+)
+
+// The handlers below serve the original, unversioned API. They are kept so
+// that older clients keep working; new code should use the /v1 routes.
+
+func (s *Server) register(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusNotFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRegisterBodySize)
+
+	var user common.User
+	if r.Header.Get("Content-Type") == "application/json" {
+		err := parseJSON(r.Body, &user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		name := r.PostFormValue("name")
+		email := r.PostFormValue("email")
+		key := r.PostFormValue("key")
+		user = common.User{
+			Name:  name,
+			Email: email,
+			Key:   key,
+		}
+	}
+
+	if err := s.registerUser(&user); err != nil {
+		responseError(w, err)
+		return
+	}
+	responseSuccess(w, &user)
+}
+
+func (s *Server) send(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusNotFound)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := s.parseMessage(w, r)
+	if err == nil {
+		err = s.sendMessage(msg)
+	}
+	if err != nil {
+		if err.code == common.ErrCodeBadRequest || err.code == common.ErrCodeInternal {
+			http.Error(w, err.Error(), err.status)
+		} else {
+			responseErrorCode(w, err.status, err.code, err)
+		}
+		return
+	}
+	responseSuccess(w, nil)
+}
+
+func (s *Server) messages(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	messages, err := s.mailboxMessages(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := &Response{
+		Success: true,
+		Data:    &messages,
+	}
+	if err := s.writeMessages(w, key, r.FormValue("encrypt") == "1", res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	// since := r.FormValue("since")
+	msg, err := s.findMessage(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), err.status)
+		return
+	}
+
+	s.writeContent(w, r, msg)
+}
+
+// rules adds or removes a block/allow rule. The body is a SenderRuleRequest
+// clearsigned by the owner of the mailbox.
+func (s *Server) rules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusNotFound)
+		return
+	}
+
+	rule, err := s.applySenderRule(http.MaxBytesReader(w, r.Body, maxRuleBodySize))
+	if err != nil {
+		if err.status == http.StatusForbidden {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		} else {
+			responseError(w, err)
+		}
+		return
+	}
+	responseSuccess(w, rule)
+}
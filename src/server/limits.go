@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"net/http"
+)
+
+const (
+	DefaultMaxMessageSize     = 16 << 20
+	DefaultMaxMailboxMessages = 500
+	DefaultMaxMailboxBytes    = 512 << 20
+	DefaultMaxPendingUploads  = 10
+
+	maxRegisterBodySize = 256 << 10 // room for a public key
+)
+
+var (
+	ErrMessageTooLarge = errors.New("message too large")
+	ErrMailboxFull     = errors.New("recipient mailbox is full")
+	ErrQuotaExceeded   = errors.New("recipient mailbox quota exceeded")
+	ErrRateLimited     = errors.New("too many requests")
+	ErrSenderBlocked   = errors.New("recipient does not accept messages from you")
+	ErrTooManyUploads  = errors.New("too many unfinished uploads")
+)
+
+// Limits caps what a single upload and a single mailbox may hold, and how
+// many uploads a sender may leave unfinished. Unfinished uploads to a
+// recipient count against their mailbox.
+// A zero value disables the corresponding check.
+type Limits struct {
+	MaxMessageSize     int64 // bytes of content per message
+	MaxMailboxMessages int   // messages waiting per recipient, not played yet
+	MaxMailboxBytes    int64 // bytes of content waiting per recipient, not played yet
+	MaxPendingUploads  int   // unfinished uploads per sender
+}
+
+// maxSendBodySize is the largest JSON body accepted by /send: the content is
+// base64 encoded, plus some room for the rest of the message.
+func (l *Limits) maxSendBodySize() int64 {
+	if l.MaxMessageSize <= 0 {
+		return 0
+	}
+	return l.MaxMessageSize/3*4 + 4 + 64<<10
+}
+
+// checkDelivery returns an error if size more bytes don't fit the mailbox of
+// key, besides pending more messages of pendingBytes still on their way.
+func (l *Limits) checkDelivery(store common.Store, key string, size int64, pending int, pendingBytes int64) *apiError {
+	if l.MaxMessageSize > 0 && size > l.MaxMessageSize {
+		return newAPIError(http.StatusRequestEntityTooLarge, common.ErrCodeMessageTooLarge, ErrMessageTooLarge)
+	}
+	if l.MaxMailboxMessages <= 0 && l.MaxMailboxBytes <= 0 {
+		return nil
+	}
+
+	count, used, err := store.GetMailboxUsage(key)
+	if err != nil {
+		return internalError(err)
+	}
+	count += pending
+	used += pendingBytes
+	if l.MaxMailboxMessages > 0 && count >= l.MaxMailboxMessages {
+		return newAPIError(http.StatusInsufficientStorage, common.ErrCodeMailboxFull, ErrMailboxFull)
+	}
+	if l.MaxMailboxBytes > 0 && used+size > l.MaxMailboxBytes {
+		return newAPIError(http.StatusInsufficientStorage, common.ErrCodeQuotaExceeded, ErrQuotaExceeded)
+	}
+	return nil
+}
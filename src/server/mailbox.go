@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidUser     = errors.New("invalid user")
+	ErrInvalidMessage  = errors.New("invalid message")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrAmbiguousKey    = errors.New("more than one key has that ID, register with the full fingerprint")
+	ErrUnsignedMessage = errors.New("message must be signed by its sender, or sealed")
+	ErrWrongSignature  = errors.New("message is signed for another message")
+)
+
+// apiError is an error together with the HTTP status and error code it is
+// reported with.
+type apiError struct {
+	status     int
+	code       string
+	err        error
+	retryAfter time.Duration // for a rate limited request
+}
+
+func newAPIError(status int, code string, err error) *apiError {
+	return &apiError{
+		status: status,
+		code:   code,
+		err:    err,
+	}
+}
+
+func internalError(err error) *apiError {
+	return newAPIError(http.StatusInternalServerError, common.ErrCodeInternal, err)
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+// registerUser makes sure the server has user's public key and saves user,
+// known by the full fingerprint of the key: a key ID may be shared by keys
+// made to collide with it.
+func (s *Server) registerUser(user *common.User) *apiError {
+	if user == nil || user.Key == "" {
+		return newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidUser)
+	}
+
+	keys, _ := s.engine.ListPublicKeys(user.Key)
+	if keys == nil || len(keys) == 0 {
+		err := s.engine.RecvKey(user.Key)
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, common.ErrCodeKeyNotFound, err)
+		}
+		keys, _ = s.engine.ListPublicKeys(user.Key)
+	}
+	if len(keys) > 1 {
+		return newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrAmbiguousKey)
+	}
+	if len(keys) == 1 {
+		user.Key = keys[0].Fingerprint
+	}
+
+	if err := s.store.AddUser(user); err != nil {
+		return internalError(err)
+	}
+	return nil
+}
+
+// parseMessage reads a JSON encoded message no larger than the size limit.
+func (s *Server) parseMessage(w http.ResponseWriter, r *http.Request) (*common.Message, *apiError) {
+	if n := s.config.Limits.maxSendBodySize(); n > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+	}
+
+	var msg common.Message
+	err := parseJSON(r.Body, &msg)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, newAPIError(http.StatusRequestEntityTooLarge, common.ErrCodeMessageTooLarge, ErrMessageTooLarge)
+	}
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err)
+	}
+	return &msg, nil
+}
+
+// sendMessage delivers msg once it is sure who it comes from.
+func (s *Server) sendMessage(msg *common.Message) *apiError {
+	req, apiErr := s.verifySender(msg)
+	if apiErr != nil {
+		return apiErr
+	}
+	if !signedContent(req, msg.Content) {
+		return newAPIError(http.StatusBadRequest, common.ErrCodeChecksumMismatch, ErrChecksumMismatch)
+	}
+	return s.deliverMessage(msg)
+}
+
+// verifySender makes sure msg comes from the sender it names, by the
+// SendRequest it carries clearsigned by that sender: the recipient's sender
+// rules are only as good as that. It returns the request, nil for a sealed
+// message which names no sender. The content is checked with signedContent
+// once it is there.
+func (s *Server) verifySender(msg *common.Message) (*common.SendRequest, *apiError) {
+	signature := msg.Signature
+	msg.Signature = ""
+	if msg.Sealed {
+		return nil, nil
+	}
+	if msg.From == nil || signature == "" {
+		return nil, newAPIError(http.StatusForbidden, common.ErrCodeForbidden, ErrUnsignedMessage)
+	}
+
+	var req common.SendRequest
+	signer, apiErr := s.verifyRequest(strings.NewReader(signature), &req)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.checkSigner(signer, msg.From.Key, req.CreatedAt); apiErr != nil {
+		return nil, apiErr
+	}
+	msg.From.Key = crypto.NormalizeKey(msg.From.Key)
+	var to string
+	if msg.To != nil {
+		to = msg.To.Key
+	}
+	if !strings.EqualFold(req.From, msg.From.Key) || !strings.EqualFold(req.To, to) || req.Group != msg.Group {
+		return nil, newAPIError(http.StatusForbidden, common.ErrCodeForbidden, ErrWrongSignature)
+	}
+	return &req, nil
+}
+
+// signedContent reports whether content is what req was signed for, or
+// there is no req to sign for it.
+func signedContent(req *common.SendRequest, content []byte) bool {
+	if req == nil {
+		return true
+	}
+	sum := sha256.Sum256(content)
+	return strings.EqualFold(hex.EncodeToString(sum[:]), req.SHA256)
+}
+
+// deliverMessage checks msg, which comes from its sender as verifySender
+// made sure, against the limits and the recipient's sender rules, then
+// stores it.
+func (s *Server) deliverMessage(msg *common.Message) *apiError {
+	if msg.Group != "" {
+		return s.deliverGroupMessage(msg)
+	}
+	if apiErr := s.checkDelivery(msg, int64(len(msg.Content))); apiErr != nil {
+		return apiErr
+	}
+	if err := s.store.AddMessage(msg); err != nil {
+		return internalError(err)
+	}
+	s.publish(msg.To.Key, common.EventMessage, msg)
+	return nil
+}
+
+// checkMessage is checkDelivery for a message to a single recipient, and
+// checkGroup for one to a group: its members' mailboxes are checked when
+// it is delivered.
+func (s *Server) checkMessage(msg *common.Message, size int64) *apiError {
+	if msg.Group == "" {
+		return s.checkDelivery(msg, size)
+	}
+	if _, apiErr := s.checkGroup(msg); apiErr != nil {
+		return apiErr
+	}
+	if max := s.config.Limits.MaxMessageSize; max > 0 && size > max {
+		return newAPIError(http.StatusRequestEntityTooLarge, common.ErrCodeMessageTooLarge, ErrMessageTooLarge)
+	}
+	return nil
+}
+
+// checkDelivery returns an error if a message with size bytes of content
+// may not be delivered: it doesn't fit the recipient's mailbox, or the
+// recipient blocks the sender. What only the recipient may tell about a
+// message is dropped, it is dated when it arrives, and of a sealed message
+// only the recipient is kept, whatever else the client sent along.
+func (s *Server) checkDelivery(msg *common.Message, size int64) *apiError {
+	if msg.To == nil || (msg.From == nil && !msg.Sealed) {
+		return newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidMessage)
+	}
+	msg.Played = false
+	msg.Verification = ""
+	msg.Path = ""
+	msg.RemoteURL = ""
+	// the retention limit goes by this date, the sender doesn't pick it
+	msg.CreatedAt = s.config.Clock.Now()
+	// keys are stored as gpg prints them, for sender rules to match
+	msg.To.Key = crypto.NormalizeKey(msg.To.Key)
+	if msg.Sealed {
+		msg.From = nil
+		msg.To = &common.User{Key: msg.To.Key}
+		msg.Duration = 0
+	}
+	if err := s.config.Limits.checkDelivery(s.store, msg.To.Key, size, 0, 0); err != nil {
+		return err
+	}
+
+	senderRules, err := s.store.GetSenderRules(msg.To.Key)
+	if err != nil {
+		return internalError(err)
+	}
+	// the server can't tell who sent a sealed message, so rules for sealed
+	// messages apply to all of them: allow lists refuse them unless allowed
+	sender := common.SealedSender
+	if msg.From != nil {
+		sender = msg.From.Key
+	}
+	if !common.SenderAllowed(senderRules, sender) {
+		return newAPIError(http.StatusForbidden, common.ErrCodeSenderBlocked, ErrSenderBlocked)
+	}
+	return nil
+}
+
+// mailboxMessages returns the messages waiting for key.
+func (s *Server) mailboxMessages(key string) ([]*common.Message, error) {
+	all, err := s.store.GetUserMessages(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// hide messages which expired since the last janitor run
+	now := s.config.Clock.Now()
+	messages := make([]*common.Message, 0, len(all))
+	for _, m := range all {
+		if !m.Expired(now) {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+// writeMessages replies with res, encrypted to key if asked to so that only
+// the owner of the key can see the messages.
+func (s *Server) writeMessages(w http.ResponseWriter, key string, encrypt bool, res *Response) error {
+	d, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	if encrypt {
+		encryptedData, err := s.engine.Encrypt(s.config.ServerKey, key, bytes.NewReader(d))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, err = w.Write(encryptedData)
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(d)
+	return err
+}
+
+// findMessage looks up a message by its ID in string form.
+func (s *Server) findMessage(id string) (*common.Message, *apiError) {
+	msgID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err)
+	}
+	msg, err := s.store.GetMessage(msgID)
+	if err == common.ErrNoResult || (err == nil && (msg == nil || msg.Expired(s.config.Clock.Now()))) {
+		// never existed, already burnt or purged
+		return nil, newAPIError(http.StatusNotFound, common.ErrCodeNotFound, ErrMessageNotFound)
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return msg, nil
+}
+
+// deleteMessage deletes the message with id for the recipient who signed
+// the DeleteRequest in body.
+func (s *Server) deleteMessage(id string, body io.Reader) *apiError {
+	var req common.DeleteRequest
+	signer, apiErr := s.verifyRequest(body, &req)
+	if apiErr != nil {
+		return apiErr
+	}
+	msg, apiErr := s.recipientMessage(id, signer, req.Key, req.MessageID, req.CreatedAt)
+	if apiErr != nil {
+		return apiErr
+	}
+	if err := s.store.DeleteMessage(msg.MessageID); err != nil {
+		return internalError(err)
+	}
+	return nil
+}
+
+// acknowledgeMessage marks the message with id played on behalf of the
+// recipient who signed the ReceiptRequest in body, or burns it if it asked
+// for that, and sends its sender a receipt.
+func (s *Server) acknowledgeMessage(id string, body io.Reader) *apiError {
+	var req common.ReceiptRequest
+	signer, apiErr := s.verifyRequest(body, &req)
+	if apiErr != nil {
+		return apiErr
+	}
+	if !req.Played {
+		return newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidMessage)
+	}
+	msg, apiErr := s.recipientMessage(id, signer, req.Key, req.MessageID, req.CreatedAt)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	if msg.BurnAfterPlaying {
+		// the recipient has its copy now, nobody else needs one
+		if err := s.store.DeleteMessage(msg.MessageID); err != nil {
+			return internalError(err)
+		}
+	} else if err := s.store.UpdateMessagePlayed(msg.MessageID, true); err != nil {
+		// played messages don't count against the mailbox limits
+		return internalError(err)
+	}
+	if msg.From != nil {
+		s.publish(msg.From.Key, common.EventReceipt, msg)
+	}
+	return nil
+}
+
+// recipientMessage finds the message with id for a request signed by
+// signer on behalf of key, which has to be its recipient.
+func (s *Server) recipientMessage(id, signer, key string, msgID int64, createdAt time.Time) (*common.Message, *apiError) {
+	if apiErr := s.checkSigner(signer, key, createdAt); apiErr != nil {
+		return nil, apiErr
+	}
+	msg, apiErr := s.findMessage(id)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	// nobody learns about messages in mailboxes other than theirs
+	if msgID != msg.MessageID || msg.To == nil || !strings.EqualFold(key, msg.To.Key) {
+		return nil, newAPIError(http.StatusNotFound, common.ErrCodeNotFound, ErrMessageNotFound)
+	}
+	return msg, nil
+}
+
+// writeContent sends the encrypted content of msg, or the part of it asked
+// for with a Range header. Only a receipt from the recipient burns it.
+func (s *Server) writeContent(w http.ResponseWriter, r *http.Request, msg *common.Message) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", msg.CreatedAt, bytes.NewReader(msg.Content))
+}
+
+// applySenderRule verifies a signed SenderRuleRequest and applies it.
+func (s *Server) applySenderRule(body io.Reader) (*common.SenderRule, *apiError) {
+	var req common.SenderRuleRequest
+	signer, apiErr := s.verifyRequest(body, &req)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := s.checkSigner(signer, req.Owner, req.CreatedAt); apiErr != nil {
+		return nil, apiErr
+	}
+	req.Owner = crypto.NormalizeKey(req.Owner)
+	if !strings.EqualFold(req.Sender, common.SealedSender) {
+		req.Sender = crypto.NormalizeKey(req.Sender)
+	} else {
+		req.Sender = common.SealedSender
+	}
+
+	var err error
+	if req.Remove {
+		err = s.store.DeleteSenderRule(req.Owner, req.Sender)
+	} else {
+		err = s.store.SetSenderRule(&req.SenderRule)
+	}
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err)
+	}
+	return &req.SenderRule, nil
+}
+
+// verifyRequest checks the signature of a clearsigned JSON request, decodes
+// it into v and returns the fingerprint of the signer. Signers are rate
+// limited by their key, from whichever address they send.
+func (s *Server) verifyRequest(body io.Reader, v interface{}) (string, *apiError) {
+	signer, content, err := s.engine.Verify(body)
+	if err != nil {
+		return "", newAPIError(http.StatusForbidden, common.ErrCodeForbidden, err)
+	}
+	return signer, s.signedRequest(signer, content, v)
+}
+
+// signedRequest unmarshals into v the content of a request signed by
+// signer, which counts against the rate limit of the signer.
+func (s *Server) signedRequest(signer string, content []byte, v interface{}) *apiError {
+	if !s.keyLimiter.Allow(signer) {
+		apiErr := newAPIError(http.StatusTooManyRequests, common.ErrCodeRateLimited, ErrRateLimited)
+		apiErr.retryAfter = s.keyLimiter.RetryAfter(signer)
+		return apiErr
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err)
+	}
+	return nil
+}
+
+// checkSigner makes sure a request on behalf of key, which has to be a full
+// fingerprint, was signed by key, and recently enough that it isn't a replay.
+func (s *Server) checkSigner(signer, key string, createdAt time.Time) *apiError {
+	if !crypto.IsFingerprint(key) || !crypto.KeyMatches(signer, key) {
+		return newAPIError(http.StatusForbidden, common.ErrCodeForbidden, ErrNotOwner)
+	}
+	if age := s.config.Clock.Now().Sub(createdAt); age > maxRuleAge || age < -maxRuleAge {
+		return newAPIError(http.StatusForbidden, common.ErrCodeForbidden, ErrStaleRequest)
+	}
+	return nil
+}
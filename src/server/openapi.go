@@ -0,0 +1,475 @@
+package server
+
+// openAPIDocument describes the /v1 API. Keep it in sync with routes() in
+// http_server.go.
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "talkie-server",
+    "description": "Mailboxes for PGP encrypted voice messages.",
+    "version": "1"
+  },
+  "servers": [{"url": "/v1"}],
+  "paths": {
+    "/users": {
+      "post": {
+        "summary": "Register a user. Given a User, the server fetches the public key from a keyserver if it doesn't know it yet. Given a KeyRegistration it takes the key uploaded with it, and hands it out from /keys.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"oneOf": [
+            {"$ref": "#/components/schemas/User"},
+            {"$ref": "#/components/schemas/KeyRegistration"}
+          ]}}}
+        },
+        "responses": {
+          "201": {"description": "Registered", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UserResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/keys": {
+      "get": {
+        "summary": "Look up the public keys of the users registered with an email, among those that uploaded their key.",
+        "parameters": [{"name": "email", "in": "query", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "The keys", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/KeysResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/messages": {
+      "get": {
+        "summary": "List the messages waiting for a key.",
+        "parameters": [
+          {"name": "key", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "encrypt", "in": "query", "description": "Set to 1 to get the response PGP encrypted to key.", "schema": {"type": "string", "enum": ["0", "1"]}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Messages, without content",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/MessagesResponse"}},
+              "application/octet-stream": {"schema": {"type": "string", "format": "binary"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Send a message. Unless it is sealed, it must carry a SendRequest for it clearsigned by its sender in signature.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}
+        },
+        "responses": {
+          "201": {"description": "Stored, data is the message ID", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/IDResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "507": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/messages/{id}": {
+      "get": {
+        "summary": "Get a message without its content.",
+        "parameters": [{"$ref": "#/components/parameters/MessageID"}],
+        "responses": {
+          "200": {"description": "The message", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/MessageResponse"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a message from its recipient's mailbox. The body is a DeleteRequest clearsigned by the recipient; anyone else gets a 404.",
+        "parameters": [{"$ref": "#/components/parameters/MessageID"}],
+        "requestBody": {
+          "required": true,
+          "content": {"text/plain": {"schema": {"type": "string"}}}
+        },
+        "responses": {
+          "200": {"description": "Deleted", "content": {"application/json": {"schema": {"type": "object", "properties": {"success": {"type": "boolean"}}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/messages/{id}/content": {
+      "get": {
+        "summary": "Download the encrypted content of a message. Supports Range requests to resume a download. Downloading changes nothing, see /messages/{id}/receipt.",
+        "parameters": [
+          {"$ref": "#/components/parameters/MessageID"},
+          {"name": "Range", "in": "header", "schema": {"type": "string"}, "example": "bytes=65536-"}
+        ],
+        "responses": {
+          "200": {"description": "PGP encrypted audio", "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}},
+          "206": {"description": "Part of the PGP encrypted audio", "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "416": {"description": "Range not satisfiable"}
+        }
+      }
+    },
+    "/messages/{id}/receipt": {
+      "post": {
+        "summary": "Tell the sender that the recipient played a message, and burn it if it is burn-after-playing. The body is a ReceiptRequest with played set, clearsigned by the recipient; anyone else gets a 404.",
+        "parameters": [{"$ref": "#/components/parameters/MessageID"}],
+        "requestBody": {
+          "required": true,
+          "content": {"text/plain": {"schema": {"type": "string"}}}
+        },
+        "responses": {
+          "200": {"description": "Receipt sent", "content": {"application/json": {"schema": {"type": "object", "properties": {"success": {"type": "boolean"}}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/uploads": {
+      "post": {
+        "summary": "Start a resumable upload of a message. The same checks as for sending apply to the announced size, with the unfinished uploads to the recipient counted against their mailbox. A sender may leave only so many uploads unfinished.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UploadRequest"}}}
+        },
+        "responses": {
+          "201": {"description": "Upload started", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UploadResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "507": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/uploads/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/UploadID"}],
+      "get": {
+        "summary": "Get how much of an upload arrived, to resume it from there.",
+        "responses": {
+          "200": {"description": "The upload", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UploadResponse"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Append a chunk of at most 4 MiB. It must start at the offset of the upload.",
+        "parameters": [{"name": "Content-Range", "in": "header", "required": true, "schema": {"type": "string"}, "example": "bytes 0-1048575/2621440"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}
+        },
+        "responses": {
+          "200": {"description": "Chunk stored", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UploadResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/uploads/{id}/finish": {
+      "parameters": [{"$ref": "#/components/parameters/UploadID"}],
+      "post": {
+        "summary": "Check the hash of a complete upload and deliver it as a message. Finishing it again answers with the same message ID.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UploadFinish"}}}
+        },
+        "responses": {
+          "201": {"description": "Delivered, data is the message ID", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/IDResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/rules": {
+      "post": {
+        "summary": "Block or allow a sender. The body is a SenderRuleRequest clearsigned by the mailbox owner.",
+        "requestBody": {
+          "required": true,
+          "content": {"text/plain": {"schema": {"type": "string"}}}
+        },
+        "responses": {
+          "201": {"description": "Rule applied", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RuleResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/groups": {
+      "post": {
+        "summary": "Create a group, replace its members, or delete it. The body is a GroupRequest clearsigned by the owner; only the owner of an existing group may change it.",
+        "requestBody": {
+          "required": true,
+          "content": {"text/plain": {"schema": {"type": "string"}}}
+        },
+        "responses": {
+          "201": {"description": "The group as it is now, or as it was if it was deleted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GroupResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "get": {
+        "summary": "List the groups a key owns or is a member of.",
+        "parameters": [{"name": "key", "in": "query", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "The groups", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GroupsResponse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/groups/{name}": {
+      "get": {
+        "summary": "Get a group. Only its owner and members see it.",
+        "parameters": [
+          {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "key", "in": "query", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "The group", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GroupResponse"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/events": {
+      "post": {
+        "summary": "Stream events for a key as Server-Sent Events: \"message\" when a message arrives for it, \"receipt\" when the recipient of a message it sent played it. The body is a SubscribeRequest clearsigned by the key.",
+        "requestBody": {
+          "required": true,
+          "content": {"text/plain": {"schema": {"type": "string"}}}
+        },
+        "responses": {
+          "200": {"description": "Event stream, each data line an Event", "content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/Event"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "MessageID": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}},
+      "UploadID": {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Error": {
+        "description": "Error",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    },
+    "schemas": {
+      "User": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer", "format": "int64"},
+          "key": {"type": "string"},
+          "name": {"type": "string"},
+          "email": {"type": "string"}
+        },
+        "required": ["key"]
+      },
+      "KeyRegistration": {
+        "type": "object",
+        "properties": {
+          "public_key": {"type": "string", "format": "byte", "description": "The public key, armored or not."},
+          "request": {"type": "string", "description": "A RegisterRequest, clearsigned by public_key. The key must have a user id with the user's email."}
+        },
+        "required": ["public_key", "request"]
+      },
+      "RegisterRequest": {
+        "type": "object",
+        "properties": {
+          "user": {"$ref": "#/components/schemas/User"},
+          "created_at": {"type": "string", "format": "date-time"}
+        },
+        "required": ["user", "created_at"]
+      },
+      "PublicKey": {
+        "type": "object",
+        "properties": {
+          "key": {"type": "string"},
+          "name": {"type": "string"},
+          "email": {"type": "string"},
+          "fingerprint": {"type": "string"},
+          "data": {"type": "string", "format": "byte", "description": "The armored public key."}
+        }
+      },
+      "Message": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer", "format": "int64"},
+          "from": {"$ref": "#/components/schemas/User"},
+          "to": {"$ref": "#/components/schemas/User"},
+          "content": {"type": "string", "format": "byte"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "duration": {"type": "integer", "description": "nanoseconds"},
+          "played": {"type": "boolean"},
+          "expires_at": {"type": "string", "format": "date-time"},
+          "burn_after_playing": {"type": "boolean"},
+          "sealed": {"type": "boolean", "description": "Sender, time and duration are inside the encrypted content. The server keeps only the recipient key and sets created_at itself."},
+          "group": {"type": "string", "description": "Send to a group instead of to: every member but the sender gets a copy. The sender must be in the group, and the message can't be sealed."},
+          "signature": {"type": "string", "description": "A SendRequest for this message clearsigned by from, required unless sealed. Only the server sees it."}
+        },
+        "description": "to is required unless group is set."
+      },
+      "Group": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer", "format": "int64"},
+          "name": {"type": "string", "pattern": "^[a-z0-9][a-z0-9._-]{0,31}$"},
+          "owner": {"type": "string", "description": "key of the owner"},
+          "members": {"type": "array", "items": {"$ref": "#/components/schemas/User"}, "maxItems": 100},
+          "created_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "GroupRequest": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "owner": {"type": "string"},
+          "members": {"type": "array", "items": {"$ref": "#/components/schemas/User"}, "description": "Replace the members; only their keys are used."},
+          "remove": {"type": "boolean", "description": "Delete the group."},
+          "created_at": {"type": "string", "format": "date-time"}
+        },
+        "required": ["name", "owner", "created_at"]
+      },
+      "SenderRule": {
+        "type": "object",
+        "properties": {
+          "owner": {"type": "string"},
+          "sender": {"type": "string", "description": "Fingerprint of the sender, or \"sealed\" for all sealed messages."},
+          "allow": {"type": "boolean"}
+        }
+      },
+      "UploadRequest": {
+        "type": "object",
+        "properties": {
+          "message": {"$ref": "#/components/schemas/Message"},
+          "size": {"type": "integer", "format": "int64"}
+        },
+        "required": ["message", "size"]
+      },
+      "Upload": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "size": {"type": "integer", "format": "int64"},
+          "offset": {"type": "integer", "format": "int64"},
+          "created_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "UploadFinish": {
+        "type": "object",
+        "properties": {"sha256": {"type": "string", "description": "hex encoded SHA-256 of the whole content"}},
+        "required": ["sha256"]
+      },
+      "DeleteRequest": {
+        "type": "object",
+        "properties": {
+          "key": {"type": "string"},
+          "id": {"type": "integer", "format": "int64"},
+          "created_at": {"type": "string", "format": "date-time"}
+        },
+        "required": ["key", "id", "created_at"]
+      },
+      "SendRequest": {
+        "type": "object",
+        "properties": {
+          "from": {"type": "string", "description": "fingerprint of the sender"},
+          "to": {"type": "string"},
+          "group": {"type": "string"},
+          "sha256": {"type": "string", "description": "hex encoded SHA-256 of the content"},
+          "created_at": {"type": "string", "format": "date-time"}
+        },
+        "required": ["from", "sha256", "created_at"]
+      },
+      "ReceiptRequest": {
+        "type": "object",
+        "properties": {
+          "key": {"type": "string"},
+          "id": {"type": "integer", "format": "int64"},
+          "played": {"type": "boolean", "enum": [true]},
+          "created_at": {"type": "string", "format": "date-time"}
+        },
+        "required": ["key", "id", "played", "created_at"]
+      },
+      "SubscribeRequest": {
+        "type": "object",
+        "properties": {
+          "key": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"}
+        },
+        "required": ["key", "created_at"]
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "type": {"type": "string", "enum": ["message", "receipt"]},
+          "message": {"$ref": "#/components/schemas/Message"},
+          "created_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "success": {"type": "boolean", "enum": [false]},
+          "error": {"type": "string"},
+          "code": {
+            "type": "string",
+            "enum": ["bad_request", "forbidden", "not_found", "method_not_allowed", "internal_error", "key_not_found",
+              "message_too_large", "mailbox_full", "quota_exceeded", "rate_limited", "sender_blocked",
+              "upload_offset_mismatch", "checksum_mismatch", "too_many_uploads"]
+          }
+        },
+        "required": ["success", "error", "code"]
+      },
+      "UserResponse": {
+        "type": "object",
+        "properties": {"success": {"type": "boolean"}, "data": {"$ref": "#/components/schemas/User"}}
+      },
+      "KeysResponse": {
+        "type": "object",
+        "properties": {"success": {"type": "boolean"}, "data": {"type": "array", "items": {"$ref": "#/components/schemas/PublicKey"}}}
+      },
+      "GroupResponse": {
+        "type": "object",
+        "properties": {"success": {"type": "boolean"}, "data": {"$ref": "#/components/schemas/Group"}}
+      },
+      "GroupsResponse": {
+        "type": "object",
+        "properties": {"success": {"type": "boolean"}, "data": {"type": "array", "items": {"$ref": "#/components/schemas/Group"}}}
+      },
+      "MessageResponse": {
+        "type": "object",
+        "properties": {"success": {"type": "boolean"}, "data": {"$ref": "#/components/schemas/Message"}}
+      },
+      "MessagesResponse": {
+        "type": "object",
+        "properties": {"success": {"type": "boolean"}, "data": {"type": "array", "items": {"$ref": "#/components/schemas/Message"}}}
+      },
+      "IDResponse": {
+        "type": "object",
+        "properties": {"success": {"type": "boolean"}, "data": {"type": "integer", "format": "int64"}}
+      },
+      "UploadResponse": {
+        "type": "object",
+        "properties": {"success": {"type": "boolean"}, "data": {"$ref": "#/components/schemas/Upload"}}
+      },
+      "RuleResponse": {
+        "type": "object",
+        "properties": {"success": {"type": "boolean"}, "data": {"$ref": "#/components/schemas/SenderRule"}}
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRateLimit         = 2.0
+	DefaultRateBurst         = 20
+	DefaultRegisterRateLimit = 1.0 / 6
+	DefaultRegisterBurst     = 10
+
+	// drop idle buckets once there are this many
+	maxRateBuckets = 10000
+)
+
+type RateLimitOptions struct {
+	Rate          float64 // requests per second per client address and per signing key
+	Burst         int
+	RegisterRate  float64 // registrations per second per client address
+	RegisterBurst int
+}
+
+// RateLimiter is a set of token buckets, one per key, each refilled at rate
+// tokens per second up to burst.
+type RateLimiter struct {
+	rate    float64
+	burst   float64
+	clock   common.Clock
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int, clock common.Clock) *RateLimiter {
+	if clock == nil {
+		clock = common.SystemClock
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clock:   clock,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from key's bucket, returning false if it is empty.
+// A limiter with a rate of 0 allows everything.
+func (l *RateLimiter) Allow(key string) bool {
+	if l == nil || l.rate <= 0 {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RetryAfter returns how long until key's bucket has a token again.
+func (l *RateLimiter) RetryAfter(key string) time.Duration {
+	if l == nil || l.rate <= 0 {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok || b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep forgets buckets which have refilled completely.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func responseRateLimited(w http.ResponseWriter, retry time.Duration) {
+	if secs := int(retry.Seconds() + 0.999); secs > 0 {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", secs))
+	}
+	responseErrorCode(w, http.StatusTooManyRequests, common.ErrCodeRateLimited, ErrRateLimited)
+}
+
+// limitByIP rejects requests once the client address runs out of tokens.
+func limitByIP(l *RateLimiter, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if !l.Allow(ip) {
+			responseRateLimited(w, l.RetryAfter(ip))
+			return
+		}
+		h(w, r)
+	}
+}
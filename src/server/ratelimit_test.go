@@ -0,0 +1,72 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 1, 24, 12, 0, 0, 0, time.UTC)}
+	l := NewRateLimiter(1, 3, clock)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow("a"))
+	}
+	assert.False(t, l.Allow("a"))
+	assert.Equal(t, time.Second, l.RetryAfter("a"))
+
+	// other keys have their own bucket
+	assert.True(t, l.Allow("b"))
+
+	clock.now = clock.now.Add(time.Second)
+	assert.True(t, l.Allow("a"))
+	assert.False(t, l.Allow("a"))
+
+	// never more than burst
+	clock.now = clock.now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow("a"))
+	}
+	assert.False(t, l.Allow("a"))
+
+	// no rate, no limit
+	unlimited := NewRateLimiter(0, 0, clock)
+	for i := 0; i < 100; i++ {
+		assert.True(t, unlimited.Allow("a"))
+	}
+}
+
+func TestLimitByIP(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 1, 24, 12, 0, 0, 0, time.UTC)}
+	h := limitByIP(NewRateLimiter(1, 1, clock), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	r, _ := http.NewRequest("GET", "/messages", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+
+	w := httptest.NewRecorder()
+	h(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	h(w, r)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	r.RemoteAddr = "10.0.0.2:1234"
+	w = httptest.NewRecorder()
+	h(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+type Response struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   interface{} `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"`
+}
+
+func parseJSON(body io.Reader, v interface{}) error {
+	d, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(d, v); err != nil {
+		return err
+	}
+	return nil
+}
+
+func responseSuccess(w http.ResponseWriter, obj interface{}) {
+	res := &Response{
+		Success: true,
+		Data:    obj,
+	}
+	responseJSON(w, res)
+}
+
+func responseJSON(w http.ResponseWriter, obj interface{}) {
+	d, _ := json.Marshal(obj)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(d)
+}
+
+func responseError(w http.ResponseWriter, err error) {
+	res := &Response{
+		Success: false,
+		Error:   err.Error(),
+	}
+	responseJSON(w, res)
+}
+
+// responseErrorCode replies with status and an error the client can tell
+// apart by code.
+func responseErrorCode(w http.ResponseWriter, status int, code string, err error) {
+	res := &Response{
+		Success: false,
+		Error:   err.Error(),
+		Code:    code,
+	}
+	d, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(d)
+}
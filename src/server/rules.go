@@ -0,0 +1,18 @@
+package server
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	maxRuleBodySize = 64 << 10
+
+	// signed rule requests older than this are refused, so they can't be replayed later
+	maxRuleAge = 5 * time.Minute
+)
+
+var (
+	ErrNotOwner     = errors.New("request is not signed by the mailbox owner")
+	ErrStaleRequest = errors.New("request is too old")
+)
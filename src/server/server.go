@@ -1,5 +1,106 @@
-package main
+package server
 
-type Server interface {
-	ListenAndServer() error
+import (
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+type Config struct {
+	ServerKey  string // PGP key used to encrypt message listings
+	Limits     Limits
+	RateLimits RateLimitOptions
+
+	Retention       time.Duration // purge messages older than this. default: 0, keep forever
+	JanitorInterval time.Duration // default: common.DefaultJanitorInterval
+	Clock           common.Clock  // default: common.SystemClock
+
+	UploadDir    string        // unfinished uploads. default: talkie-uploads in the temp dir
+	UploadExpiry time.Duration // drop unfinished uploads older than this. default: DefaultUploadExpiry
+}
+
+// DefaultConfig returns the configuration talkie-server starts with when no
+// flags are given.
+func DefaultConfig() *Config {
+	return &Config{
+		Limits: Limits{
+			MaxMessageSize:     DefaultMaxMessageSize,
+			MaxMailboxMessages: DefaultMaxMailboxMessages,
+			MaxMailboxBytes:    DefaultMaxMailboxBytes,
+			MaxPendingUploads:  DefaultMaxPendingUploads,
+		},
+		RateLimits: RateLimitOptions{
+			Rate:          DefaultRateLimit,
+			Burst:         DefaultRateBurst,
+			RegisterRate:  DefaultRegisterRateLimit,
+			RegisterBurst: DefaultRegisterBurst,
+		},
+		JanitorInterval: common.DefaultJanitorInterval,
+		UploadExpiry:    DefaultUploadExpiry,
+	}
+}
+
+// Server serves the talkie HTTP API from a Store.
+type Server struct {
+	store   common.Store
+	engine  crypto.Engine
+	config  Config
+	router  *mux.Router
+	janitor *common.Janitor
+	events  *eventHub
+	uploads *uploadStore
+
+	ipLimiter       *RateLimiter
+	keyLimiter      *RateLimiter
+	registerLimiter *RateLimiter
+}
+
+func NewServer(store common.Store, engine crypto.Engine, config *Config) *Server {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	cfg := *config
+	if cfg.Clock == nil {
+		cfg.Clock = common.SystemClock
+	}
+	if cfg.UploadExpiry == 0 {
+		cfg.UploadExpiry = DefaultUploadExpiry
+	}
+
+	s := &Server{
+		store:  store,
+		engine: engine,
+		config: cfg,
+		router: mux.NewRouter(),
+		janitor: common.NewJanitor(store, &common.JanitorOptions{
+			Retention: cfg.Retention,
+			Interval:  cfg.JanitorInterval,
+			Clock:     cfg.Clock,
+		}),
+		events:          newEventHub(),
+		uploads:         newUploadStore(cfg.UploadDir),
+		ipLimiter:       NewRateLimiter(cfg.RateLimits.Rate, cfg.RateLimits.Burst, cfg.Clock),
+		keyLimiter:      NewRateLimiter(cfg.RateLimits.Rate, cfg.RateLimits.Burst, cfg.Clock),
+		registerLimiter: NewRateLimiter(cfg.RateLimits.RegisterRate, cfg.RateLimits.RegisterBurst, cfg.Clock),
+	}
+	s.routes()
+	return s
+}
+
+// Start runs the background janitor purging expired messages.
+func (s *Server) Start() {
+	s.janitor.Start()
+}
+
+// Close stops the background janitor and ends all event streams. The store
+// is left open.
+func (s *Server) Close() {
+	s.janitor.Stop()
+	s.events.close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
 }
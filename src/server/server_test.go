@@ -0,0 +1,950 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/api"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// full fingerprints of the keys in the tests
+const (
+	aliceFpr = "0123456789ABCDEF0123456789ABCDEFAAAA1111"
+	bobFpr   = "0123456789ABCDEF0123456789ABCDEFBBBB2222"
+	carolFpr = "0123456789ABCDEF0123456789ABCDEFCCCC3333"
+	daveFpr  = "0123456789ABCDEF0123456789ABCDEFDDDD4444"
+)
+
+// fakeEngine knows every key and "encrypts" by passing data through.
+// Signatures verify as made by signer, or by an unknown key while strict
+// and the signer was neither received nor imported. Only keys are listed
+// and exported, as JSON.
+type fakeEngine struct {
+	recvKeys []string
+	signer   string
+	keys     []crypto.Key
+	strict   bool
+}
+
+func (e *fakeEngine) knows(key string) bool {
+	for _, k := range e.recvKeys {
+		if crypto.KeyMatches(k, key) {
+			return true
+		}
+	}
+	for _, k := range e.keys {
+		if crypto.KeyMatches(k.Fingerprint, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *fakeEngine) ListPublicKeys(search string) ([]crypto.Key, error) {
+	var keys []crypto.Key
+	for _, k := range e.keys {
+		if strings.HasPrefix(search, "<") && strings.EqualFold("<"+k.Email+">", search) || crypto.KeyMatches(k.Fingerprint, search) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (e *fakeEngine) ListSecretKeys(search string) ([]crypto.Key, error) {
+	return nil, nil
+}
+
+func (e *fakeEngine) RecvKey(key string) error {
+	e.recvKeys = append(e.recvKeys, key)
+	return nil
+}
+
+func (e *fakeEngine) ImportKey(src io.Reader) error {
+	var k crypto.Key
+	if err := json.NewDecoder(src).Decode(&k); err != nil {
+		return err
+	}
+	e.add(k)
+	return nil
+}
+
+func (e *fakeEngine) add(k crypto.Key) {
+	for i := range e.keys {
+		if e.keys[i].Fingerprint == k.Fingerprint {
+			e.keys[i] = k
+			return
+		}
+	}
+	e.keys = append(e.keys, k)
+}
+
+func (e *fakeEngine) VerifyWithKey(key []byte, src io.Reader) (string, []byte, error) {
+	var k crypto.Key
+	if err := json.Unmarshal(key, &k); err != nil || e.signer == "" || !crypto.KeyMatches(k.Fingerprint, e.signer) {
+		return "", nil, crypto.ErrBadSignature
+	}
+	content, err := ioutil.ReadAll(src)
+	return e.signer, content, err
+}
+
+func (e *fakeEngine) ImportMatchingKeys(src io.Reader, search string) ([]crypto.Key, error) {
+	var k crypto.Key
+	if err := json.NewDecoder(src).Decode(&k); err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold("<"+k.Email+">", search) && !crypto.KeyMatches(k.Fingerprint, search) {
+		return nil, crypto.ErrKeyNotFound
+	}
+	e.add(k)
+	return []crypto.Key{k}, nil
+}
+
+func (e *fakeEngine) ExportKey(key string) ([]byte, error) {
+	for _, k := range e.keys {
+		if crypto.KeyMatches(k.Fingerprint, key) {
+			return json.Marshal(&k)
+		}
+	}
+	return nil, crypto.ErrKeyNotFound
+}
+
+func (e *fakeEngine) Encrypt(uid, recipient string, src io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(src)
+}
+
+func (e *fakeEngine) EncryptTo(uid string, recipients []string, src io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(src)
+}
+
+func (e *fakeEngine) Decrypt(uid string, src io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(src)
+}
+
+func (e *fakeEngine) DecryptVerify(uid string, src io.Reader) ([]byte, *crypto.Signature, error) {
+	content, err := ioutil.ReadAll(src)
+	if e.signer == "" {
+		return content, &crypto.Signature{Status: crypto.SigMissing}, err
+	}
+	if e.strict && !e.knows(e.signer) {
+		return content, &crypto.Signature{Status: crypto.SigUnknownKey, Fingerprint: e.signer}, err
+	}
+	return content, &crypto.Signature{Status: crypto.SigGood, Fingerprint: e.signer}, err
+}
+
+func (e *fakeEngine) ClearSign(uid string, src io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(src)
+}
+
+func (e *fakeEngine) Verify(src io.Reader) (string, []byte, error) {
+	if e.signer == "" {
+		return "", nil, crypto.ErrBadSignature
+	}
+	content, err := ioutil.ReadAll(src)
+	return e.signer, content, err
+}
+
+type testServer struct {
+	*httptest.Server
+	srv    *Server
+	store  common.Store
+	engine *fakeEngine
+	client *api.Client
+}
+
+func (ts *testServer) Close() {
+	ts.srv.Close()
+	ts.Server.Close()
+	ts.store.Close()
+	os.RemoveAll(ts.srv.uploads.dir)
+}
+
+func startServer(t *testing.T, config *Config) *testServer {
+	dbPath := path.Join(os.TempDir(), fmt.Sprintf("%06x.db", rand.Uint32()&0xFFFFFF))
+	os.RemoveAll(dbPath)
+	store := common.NewStoreSqlite(&common.SqliteStoreOptions{
+		DBPath: dbPath,
+	})
+	engine := &fakeEngine{}
+
+	// uploads left unfinished by one test don't count in the next
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if config.UploadDir == "" {
+		config.UploadDir = dbPath + ".uploads"
+	}
+	srv := NewServer(store, engine, config)
+	ts := httptest.NewServer(srv)
+
+	client := api.NewClient(ts.URL)
+	client.SetEngine(engine)
+	return &testServer{
+		Server: ts,
+		srv:    srv,
+		store:  store,
+		engine: engine,
+		client: client,
+	}
+}
+
+func TestRegisterAndSend(t *testing.T) {
+	ts := startServer(t, nil)
+	defer ts.Close()
+
+	alice := &common.User{Name: "Alice", Email: "alice@example.com", Key: aliceFpr}
+	bob := &common.User{Name: "Bob", Email: "bob@example.com", Key: bobFpr}
+	assert.Nil(t, ts.client.Register(context.Background(), alice))
+	assert.Nil(t, ts.client.Register(context.Background(), bob))
+	assert.True(t, alice.UserID > 0)
+	assert.Equal(t, []string{aliceFpr, bobFpr}, ts.engine.recvKeys)
+
+	msg := &common.Message{
+		From:      alice,
+		To:        bob,
+		CreatedAt: time.Now(),
+		Content:   []byte("hello bob"),
+	}
+	ts.engine.signer = aliceFpr
+	assert.Nil(t, ts.client.Send(context.Background(), msg))
+	assert.True(t, msg.MessageID > 0)
+
+	messages, err := ts.client.GetMessages(context.Background(), bob)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, msg.MessageID, messages[0].MessageID)
+	assert.Equal(t, "Alice", messages[0].From.Name)
+
+	content, err := ts.client.DownloadMessage(context.Background(), msg.MessageID)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello bob"), content)
+
+	// the server claims alice sent it, her signature has to agree
+	m := messages[0]
+	m.Content = content
+	ts.engine.signer = carolFpr
+	_, _, err = ts.client.Open(bob, m)
+	assert.Nil(t, err)
+	assert.Equal(t, common.VerifyMismatch, m.Verification)
+	ts.engine.signer = aliceFpr
+	_, _, err = ts.client.Open(bob, m)
+	assert.Nil(t, err)
+	assert.Equal(t, common.VerifyUntrusted, m.Verification)
+
+	messages, err = ts.client.GetMessages(context.Background(), alice)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(messages))
+
+	_, err = ts.client.DownloadMessage(context.Background(), msg.MessageID+100)
+	assert.Equal(t, api.ErrNotFound, err)
+}
+
+func TestBurnAfterPlaying(t *testing.T) {
+	ts := startServer(t, nil)
+	defer ts.Close()
+
+	alice := &common.User{Key: aliceFpr}
+	bob := &common.User{Key: bobFpr}
+	msg := &common.Message{
+		From:             alice,
+		To:               bob,
+		Content:          []byte("read once"),
+		BurnAfterPlaying: true,
+	}
+	ts.engine.signer = aliceFpr
+	assert.Nil(t, ts.client.Send(context.Background(), msg))
+
+	content, err := ts.client.DownloadMessage(context.Background(), msg.MessageID)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("read once"), content)
+	// downloading alone doesn't burn it
+	_, err = ts.client.DownloadMessage(context.Background(), msg.MessageID)
+	assert.Nil(t, err)
+
+	// only the recipient may say it played it
+	ts.engine.signer = aliceFpr
+	assert.Equal(t, api.ErrNotFound, ts.client.Acknowledge(context.Background(), alice, msg.MessageID))
+	assert.Equal(t, api.ErrUnauthorized, ts.client.Acknowledge(context.Background(), bob, msg.MessageID))
+	_, err = ts.client.DownloadMessage(context.Background(), msg.MessageID)
+	assert.Nil(t, err)
+
+	ts.engine.signer = bobFpr
+	assert.Nil(t, ts.client.Acknowledge(context.Background(), bob, msg.MessageID))
+	_, err = ts.client.DownloadMessage(context.Background(), msg.MessageID)
+	assert.Equal(t, api.ErrNotFound, err)
+}
+
+func TestDeleteMessage(t *testing.T) {
+	ts := startServer(t, nil)
+	defer ts.Close()
+
+	alice := &common.User{Key: aliceFpr}
+	bob := &common.User{Key: bobFpr}
+	msg := &common.Message{From: alice, To: bob, Content: []byte("delete me")}
+	ts.engine.signer = aliceFpr
+	assert.Nil(t, ts.client.Send(context.Background(), msg))
+
+	// only the recipient may delete it, and has to sign for it
+	ts.engine.signer = aliceFpr
+	assert.Equal(t, api.ErrNotFound, ts.client.DeleteMessage(context.Background(), alice, msg.MessageID))
+	assert.Equal(t, api.ErrUnauthorized, ts.client.DeleteMessage(context.Background(), bob, msg.MessageID))
+	_, err := ts.store.GetMessage(msg.MessageID)
+	assert.Nil(t, err)
+
+	// nor may a key ID stand in for the fingerprint
+	ts.engine.signer = bobFpr
+	assert.Equal(t, api.ErrUnauthorized, ts.client.DeleteMessage(context.Background(), &common.User{Key: "BBBB2222"}, msg.MessageID))
+	assert.Nil(t, ts.client.DeleteMessage(context.Background(), bob, msg.MessageID))
+	_, err = ts.store.GetMessage(msg.MessageID)
+	assert.Equal(t, common.ErrNoResult, err)
+	assert.Equal(t, api.ErrNotFound, ts.client.DeleteMessage(context.Background(), bob, msg.MessageID))
+}
+
+func TestSendLimits(t *testing.T) {
+	config := DefaultConfig()
+	config.Limits = Limits{
+		MaxMessageSize:     10,
+		MaxMailboxMessages: 2,
+	}
+	ts := startServer(t, config)
+	defer ts.Close()
+
+	alice := &common.User{Key: aliceFpr}
+	bob := &common.User{Key: bobFpr}
+
+	ts.engine.signer = aliceFpr
+	err := ts.client.Send(context.Background(), &common.Message{From: alice, To: bob, Content: make([]byte, 11)})
+	assert.Equal(t, api.ErrMessageTooLarge, err)
+
+	assert.Nil(t, ts.client.Send(context.Background(), &common.Message{From: alice, To: bob, Content: make([]byte, 10)}))
+	// only the recipient tells what it played, and the sender can't say
+	// for it
+	played := &common.Message{From: alice, To: bob, Content: make([]byte, 10), Played: true, BurnAfterPlaying: true,
+		Verification: common.VerifyTrusted, RemoteURL: "http://example.com/1"}
+	assert.Nil(t, ts.client.Send(context.Background(), played))
+	stored, err := ts.store.GetMessage(played.MessageID)
+	assert.Nil(t, err)
+	assert.False(t, stored.Played)
+	assert.Equal(t, common.Verification(""), stored.Verification)
+	assert.Equal(t, "", stored.RemoteURL)
+	err = ts.client.Send(context.Background(), &common.Message{From: alice, To: bob, Content: make([]byte, 10), Played: true})
+	assert.Equal(t, api.ErrMailboxFull, err)
+
+	// played messages make room
+	ts.engine.signer = bobFpr
+	assert.Nil(t, ts.client.Acknowledge(context.Background(), bob, played.MessageID))
+	ts.engine.signer = aliceFpr
+	assert.Nil(t, ts.client.Send(context.Background(), &common.Message{From: alice, To: bob, Content: make([]byte, 10)}))
+
+	// bob blocks carol
+	carol := &common.User{Key: carolFpr}
+	assert.Nil(t, ts.store.SetSenderRule(&common.SenderRule{Owner: carol.Key, Sender: alice.Key}))
+	err = ts.client.Send(context.Background(), &common.Message{From: alice, To: carol, Content: make([]byte, 1)})
+	assert.Equal(t, api.ErrSenderBlocked, err)
+	// whatever the case of the keys
+	lower := &common.User{Key: strings.ToLower(aliceFpr)}
+	err = ts.client.Send(context.Background(), &common.Message{From: lower, To: carol, Content: make([]byte, 1)})
+	assert.Equal(t, api.ErrSenderBlocked, err)
+	err = ts.client.Send(context.Background(), &common.Message{From: alice, To: &common.User{Key: strings.ToLower(carolFpr)}, Content: make([]byte, 1)})
+	assert.Equal(t, api.ErrSenderBlocked, err)
+	ts.engine.signer = carolFpr
+	assert.Nil(t, ts.client.SetSenderRule(context.Background(), carol, alice.Key, false, true))
+	assert.Nil(t, ts.client.SetSenderRule(context.Background(), &common.User{Key: strings.ToLower(carolFpr)}, lower.Key, false, false))
+	rules, err := ts.store.GetSenderRules(carolFpr)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(rules)) {
+		assert.Equal(t, aliceFpr, rules[0].Sender)
+	}
+	ts.engine.signer = aliceFpr
+	err = ts.client.Send(context.Background(), &common.Message{From: alice, To: carol, Content: make([]byte, 1)})
+	assert.Equal(t, api.ErrSenderBlocked, err)
+
+	// nor does she get through as someone else
+	err = ts.client.Send(context.Background(), &common.Message{From: bob, To: carol, Content: make([]byte, 1)})
+	assert.Equal(t, api.ErrUnauthorized, err)
+	res, err := http.Post(ts.URL+"/v1/messages", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"from":{"key":"%s"},"to":{"key":"%s"},"content":"aGk="}`, bob.Key, carol.Key)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
+
+func TestRetention(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	config := DefaultConfig()
+	config.Retention = 24 * time.Hour
+	config.Clock = clock
+	ts := startServer(t, config)
+	defer ts.Close()
+
+	// dated when it arrives, whenever the sender says it was sent
+	ts.engine.signer = aliceFpr
+	future := &common.Message{From: &common.User{Key: aliceFpr}, To: &common.User{Key: bobFpr}, Content: []byte("hi"),
+		CreatedAt: time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC)}
+	assert.Nil(t, ts.client.Send(context.Background(), future))
+	past := &common.Message{From: &common.User{Key: aliceFpr}, To: &common.User{Key: bobFpr}, Content: []byte("hi")}
+	assert.Nil(t, ts.client.Send(context.Background(), past))
+
+	n, err := ts.srv.janitor.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	clock.now = clock.now.Add(25 * time.Hour)
+	n, err = ts.srv.janitor.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	_, err = ts.store.GetMessage(future.MessageID)
+	assert.Equal(t, common.ErrNoResult, err)
+}
+
+func TestRateLimited(t *testing.T) {
+	config := DefaultConfig()
+	config.RateLimits = RateLimitOptions{Rate: 1, Burst: 1}
+	config.Clock = &fakeClock{now: time.Date(2015, 1, 24, 12, 0, 0, 0, time.UTC)}
+	ts := startServer(t, config)
+	defer ts.Close()
+	client, err := api.NewClientWithOptions(ts.URL, &api.ClientOptions{Retries: -1})
+	assert.Nil(t, err)
+	ts.client = client
+
+	bob := &common.User{Key: bobFpr}
+	_, err = ts.client.GetMessages(context.Background(), bob)
+	assert.Nil(t, err)
+	_, err = ts.client.GetMessages(context.Background(), bob)
+	assert.Equal(t, api.ErrRateLimited, err)
+
+	// signed requests count against their signer, whatever key they claim
+	ts.engine.signer = aliceFpr
+	var req common.SenderRuleRequest
+	_, apiErr := ts.srv.verifyRequest(strings.NewReader("{}"), &req)
+	assert.Nil(t, apiErr)
+	_, apiErr = ts.srv.verifyRequest(strings.NewReader("{}"), &req)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.status)
+	ts.engine.signer = bobFpr
+	_, apiErr = ts.srv.verifyRequest(strings.NewReader("{}"), &req)
+	assert.Nil(t, apiErr)
+}
+
+func TestV1Errors(t *testing.T) {
+	ts := startServer(t, nil)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/v1/users")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+	res, err = http.Get(ts.URL + "/v1/nothing-here")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+	res, err = http.Get(ts.URL + "/v1/openapi.json")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestEvents(t *testing.T) {
+	ts := startServer(t, nil)
+	defer ts.Close()
+
+	alice := &common.User{Name: "Alice", Key: aliceFpr}
+	bob := &common.User{Name: "Bob", Key: bobFpr}
+
+	// signed by someone else
+	ts.engine.signer = carolFpr
+	_, err := ts.client.Subscribe(context.Background(), bob)
+	assert.NotNil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts.engine.signer = bobFpr
+	bobEvents, err := ts.client.Subscribe(ctx, bob)
+	assert.Nil(t, err)
+	ts.engine.signer = aliceFpr
+	aliceEvents, err := ts.client.Subscribe(ctx, alice)
+	assert.Nil(t, err)
+
+	msg := &common.Message{From: alice, To: bob, Content: []byte("hi")}
+	assert.Nil(t, ts.client.Send(context.Background(), msg))
+	ev := nextEvent(t, bobEvents)
+	if assert.NotNil(t, ev) {
+		assert.Equal(t, common.EventMessage, ev.Type)
+		assert.Equal(t, msg.MessageID, ev.Message.MessageID)
+		assert.Equal(t, "Alice", ev.Message.From.Name)
+		assert.Nil(t, ev.Message.Content)
+	}
+
+	_, err = ts.client.DownloadMessage(context.Background(), msg.MessageID)
+	assert.Nil(t, err)
+	ts.engine.signer = bobFpr
+	assert.Nil(t, ts.client.Acknowledge(context.Background(), bob, msg.MessageID))
+	ev = nextEvent(t, aliceEvents)
+	if assert.NotNil(t, ev) {
+		assert.Equal(t, common.EventReceipt, ev.Type)
+		assert.Equal(t, msg.MessageID, ev.Message.MessageID)
+	}
+
+	cancel()
+	for range bobEvents {
+	}
+}
+
+func TestGroups(t *testing.T) {
+	config := DefaultConfig()
+	config.RateLimits.Burst = 100
+	ts := startServer(t, config)
+	defer ts.Close()
+	ctx := context.Background()
+
+	alice := &common.User{Name: "Alice", Email: "alice@example.com", Key: aliceFpr}
+	bob := &common.User{Name: "Bob", Email: "bob@example.com", Key: bobFpr}
+	carol := &common.User{Name: "Carol", Email: "carol@example.com", Key: carolFpr}
+	dave := &common.User{Name: "Dave", Email: "dave@example.com", Key: daveFpr}
+	for _, u := range []*common.User{alice, bob, carol, dave} {
+		assert.Nil(t, ts.client.Register(ctx, u))
+	}
+
+	ts.engine.signer = aliceFpr
+	group, err := ts.client.SetGroup(ctx, alice, &common.Group{Name: "team", Members: []*common.User{bob, carol}}, false)
+	assert.Nil(t, err)
+	if assert.NotNil(t, group) && assert.Equal(t, 2, len(group.Members)) {
+		assert.Equal(t, aliceFpr, group.Owner)
+		assert.Equal(t, "Bob", group.Members[0].Name)
+	}
+	// only alice may change it
+	ts.engine.signer = bobFpr
+	_, err = ts.client.SetGroup(ctx, bob, &common.Group{Name: "team", Members: []*common.User{bob}}, false)
+	assert.Equal(t, api.ErrUnauthorized, err)
+
+	group, err = ts.client.GetGroup(ctx, carol, "team")
+	assert.Nil(t, err)
+	assert.Equal(t, "team", group.Name)
+	_, err = ts.client.GetGroup(ctx, dave, "team")
+	assert.Equal(t, api.ErrNotFound, err)
+	groups, err := ts.client.GetGroups(ctx, bob)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(groups))
+
+	// carol doesn't want to hear from bob, alice still does
+	ts.engine.signer = carolFpr
+	assert.Nil(t, ts.client.SetSenderRule(ctx, carol, bob.Key, false, false))
+	msg := &common.Message{From: bob, Group: "team", CreatedAt: time.Now(), Content: []byte("hello team")}
+	ts.engine.signer = bobFpr
+	assert.Nil(t, ts.client.Upload(ctx, msg, nil))
+	assert.True(t, msg.MessageID > 0)
+	for _, x := range []struct {
+		user *common.User
+		n    int
+	}{{alice, 1}, {bob, 0}, {carol, 0}} {
+		messages, err := ts.client.GetMessages(ctx, x.user)
+		assert.Nil(t, err)
+		if assert.Equal(t, x.n, len(messages), x.user.Name) && x.n > 0 {
+			assert.Equal(t, "team", messages[0].Group)
+			assert.Equal(t, "Bob", messages[0].From.Name)
+		}
+	}
+	ts.engine.signer = carolFpr
+	assert.Nil(t, ts.client.SetSenderRule(ctx, carol, bob.Key, false, true))
+	ts.engine.signer = aliceFpr
+	assert.Nil(t, ts.client.Send(ctx, &common.Message{From: alice, Group: "team", CreatedAt: time.Now(), Content: []byte("hi")}))
+	messages, err := ts.client.GetMessages(ctx, carol)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+
+	// outsiders and sealed messages are refused
+	ts.engine.signer = daveFpr
+	err = ts.client.Upload(ctx, &common.Message{From: dave, Group: "team", CreatedAt: time.Now(), Content: []byte("hi")}, nil)
+	assert.Equal(t, api.ErrUnauthorized, err)
+	err = ts.client.Upload(ctx, &common.Message{Sealed: true, Group: "team", CreatedAt: time.Now(), Content: []byte("hi")}, nil)
+	assert.NotNil(t, err)
+	ts.engine.signer = aliceFpr
+	err = ts.client.Upload(ctx, &common.Message{From: alice, Group: "nobody", CreatedAt: time.Now(), Content: []byte("hi")}, nil)
+	assert.NotNil(t, err)
+
+	ts.engine.signer = aliceFpr
+	_, err = ts.client.SetGroup(ctx, alice, &common.Group{Name: "team"}, true)
+	assert.Nil(t, err)
+	_, err = ts.client.GetGroup(ctx, alice, "team")
+	assert.Equal(t, api.ErrNotFound, err)
+}
+
+func TestSealedSender(t *testing.T) {
+	ts := startServer(t, nil)
+	defer ts.Close()
+
+	alice := &common.User{Name: "Alice", Key: aliceFpr}
+	bob := &common.User{Name: "Bob", Key: bobFpr}
+	assert.Nil(t, ts.client.Register(context.Background(), bob))
+
+	sent := time.Date(2015, 1, 24, 12, 0, 0, 0, time.UTC)
+	msg := &common.Message{From: alice, To: bob, CreatedAt: sent, Duration: 3 * time.Second, Content: []byte("hi"),
+		UUID: "u2", ThreadID: "u1", InReplyTo: "u1"}
+	sealed, err := ts.client.Seal(msg)
+	assert.Nil(t, err)
+	assert.Nil(t, sealed.From)
+	// a client that doesn't play along gets its metadata dropped
+	sealed.Duration = time.Second
+	assert.Nil(t, ts.client.Upload(context.Background(), sealed, nil))
+
+	stored, err := ts.store.GetMessage(sealed.MessageID)
+	assert.Nil(t, err)
+	assert.Nil(t, stored.From)
+	assert.True(t, stored.Sealed)
+	assert.Equal(t, time.Duration(0), stored.Duration)
+	assert.NotEqual(t, sent.Unix(), stored.CreatedAt.Unix())
+	assert.Equal(t, "", stored.ThreadID)
+
+	messages, err := ts.client.GetMessages(context.Background(), bob)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(messages)) {
+		m := messages[0]
+		assert.Nil(t, m.From)
+		assert.True(t, m.Sealed)
+
+		m.Content, err = ts.client.DownloadMessage(context.Background(), m.MessageID)
+		assert.Nil(t, err)
+
+		// signed by someone else than the sender it names
+		ts.engine.signer = carolFpr
+		_, _, err = ts.client.Unseal(bob, m)
+		assert.Equal(t, api.ErrBadSeal, err)
+		assert.Equal(t, common.VerifyMismatch, m.Verification)
+
+		// the key of the sender is fetched when it isn't known yet
+		ts.engine.signer = aliceFpr
+		ts.engine.strict = true
+		assert.NotContains(t, ts.engine.recvKeys, aliceFpr)
+		audio, _, err := ts.client.Unseal(bob, m)
+		assert.Nil(t, err)
+		assert.Contains(t, ts.engine.recvKeys, aliceFpr)
+		assert.Equal(t, common.VerifyUntrusted, m.Verification)
+		assert.Equal(t, []byte("hi"), audio)
+		assert.Equal(t, "Alice", m.From.Name)
+		assert.Equal(t, sent.Unix(), m.CreatedAt.Unix())
+		assert.Equal(t, 3*time.Second, m.Duration)
+		assert.Equal(t, "u2", m.UUID)
+		assert.Equal(t, "u1", m.ThreadID)
+		assert.Equal(t, "u1", m.InReplyTo)
+	}
+
+	// blocking a sender doesn't keep out their sealed messages, blocking
+	// sealed messages does
+	ts.engine.signer = bobFpr
+	assert.Nil(t, ts.client.SetSenderRule(context.Background(), bob, alice.Key, false, false))
+	sealed, err = ts.client.Seal(msg)
+	assert.Nil(t, err)
+	assert.Nil(t, ts.client.Send(context.Background(), sealed))
+	assert.Nil(t, ts.client.SetSenderRule(context.Background(), bob, "Sealed", false, false))
+	sealed, err = ts.client.Seal(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, api.ErrSenderBlocked, ts.client.Send(context.Background(), sealed))
+
+	// an allow list can't tell who sent a sealed message, unless it allows
+	// them all
+	ts.store.DeleteSenderRule(bob.Key, alice.Key)
+	ts.store.DeleteSenderRule(bob.Key, common.SealedSender)
+	ts.store.SetSenderRule(&common.SenderRule{Owner: bob.Key, Sender: alice.Key, Allow: true})
+	sealed, err = ts.client.Seal(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, api.ErrSenderBlocked, ts.client.Send(context.Background(), sealed))
+	assert.Nil(t, ts.client.SetSenderRule(context.Background(), bob, common.SealedSender, true, false))
+	sealed, err = ts.client.Seal(msg)
+	assert.Nil(t, err)
+	assert.Nil(t, ts.client.Send(context.Background(), sealed))
+}
+
+func nextEvent(t *testing.T, events <-chan *common.Event) *common.Event {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Error("no event")
+		return nil
+	}
+}
+
+// cutReader gives up after left bytes, like a connection that dropped.
+type cutReader struct {
+	r    io.Reader
+	left int
+}
+
+func (c *cutReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= n
+	return n, err
+}
+
+// cutWriter lets left bytes of the response through.
+type cutWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (c *cutWriter) Write(p []byte) (int, error) {
+	if len(p) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.ResponseWriter.Write(p)
+	c.left -= n
+	if err == nil && c.left <= 0 {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+func hangUp(w http.ResponseWriter) {
+	if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+		conn.Close()
+	}
+}
+
+func TestKeyDirectory(t *testing.T) {
+	ts := startServer(t, nil)
+	defer ts.Close()
+	ctx := context.Background()
+
+	// alice's key is fetched by the server, carol uploads hers
+	alice := &common.User{Name: "Alice", Email: "alice@example.com", Key: aliceFpr}
+	assert.Nil(t, ts.client.Register(ctx, alice))
+	carolKey := crypto.Key{Name: "Carol", Email: "carol@example.com", PublicKey: "CCCC3333", Fingerprint: carolFpr}
+	ts.engine.keys = []crypto.Key{carolKey}
+	carol := &common.User{Name: "Carol", Email: "carol@example.com", Key: carolFpr}
+	assert.Equal(t, crypto.ErrKeyNotFound, ts.client.RegisterKey(ctx, alice))
+
+	// signed by someone else
+	ts.engine.signer = daveFpr
+	assert.Equal(t, api.ErrUnauthorized, ts.client.RegisterKey(ctx, carol))
+	ts.engine.signer = carolKey.Fingerprint
+	assert.Nil(t, ts.client.RegisterKey(ctx, carol))
+	assert.True(t, carol.UserID > 0)
+	assert.Equal(t, []string{aliceFpr}, ts.engine.recvKeys)
+
+	// a key ID is registered as the fingerprint of the key it names
+	assert.Nil(t, ts.client.Register(ctx, &common.User{Name: "Carol", Key: "89ABCDEFCCCC3333"}))
+	_, err := ts.store.FindUserByKey(carolFpr)
+	assert.Nil(t, err)
+
+	// an email the key doesn't carry
+	mallory := &common.User{Name: "Mallory", Email: "mallory@example.com", Key: carolFpr}
+	assert.Equal(t, api.ErrUnauthorized, ts.client.RegisterKey(ctx, mallory))
+
+	keys, err := ts.client.LookupKeys(ctx, "Carol@Example.com")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(keys)) {
+		assert.Equal(t, carolFpr, keys[0].Key)
+		assert.Equal(t, carolKey.Fingerprint, keys[0].Fingerprint)
+		assert.NotEmpty(t, keys[0].Data)
+	}
+	// alice never proved her email
+	_, err = ts.client.LookupKeys(ctx, "alice@example.com")
+	assert.Equal(t, api.ErrKeyNotFound, err)
+
+	chain := api.NewKeyChain(ts.engine, &api.ServerResolver{Client: ts.client})
+	keys, err = chain.Resolve(ctx, "carol@example.com")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(keys)) {
+		assert.Equal(t, carolFpr, keys[0].Key)
+		assert.Equal(t, "Carol", keys[0].Name)
+	}
+	_, err = chain.Resolve(ctx, "dave@example.com")
+	assert.Equal(t, api.ErrKeyNotFound, err)
+
+	// a key without the email looked up stays out of the keyring
+	eveKey := crypto.Key{Name: "Eve", Email: "eve@example.com", Fingerprint: "0123456789ABCDEF0123456789ABCDEFEEEE5555"}
+	data, err := json.Marshal(&eveKey)
+	assert.Nil(t, err)
+	chain = api.NewKeyChain(ts.engine, keysResolver{{Key: eveKey.Fingerprint, Fingerprint: eveKey.Fingerprint, Data: data}})
+	_, err = chain.Resolve(ctx, "carol@example.com")
+	assert.Equal(t, api.ErrKeyNotFound, err)
+	assert.False(t, ts.engine.knows(eveKey.Fingerprint))
+}
+
+// keysResolver finds the same keys for any email.
+type keysResolver []*common.PublicKey
+
+func (r keysResolver) Resolve(ctx context.Context, email string) ([]*common.PublicKey, error) {
+	return r, nil
+}
+
+func TestResumableTransfer(t *testing.T) {
+	ts := startServer(t, nil)
+	defer ts.Close()
+
+	// break the first chunk upload and the first download halfway, and
+	// lose the answer to the first finish
+	var puts, gets, finishes int32
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && atomic.AddInt32(&puts, 1) == 1:
+			r.Body = ioutil.NopCloser(&cutReader{r: r.Body, left: 100 << 10})
+			ts.srv.ServeHTTP(httptest.NewRecorder(), r)
+			hangUp(w)
+		case strings.HasSuffix(r.URL.Path, "/finish") && atomic.AddInt32(&finishes, 1) == 1:
+			ts.srv.ServeHTTP(httptest.NewRecorder(), r)
+			hangUp(w)
+		case strings.HasSuffix(r.URL.Path, "/content") && atomic.AddInt32(&gets, 1) == 1:
+			ts.srv.ServeHTTP(&cutWriter{ResponseWriter: w, left: 200 << 10}, r)
+			hangUp(w)
+		default:
+			ts.srv.ServeHTTP(w, r)
+		}
+	})
+	client, err := api.NewClientWithOptions(ts.URL, &api.ClientOptions{RetryBackoff: time.Millisecond})
+	assert.Nil(t, err)
+	client.SetEngine(ts.engine)
+	ts.engine.signer = aliceFpr
+
+	content := make([]byte, api.DefaultChunkSize*5/2)
+	rand.Read(content)
+	msg := &common.Message{
+		From:             &common.User{Key: aliceFpr},
+		To:               &common.User{Key: bobFpr},
+		Content:          content,
+		BurnAfterPlaying: true,
+	}
+	var progress []int64
+	assert.Nil(t, client.Upload(context.Background(), msg, func(done, total int64) {
+		assert.Equal(t, int64(len(content)), total)
+		progress = append(progress, done)
+	}))
+	assert.True(t, msg.MessageID > 0)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&puts))
+	assert.Equal(t, int64(len(content)), progress[len(progress)-1])
+
+	// finished twice, delivered once
+	assert.Equal(t, int32(2), atomic.LoadInt32(&finishes))
+	messages, err := client.GetMessages(context.Background(), msg.To)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages))
+
+	downloaded, err := client.Download(context.Background(), msg.MessageID, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, content, downloaded)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&gets))
+
+	// burnt once the recipient played it
+	ts.engine.signer = bobFpr
+	assert.Nil(t, client.Acknowledge(context.Background(), msg.To, msg.MessageID))
+	_, err = client.DownloadMessage(context.Background(), msg.MessageID)
+	assert.Equal(t, api.ErrNotFound, err)
+}
+
+func TestUploadChecks(t *testing.T) {
+	config := DefaultConfig()
+	config.Limits.MaxMessageSize = 1000
+	ts := startServer(t, config)
+	defer ts.Close()
+
+	msg := &common.Message{
+		From:    &common.User{Key: aliceFpr},
+		To:      &common.User{Key: bobFpr},
+		Content: make([]byte, 1001),
+	}
+	ts.engine.signer = aliceFpr
+	assert.Equal(t, api.ErrMessageTooLarge, ts.client.Upload(context.Background(), msg, nil))
+
+	// chunks must follow each other
+	res, err := http.Post(ts.URL+"/v1/uploads", "application/json",
+		strings.NewReader(`{"message":{"sealed":true,"to":{"key":"B"}},"size":10}`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	var created struct {
+		Data common.Upload `json:"data"`
+	}
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&created))
+	res.Body.Close()
+
+	put := func(contentRange, body string) int {
+		req, _ := http.NewRequest("PUT", ts.URL+"/v1/uploads/"+created.Data.ID, strings.NewReader(body))
+		req.Header.Set("Content-Range", contentRange)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, http.StatusConflict, put("bytes 5-9/10", "56789"))
+	assert.Equal(t, http.StatusOK, put("bytes 0-4/10", "01234"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, put("bytes 5-10/10", "56789X"))
+
+	res, err = http.Post(ts.URL+"/v1/uploads/"+created.Data.ID+"/finish", "application/json",
+		strings.NewReader(`{"sha256":"00"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = http.Get(ts.URL + "/v1/uploads/../../etc/passwd")
+	assert.Nil(t, err)
+	assert.NotEqual(t, http.StatusOK, res.StatusCode)
+}
+
+func TestPendingUploads(t *testing.T) {
+	config := DefaultConfig()
+	config.Limits.MaxPendingUploads = 2
+	config.Limits.MaxMailboxBytes = 25
+	ts := startServer(t, config)
+	defer ts.Close()
+
+	// unfinished uploads count against the mailbox
+	start := func(to string) int {
+		res, err := http.Post(ts.URL+"/v1/uploads", "application/json",
+			strings.NewReader(`{"message":{"sealed":true,"to":{"key":"`+to+`"}},"size":10}`))
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, http.StatusCreated, start(bobFpr))
+	assert.Equal(t, http.StatusCreated, start(bobFpr))
+	assert.Equal(t, http.StatusInsufficientStorage, start(bobFpr))
+	assert.Equal(t, http.StatusCreated, start(carolFpr))
+
+	// and a sender may only leave so many
+	alice := &common.User{Key: aliceFpr}
+	for _, to := range []string{carolFpr, daveFpr} {
+		msg := &common.Message{From: alice, To: &common.User{Key: to}}
+		_, err := ts.srv.uploads.create(msg, nil, 10, time.Now())
+		assert.Nil(t, err)
+	}
+	ts.engine.signer = aliceFpr
+	msg := &common.Message{From: alice, To: &common.User{Key: daveFpr}, Content: []byte("hi")}
+	assert.Equal(t, api.ErrTooManyUploads, ts.client.Upload(context.Background(), msg, nil))
+}
+
+func TestFinishLock(t *testing.T) {
+	u := newUploadStore(os.TempDir())
+	unlock := u.lockFinish("a")
+	// other uploads don't wait
+	u.lockFinish("b")()
+
+	done := make(chan bool)
+	go func() {
+		u.lockFinish("a")()
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatal("finished the same upload twice at once")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	<-done
+	assert.Equal(t, 0, len(u.finishing))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"github.com/gophergala/gopher_talkie/src/server"
+	"log"
+	"os"
+	"path"
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "talkie-server"
+	app.Usage = "Secure voicing messaging for geeks"
+	app.Version = "0.1.0"
+	app.Author = "Tom Li"
+	app.Email = "nklizhe@gmail.com"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "host",
+			Value: "0.0.0.0",
+		},
+		cli.IntFlag{
+			Name:  "port",
+			Value: 3333,
+		},
+		cli.StringFlag{
+			Name: "server-key",
+		},
+		cli.StringFlag{
+			Name:  "gnupg-home",
+			Usage: "gpg home directory with the server key and the keys of registered users (default: gnupg next to the database)",
+		},
+		cli.StringFlag{
+			Name:  "passphrase",
+			Value: "agent",
+			Usage: "where the passphrase of the server key comes from: agent, env[:NAME] or fd:N",
+		},
+		cli.StringFlag{
+			Name:  "db",
+			Value: path.Join(os.Getenv("HOME"), ".talkie", "talkie.db"),
+		},
+		cli.IntFlag{
+			Name:  "max-message-size",
+			Value: server.DefaultMaxMessageSize,
+			Usage: "largest message in bytes, 0 for no limit",
+		},
+		cli.IntFlag{
+			Name:  "max-mailbox-messages",
+			Value: server.DefaultMaxMailboxMessages,
+			Usage: "most messages waiting per recipient and not played yet, 0 for no limit",
+		},
+		cli.IntFlag{
+			Name:  "max-mailbox-bytes",
+			Value: server.DefaultMaxMailboxBytes,
+			Usage: "most bytes waiting per recipient and not played yet, 0 for no limit",
+		},
+		cli.IntFlag{
+			Name:  "max-pending-uploads",
+			Value: server.DefaultMaxPendingUploads,
+			Usage: "most unfinished uploads per sender, 0 for no limit",
+		},
+		cli.Float64Flag{
+			Name:  "rate-limit",
+			Value: server.DefaultRateLimit,
+			Usage: "requests per second allowed per client address and per signing key, 0 for no limit",
+		},
+		cli.IntFlag{
+			Name:  "rate-burst",
+			Value: server.DefaultRateBurst,
+		},
+		cli.Float64Flag{
+			Name:  "register-rate-limit",
+			Value: server.DefaultRegisterRateLimit,
+			Usage: "registrations per second allowed per client address, 0 for no limit",
+		},
+		cli.IntFlag{
+			Name:  "register-burst",
+			Value: server.DefaultRegisterBurst,
+		},
+		cli.StringFlag{
+			Name:  "upload-dir",
+			Value: path.Join(os.Getenv("HOME"), ".talkie", "uploads"),
+			Usage: "where unfinished uploads are kept",
+		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "PEM certificate to serve HTTPS with",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "PEM private key of --tls-cert",
+		},
+		cli.BoolFlag{
+			Name:  "tls-self-signed",
+			Usage: "serve HTTPS with a self-signed certificate, generated into --tls-cert and --tls-key if they don't exist yet; the default without them",
+		},
+		cli.BoolFlag{
+			Name:  "plain-http",
+			Usage: "serve plain HTTP instead of HTTPS, clients then need --server http://<host:port>",
+		},
+		cli.DurationFlag{
+			Name:  "retention",
+			Usage: "delete messages older than this, 0 keeps them until they expire",
+		},
+		cli.DurationFlag{
+			Name:  "janitor-interval",
+			Value: common.DefaultJanitorInterval,
+			Usage: "how often expired messages are purged",
+		},
+	}
+	app.Action = func(c *cli.Context) {
+		config := server.DefaultConfig()
+		config.ServerKey = c.String("server-key")
+		config.Limits = server.Limits{
+			MaxMessageSize:     int64(c.Int("max-message-size")),
+			MaxMailboxMessages: c.Int("max-mailbox-messages"),
+			MaxMailboxBytes:    int64(c.Int("max-mailbox-bytes")),
+			MaxPendingUploads:  c.Int("max-pending-uploads"),
+		}
+		config.RateLimits = server.RateLimitOptions{
+			Rate:          c.Float64("rate-limit"),
+			Burst:         c.Int("rate-burst"),
+			RegisterRate:  c.Float64("register-rate-limit"),
+			RegisterBurst: c.Int("register-burst"),
+		}
+		config.Retention = c.Duration("retention")
+		config.JanitorInterval = c.Duration("janitor-interval")
+		config.UploadDir = c.String("upload-dir")
+
+		dbPath := c.String("db")
+		if err := os.MkdirAll(path.Dir(dbPath), 0755); err != nil {
+			log.Fatal(err)
+		}
+		store := common.NewStoreSqlite(&common.SqliteStoreOptions{
+			DBPath: dbPath,
+		})
+		defer store.Close()
+
+		passphrase, err := crypto.ParsePassphraseSource(c.String("passphrase"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		// keys of registered users don't belong in the operator's keyring
+		home := c.String("gnupg-home")
+		if home == "" {
+			home = path.Join(path.Dir(dbPath), "gnupg")
+		}
+		engine, err := crypto.NewGPGEngineWithOptions(&crypto.GPGEngineOptions{
+			Home:       home,
+			Passphrase: passphrase,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if config.ServerKey != "" {
+			if keys, _ := engine.ListSecretKeys(config.ServerKey); len(keys) == 0 {
+				log.Fatalf("server key %s is not in %s, import it with\n  gpg --export-secret-keys %s | gpg --homedir %s --import", config.ServerKey, home, config.ServerKey, home)
+			}
+		}
+		srv := server.NewServer(store, engine, config)
+		srv.Start()
+		defer srv.Close()
+
+		httpServer := server.NewHTTPServer(c.String("host"), c.Int("port"), srv)
+		certFile, keyFile, err := tlsFiles(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if certFile == "" {
+			log.Printf("WARNING: serving plain HTTP, anyone on the way sees who talks to whom")
+			fmt.Printf("Listening http://%s:%d...", c.String("host"), c.Int("port"))
+			log.Fatal(httpServer.ListenAndServer())
+		}
+
+		fingerprint, err := server.CertFileFingerprint(certFile, keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Certificate fingerprint (SHA-256): %s\n", fingerprint)
+		fmt.Printf("Listening https://%s:%d...", c.String("host"), c.Int("port"))
+		log.Fatal(httpServer.ListenAndServeTLS(certFile, keyFile))
+	}
+	app.Run(os.Args)
+}
+
+// tlsFiles returns the certificate and key to serve HTTPS with: the given
+// ones, or else a self-signed pair, generated once. Both are empty for plain
+// HTTP, which has to be asked for.
+func tlsFiles(c *cli.Context) (string, string, error) {
+	certFile, keyFile := c.String("tls-cert"), c.String("tls-key")
+	if c.Bool("plain-http") {
+		if certFile != "" || keyFile != "" || c.Bool("tls-self-signed") {
+			return "", "", errors.New("--plain-http serves no certificate")
+		}
+		return "", "", nil
+	}
+	if !c.Bool("tls-self-signed") && (certFile != "" || keyFile != "") {
+		if (certFile == "") != (keyFile == "") {
+			return "", "", errors.New("--tls-cert and --tls-key go together")
+		}
+		return certFile, keyFile, nil
+	}
+
+	if certFile == "" {
+		certFile = path.Join(os.Getenv("HOME"), ".talkie", "server.crt")
+	}
+	if keyFile == "" {
+		keyFile = path.Join(os.Getenv("HOME"), ".talkie", "server.key")
+	}
+	if _, err := os.Stat(certFile); err == nil {
+		// keep the certificate clients already pinned
+		return certFile, keyFile, nil
+	}
+
+	hosts := []string{"localhost", "127.0.0.1"}
+	if h := c.String("host"); h != "0.0.0.0" && h != "" {
+		hosts = append(hosts, h)
+	}
+	if h, err := os.Hostname(); err == nil {
+		hosts = append(hosts, h)
+	}
+	if err := os.MkdirAll(path.Dir(certFile), 0750); err != nil {
+		return "", "", err
+	}
+	if err := server.GenerateSelfSignedCert(certFile, keyFile, hosts); err != nil {
+		return "", "", err
+	}
+	log.Printf("generated self-signed certificate %s", certFile)
+	return certFile, keyFile, nil
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestGenerateSelfSignedCert(t *testing.T) {
+	dir := path.Join(os.TempDir(), fmt.Sprintf("talkie-tls-%d", os.Getpid()))
+	assert.Nil(t, os.MkdirAll(dir, 0700))
+	defer os.RemoveAll(dir)
+	certFile, keyFile := path.Join(dir, "server.crt"), path.Join(dir, "server.key")
+
+	assert.Nil(t, GenerateSelfSignedCert(certFile, keyFile, []string{"localhost", "127.0.0.1"}))
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.Nil(t, err)
+
+	fingerprint, err := CertFileFingerprint(certFile, keyFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 64, len(fingerprint))
+	assert.Equal(t, 1, len(cert.Certificate))
+
+	info, err := os.Stat(keyFile)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,472 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultUploadExpiry = 24 * time.Hour
+
+	// largest chunk accepted by one PUT
+	maxChunkSize = 4 << 20
+)
+
+var (
+	ErrUploadNotFound      = errors.New("upload not found")
+	ErrUploadOffset        = errors.New("chunk does not start at the upload offset")
+	ErrUploadIncomplete    = errors.New("upload is incomplete")
+	ErrChecksumMismatch    = errors.New("checksum mismatch")
+	ErrInvalidContentRange = errors.New("invalid Content-Range")
+)
+
+// uploadState is kept in <dir>/<id>.json, the content received so far in
+// <dir>/<id>.part. The size of the latter is the offset of the upload.
+// Request is what the sender signed for the message when the upload
+// started, nil for a sealed one. Once delivered, the part is gone and
+// MessageID and SHA256 tell what became of it, until the upload expires.
+type uploadState struct {
+	common.Upload
+	Message   *common.Message     `json:"message"`
+	Request   *common.SendRequest `json:"request,omitempty"`
+	MessageID int64               `json:"message_id,omitempty"`
+	SHA256    string              `json:"sha256,omitempty"`
+}
+
+// uploadStore keeps unfinished uploads on disk so they survive restarts.
+// finishing holds a lock per upload being delivered, so it is delivered
+// once.
+type uploadStore struct {
+	dir       string
+	mutex     sync.Mutex
+	finishing map[string]*finishLock
+}
+
+// finishLock is held while an upload is delivered, by one of waiting
+// finishes of it.
+type finishLock struct {
+	sync.Mutex
+	waiting int
+}
+
+func newUploadStore(dir string) *uploadStore {
+	if dir == "" {
+		dir = path.Join(os.TempDir(), "talkie-uploads")
+	}
+	return &uploadStore{
+		dir:       dir,
+		finishing: make(map[string]*finishLock),
+	}
+}
+
+// lockFinish waits for other finishes of upload id, and returns what ends
+// this one.
+func (u *uploadStore) lockFinish(id string) func() {
+	u.mutex.Lock()
+	l, ok := u.finishing[id]
+	if !ok {
+		l = &finishLock{}
+		u.finishing[id] = l
+	}
+	l.waiting++
+	u.mutex.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		u.mutex.Lock()
+		defer u.mutex.Unlock()
+		if l.waiting--; l.waiting == 0 {
+			delete(u.finishing, id)
+		}
+	}
+}
+
+func (u *uploadStore) statePath(id string) string {
+	return path.Join(u.dir, id+".json")
+}
+
+func (u *uploadStore) partPath(id string) string {
+	return path.Join(u.dir, id+".part")
+}
+
+// validUploadID keeps IDs from the URL from reaching outside of dir.
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func (u *uploadStore) create(msg *common.Message, req *common.SendRequest, size int64, now time.Time) (*uploadState, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	state := &uploadState{
+		Upload: common.Upload{
+			ID:        hex.EncodeToString(b),
+			Size:      size,
+			CreatedAt: now,
+		},
+		Message: msg,
+		Request: req,
+	}
+	d, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if err := os.MkdirAll(u.dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(u.partPath(state.ID), nil, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(u.statePath(state.ID), d, 0600); err != nil {
+		os.Remove(u.partPath(state.ID))
+		return nil, err
+	}
+	return state, nil
+}
+
+func (u *uploadStore) get(id string) (*uploadState, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.load(id)
+}
+
+func (u *uploadStore) load(id string) (*uploadState, error) {
+	if !validUploadID(id) {
+		return nil, ErrUploadNotFound
+	}
+	d, err := ioutil.ReadFile(u.statePath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state uploadState
+	if err := json.Unmarshal(d, &state); err != nil {
+		return nil, err
+	}
+	if state.MessageID != 0 {
+		state.Offset = state.Size
+		return &state, nil
+	}
+	info, err := os.Stat(u.partPath(id))
+	if err != nil {
+		return nil, err
+	}
+	state.Offset = info.Size()
+	return &state, nil
+}
+
+// write appends data at offset, which must be where the upload stands.
+// Whatever arrives is kept even if data breaks off, the client resumes from
+// there.
+func (u *uploadStore) write(id string, offset int64, data io.Reader) (*uploadState, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	state, err := u.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != state.Offset || state.MessageID != 0 {
+		return state, ErrUploadOffset
+	}
+
+	f, err := os.OpenFile(u.partPath(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	n, copyErr := io.Copy(f, io.LimitReader(data, state.Size-offset+1))
+	if state.Offset+n > state.Size {
+		// more than announced
+		f.Truncate(state.Size)
+		state.Offset = state.Size
+		return state, ErrMessageTooLarge
+	}
+	state.Offset += n
+	return state, copyErr
+}
+
+// pending returns the uploads not delivered yet.
+func (u *uploadStore) pending() ([]*uploadState, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(u.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var states []*uploadState
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		d, err := ioutil.ReadFile(path.Join(u.dir, f.Name()))
+		if err != nil {
+			continue
+		}
+		var state uploadState
+		if json.Unmarshal(d, &state) != nil || state.MessageID != 0 || state.Message == nil {
+			continue
+		}
+		states = append(states, &state)
+	}
+	return states, nil
+}
+
+func (u *uploadStore) content(id string) ([]byte, error) {
+	return ioutil.ReadFile(u.partPath(id))
+}
+
+// delivered records that state became the message with msgID, and drops
+// its content.
+func (u *uploadStore) delivered(state *uploadState, msgID int64, sha256 string) error {
+	state.MessageID = msgID
+	state.SHA256 = sha256
+	state.Message.Content = nil
+	d, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if err := ioutil.WriteFile(u.statePath(state.ID), d, 0600); err != nil {
+		return err
+	}
+	os.Remove(u.partPath(state.ID))
+	return nil
+}
+
+func (u *uploadStore) remove(id string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	os.Remove(u.statePath(id))
+	os.Remove(u.partPath(id))
+}
+
+// purge removes uploads started before before.
+func (u *uploadStore) purge(before time.Time) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(u.dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") || !f.ModTime().Before(before) {
+			continue
+		}
+		id := strings.TrimSuffix(f.Name(), ".json")
+		os.Remove(u.statePath(id))
+		os.Remove(u.partPath(id))
+	}
+}
+
+// parseContentRange returns where the chunk in "bytes <start>-<end>/<size>"
+// starts.
+func parseContentRange(header string) (int64, error) {
+	var start, end int64
+	var size string
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%s", &start, &end, &size); err != nil {
+		return 0, ErrInvalidContentRange
+	}
+	if start < 0 || end < start {
+		return 0, ErrInvalidContentRange
+	}
+	return start, nil
+}
+
+func uploadError(err error) *apiError {
+	switch err {
+	case ErrUploadNotFound:
+		return newAPIError(http.StatusNotFound, common.ErrCodeNotFound, err)
+	case ErrUploadOffset, ErrUploadIncomplete:
+		return newAPIError(http.StatusConflict, common.ErrCodeUploadOffset, err)
+	case ErrMessageTooLarge:
+		return newAPIError(http.StatusRequestEntityTooLarge, common.ErrCodeMessageTooLarge, err)
+	case ErrChecksumMismatch:
+		return newAPIError(http.StatusBadRequest, common.ErrCodeChecksumMismatch, err)
+	}
+	return internalError(err)
+}
+
+// POST /v1/uploads
+func (s *Server) createUpload(w http.ResponseWriter, r *http.Request) {
+	var req common.UploadRequest
+	if err := parseJSON(http.MaxBytesReader(w, r.Body, maxRegisterBodySize), &req); err != nil {
+		responseAPIError(w, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err))
+		return
+	}
+	if req.Message == nil || req.Size <= 0 {
+		responseAPIError(w, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, ErrInvalidMessage))
+		return
+	}
+	// refuse now what would be refused once all of it is uploaded
+	signed, apiErr := s.verifySender(req.Message)
+	if apiErr == nil {
+		apiErr = s.checkMessage(req.Message, req.Size)
+	}
+	if apiErr != nil {
+		responseAPIError(w, apiErr)
+		return
+	}
+
+	now := s.config.Clock.Now()
+	s.uploads.purge(now.Add(-s.config.UploadExpiry))
+	if apiErr := s.checkPendingUploads(req.Message, req.Size); apiErr != nil {
+		responseAPIError(w, apiErr)
+		return
+	}
+	req.Message.Content = nil
+	state, err := s.uploads.create(req.Message, signed, req.Size, now)
+	if err != nil {
+		responseAPIError(w, internalError(err))
+		return
+	}
+	responseCreated(w, &state.Upload)
+}
+
+// checkPendingUploads returns an error if msg, size bytes large, may not be
+// uploaded next to those still unfinished: its sender has too many of them,
+// or they would fill the recipient's mailbox. Members of a group are
+// checked when a message to it is delivered.
+func (s *Server) checkPendingUploads(msg *common.Message, size int64) *apiError {
+	limits := &s.config.Limits
+	states, err := s.uploads.pending()
+	if err != nil {
+		return internalError(err)
+	}
+	var sent, pending int
+	var pendingBytes int64
+	for _, state := range states {
+		if msg.From != nil && state.Message.From != nil && strings.EqualFold(state.Message.From.Key, msg.From.Key) {
+			sent++
+		}
+		if msg.To != nil && state.Message.To != nil && strings.EqualFold(state.Message.To.Key, msg.To.Key) {
+			pending++
+			pendingBytes += state.Size
+		}
+	}
+	if limits.MaxPendingUploads > 0 && sent >= limits.MaxPendingUploads {
+		// not worth retrying right away, unlike a rate limit
+		return newAPIError(http.StatusConflict, common.ErrCodeTooManyUploads, ErrTooManyUploads)
+	}
+	if msg.Group != "" || pending == 0 {
+		return nil
+	}
+	return limits.checkDelivery(s.store, msg.To.Key, size, pending, pendingBytes)
+}
+
+// GET /v1/uploads/{id}
+func (s *Server) getUpload(w http.ResponseWriter, r *http.Request) {
+	state, err := s.uploads.get(mux.Vars(r)["id"])
+	if err != nil {
+		responseAPIError(w, uploadError(err))
+		return
+	}
+	responseSuccess(w, &state.Upload)
+}
+
+// PUT /v1/uploads/{id} with a Content-Range header
+func (s *Server) putUploadChunk(w http.ResponseWriter, r *http.Request) {
+	offset, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		responseAPIError(w, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err))
+		return
+	}
+
+	state, err := s.uploads.write(mux.Vars(r)["id"], offset, http.MaxBytesReader(w, r.Body, maxChunkSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = ErrMessageTooLarge
+	}
+	if err != nil {
+		responseAPIError(w, uploadError(err))
+		return
+	}
+	responseSuccess(w, &state.Upload)
+}
+
+// POST /v1/uploads/{id}/finish
+func (s *Server) finishUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var req common.UploadFinish
+	if err := parseJSON(http.MaxBytesReader(w, r.Body, maxRegisterBodySize), &req); err != nil {
+		responseAPIError(w, newAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, err))
+		return
+	}
+
+	// one finish at a time, a retried one answers with the message the
+	// first one delivered
+	defer s.uploads.lockFinish(id)()
+
+	state, err := s.uploads.get(id)
+	if err == nil && state.MessageID != 0 {
+		if !strings.EqualFold(state.SHA256, req.SHA256) {
+			responseAPIError(w, uploadError(ErrChecksumMismatch))
+			return
+		}
+		responseCreated(w, state.MessageID)
+		return
+	}
+	if err == nil && state.Offset < state.Size {
+		err = ErrUploadIncomplete
+	}
+	var content []byte
+	if err == nil {
+		content, err = s.uploads.content(id)
+	}
+	if err != nil {
+		responseAPIError(w, uploadError(err))
+		return
+	}
+
+	sum := sha256.Sum256(content)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), req.SHA256) || !signedContent(state.Request, content) {
+		// start over, something got mangled on the way
+		s.uploads.remove(id)
+		responseAPIError(w, uploadError(ErrChecksumMismatch))
+		return
+	}
+
+	msg := state.Message
+	msg.Content = content
+	if apiErr := s.deliverMessage(msg); apiErr != nil {
+		responseAPIError(w, apiErr)
+		return
+	}
+	if err := s.uploads.delivered(state, msg.MessageID, req.SHA256); err != nil {
+		// a retry would deliver it again
+		s.uploads.remove(id)
+	}
+	responseCreated(w, msg.MessageID)
+}
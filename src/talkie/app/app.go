@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,9 +9,11 @@ import (
 	"github.com/gophergala/gopher_talkie/src/api"
 	"github.com/gophergala/gopher_talkie/src/common"
 	"github.com/gophergala/gopher_talkie/src/crypto"
+	"github.com/gophergala/gopher_talkie/src/transcribe"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"time"
 )
 
@@ -19,15 +22,41 @@ const (
 	Author             = "Tom Li"
 	Email              = "nklizhe@gmail.com"
 	DefaultMaxDuration = time.Duration(15) * time.Second
+	DefaultServer      = "https://130.211.156.226:3333"
 )
 
 var (
 	ErrNoKeyFound = errors.New("no key found")
 	ErrNoUser     = errors.New("no user")
+	ErrKeyChanged = errors.New("key changed since it was pinned")
+	ErrNoSender   = errors.New("the message doesn't tell who sent it")
+	ErrNoAudio    = errors.New("the audio of the message was not kept")
+
+	ErrNoTranscriber = errors.New("no transcription engine, pass --engine or set transcriber in ~/.talkie/config")
+	ErrNewerArchive  = errors.New("the archive was made by a newer talkie")
+	ErrChecksum      = errors.New("the audio doesn't match its checksum in the manifest")
+	ErrTwoSources    = errors.New("send --file or --stdin, not both")
+	ErrNotAIFF       = errors.New("only aiff audio is sent as it is, leave out --transcode=false to convert it")
 )
 
 type AppConfig struct {
 	CurrentUser string `json:"current_user,omitempty"`
+	Server      string `json:"server,omitempty"`
+	// CAFile and PinnedCert are used to verify the TLS certificate of Server
+	CAFile     string `json:"ca_file,omitempty"`
+	PinnedCert string `json:"pinned_cert,omitempty"`
+	// GPGHome is the gpg home directory with the keys of this profile,
+	// empty for gpg's default
+	GPGHome string `json:"gnupg_home,omitempty"`
+	// KeyRegistration records which key was uploaded to which server, as
+	// "<key> <server url>", so it is signed and uploaded only once
+	KeyRegistration string `json:"key_registration,omitempty"`
+	// Transcriber is the engine talkie transcribe uses
+	Transcriber *transcribe.Options `json:"transcriber,omitempty"`
+	// InputDevice and OutputDevice are the audio devices to record from and
+	// play on, or part of their names; empty for the default ones
+	InputDevice  string `json:"input_device,omitempty"`
+	OutputDevice string `json:"output_device,omitempty"`
 }
 
 type App struct {
@@ -37,6 +66,7 @@ type App struct {
 	store       common.Store
 	maxDuration time.Duration
 	client      *api.Client
+	engine      crypto.Engine
 }
 
 func NewApp() *App {
@@ -52,7 +82,24 @@ func NewApp() *App {
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:  "server",
-			Value: "130.211.156.226:3333",
+			Usage: fmt.Sprintf("server address, host:port for https or http://host:port for plain HTTP (default %s)", DefaultServer),
+		},
+		cli.StringFlag{
+			Name:  "ca-file",
+			Usage: "PEM bundle of certificate authorities trusted for the server",
+		},
+		cli.StringFlag{
+			Name:  "pin-cert",
+			Usage: "SHA-256 fingerprint the server certificate must have",
+		},
+		cli.StringFlag{
+			Name:  "gnupg-home",
+			Usage: "gpg home directory with your keys (default: gnupg_home in the config, or gpg's own)",
+		},
+		cli.StringFlag{
+			Name:  "passphrase",
+			Value: "agent",
+			Usage: "where the passphrase of your key comes from: agent, tty, env[:NAME] or fd:N",
 		},
 	}
 	app.Version = Version
@@ -61,7 +108,20 @@ func NewApp() *App {
 	app.Commands = []cli.Command{
 		NewListCommand(this),
 		NewSendCommand(this),
-		// NewPlayCommand(this),
+		NewReplyCommand(this),
+		NewPlayCommand(this),
+		NewRecordCommand(this),
+		NewPlayFileCommand(this),
+		NewTranscribeCommand(this),
+		NewExportCommand(this),
+		NewImportCommand(this),
+		NewBlockCommand(this),
+		NewAllowCommand(this),
+		NewUnblockCommand(this),
+		NewWatchCommand(this),
+		NewPinCommand(this),
+		NewContactsCommand(this),
+		NewGroupCommand(this),
 		// NewDeleteCommand(this),
 	}
 
@@ -133,7 +193,7 @@ func (this *App) saveConfig(cfg *AppConfig) error {
 
 func (this *App) selectCurrentUser() *common.User {
 	// List all users of gpg
-	keys, err := crypto.GPGListSecretKeys("")
+	keys, err := this.engine.ListSecretKeys("")
 	if err != nil {
 		return nil
 	}
@@ -164,7 +224,7 @@ func (this *App) selectCurrentUser() *common.User {
 	user := &common.User{
 		Name:  k.Name,
 		Email: k.Email,
-		Key:   k.PublicKey,
+		Key:   k.Fingerprint,
 	}
 	err = this.store.AddUser(user)
 	if err != nil {
@@ -173,9 +233,62 @@ func (this *App) selectCurrentUser() *common.User {
 	return user
 }
 
+// upgradeUserKey names the current user by the full fingerprint of its key
+// instead of a key ID, which the server no longer takes for it, along with
+// its local messages. The key is registered again with the next setup.
+func (this *App) upgradeUserKey() {
+	keys, err := this.engine.ListSecretKeys(this.user.Key)
+	if err != nil || len(keys) != 1 {
+		return
+	}
+	user := *this.user
+	user.UserID = 0
+	user.Key = keys[0].Fingerprint
+	if err := this.store.RenameKey(this.user.Key, user.Key); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if err := this.store.AddUser(&user); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	this.user = &user
+	if this.config != nil && this.config.CurrentUser != "" {
+		this.config.CurrentUser = user.Key
+		this.saveConfig(this.config)
+	}
+}
+
 func (this *App) setup(c *cli.Context) error {
+	if this.engine == nil {
+		passphrase, err := crypto.ParsePassphraseSource(c.GlobalString("passphrase"))
+		if err != nil {
+			return err
+		}
+		home := c.GlobalString("gnupg-home")
+		if home == "" && this.config != nil {
+			home = this.config.GPGHome
+		}
+		engine, err := crypto.NewGPGEngineWithOptions(&crypto.GPGEngineOptions{
+			Home:       home,
+			Passphrase: passphrase,
+		})
+		if err != nil {
+			return err
+		}
+		this.engine = engine
+	}
 	if this.client == nil {
-		this.client = api.NewClient(c.GlobalString("server"))
+		client, err := this.newClient(c)
+		if err != nil {
+			return err
+		}
+		this.client = client
+	}
+
+	// drop local copies of messages that have expired
+	if _, err := common.NewJanitor(this.store, nil).Purge(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
 	}
 
 	if this.user == nil {
@@ -189,22 +302,101 @@ func (this *App) setup(c *cli.Context) error {
 			this.user = this.selectCurrentUser()
 		}
 	}
+	if this.user != nil && !crypto.IsFingerprint(this.user.Key) {
+		this.upgradeUserKey()
+	}
 
 	if this.user == nil {
 		return ErrNoUser
 	}
 
-	if err := this.client.Register(this.user); err != nil {
+	if this.config == nil {
+		this.config = &AppConfig{}
+	}
+	save := this.config.CurrentUser == ""
+
+	// upload the public key once, so others can find it by email; if that
+	// fails the server fetches it from the keyserver as it always did
+	registration := this.user.Key + " " + this.client.GetURL("", nil)
+	if this.config.KeyRegistration != registration && this.client.RegisterKey(context.Background(), this.user) == nil {
+		this.config.KeyRegistration = registration
+		save = true
+	} else if err := this.client.Register(context.Background(), this.user); err != nil {
 		return err
 	}
 
 	// save config
-	if this.config == nil {
-		this.config = &AppConfig{
-			CurrentUser: this.user.Key,
+	if save {
+		if this.config.CurrentUser == "" {
+			this.config.CurrentUser = this.user.Key
 		}
 		this.saveConfig(this.config)
 	}
 
 	return nil
 }
+
+// inputDevice is the configured audio device to record from, empty for
+// the default one.
+func (this *App) inputDevice() string {
+	if this.config == nil {
+		return ""
+	}
+	return this.config.InputDevice
+}
+
+// outputDevice is the configured audio device to play on, empty for the
+// default one.
+func (this *App) outputDevice() string {
+	if this.config == nil {
+		return ""
+	}
+	return this.config.OutputDevice
+}
+
+// serverAddr is the URL of the server given on the command line, the
+// configured one or the default one.
+func (this *App) serverAddr(c *cli.Context) string {
+	if s := c.GlobalString("server"); s != "" {
+		return serverURL(s)
+	}
+	if this.config != nil && this.config.Server != "" {
+		return serverURL(this.config.Server)
+	}
+	return DefaultServer
+}
+
+// serverURL is addr with its scheme, https for a bare "host:port" as the
+// client takes it.
+func serverURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	return "https://" + addr
+}
+
+func (this *App) newClient(c *cli.Context) (*api.Client, error) {
+	addr := this.serverAddr(c)
+	options := &api.ClientOptions{
+		CAFile:     c.GlobalString("ca-file"),
+		PinnedCert: c.GlobalString("pin-cert"),
+	}
+	// the configured CA and pin only vouch for the configured server
+	if this.config != nil && this.config.Server != "" && serverURL(this.config.Server) == addr {
+		if options.CAFile == "" {
+			options.CAFile = this.config.CAFile
+		}
+		if options.PinnedCert == "" {
+			options.PinnedCert = this.config.PinnedCert
+		}
+	}
+
+	client, err := api.NewClientWithOptions(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	if this.engine != nil {
+		client.SetEngine(this.engine)
+	}
+	return client, nil
+}
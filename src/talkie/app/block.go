@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"os"
+)
+
+func NewBlockCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "block",
+		Usage: "refuse messages from a key, or `sealed` ones whoever sent them",
+		Action: func(c *cli.Context) {
+			this.setSenderRule(c, false, false)
+		},
+	}
+}
+
+func NewAllowCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "allow",
+		Usage: "only accept messages from allowed keys, and allow this one, or `sealed` ones",
+		Action: func(c *cli.Context) {
+			this.setSenderRule(c, true, false)
+		},
+	}
+}
+
+func NewUnblockCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "unblock",
+		Usage: "forget a block or allow rule for a key",
+		Action: func(c *cli.Context) {
+			this.setSenderRule(c, false, true)
+		},
+	}
+}
+
+func (this *App) setSenderRule(c *cli.Context, allow, remove bool) {
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if len(c.Args()) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: missing key\n")
+		return
+	}
+
+	sender := c.Args()[0]
+	if err := this.client.SetSenderRule(context.Background(), this.user, sender, allow, remove); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	switch {
+	case remove:
+		fmt.Printf("Removed rule for %s.\n", sender)
+	case allow:
+		fmt.Printf("Allowed %s.\n", sender)
+	default:
+		fmt.Printf("Blocked %s.\n", sender)
+	}
+}
@@ -0,0 +1,268 @@
+package app
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"os"
+	"strings"
+	"time"
+)
+
+func NewContactsCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "contacts",
+		Usage: "manage the people you talk to",
+		Subcommands: []cli.Command{
+			{
+				Name:  "add",
+				Usage: "add <alias> <key, email or name>",
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "favorite",
+						Usage: "list the contact first",
+					},
+				},
+				Action: func(c *cli.Context) {
+					this.addContact(c)
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "list your contacts, favorites first",
+				Action: func(c *cli.Context) {
+					this.listContacts(c)
+				},
+			},
+			{
+				Name:  "search",
+				Usage: "search <text> in your contacts, the users you talked to and your keyring",
+				Action: func(c *cli.Context) {
+					this.searchContacts(c)
+				},
+			},
+			{
+				Name:  "rename-alias",
+				Usage: "rename-alias <alias> <new alias>",
+				Action: func(c *cli.Context) {
+					this.renameContact(c)
+				},
+			},
+			{
+				Name:  "remove",
+				Usage: "remove <alias>",
+				Action: func(c *cli.Context) {
+					this.removeContact(c)
+				},
+			},
+			{
+				Name:  "verify",
+				Usage: "compare safety numbers with a contact, and accept a key that changed",
+				Action: func(c *cli.Context) {
+					this.verifyContact(c)
+				},
+			},
+		},
+	}
+}
+
+func (this *App) addContact(c *cli.Context) {
+	if len(c.Args()) < 2 {
+		fmt.Printf("Usage: talkie contacts add <alias> <key, email or name>\n")
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	alias := c.Args()[0]
+	user := this.resolveRecipient(c.Args()[1], false)
+	if user == nil {
+		fmt.Printf("No user found!\n")
+		return
+	}
+
+	contact := &common.Contact{
+		Alias:    alias,
+		Key:      user.Key,
+		Name:     user.Name,
+		Email:    user.Email,
+		Favorite: c.Bool("favorite"),
+	}
+	if err := this.store.AddContact(contact); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	fmt.Printf("Added %s: %s %s\n", alias, userLabel(user), user.Key)
+}
+
+func (this *App) listContacts(c *cli.Context) {
+	contacts, err := this.store.GetContacts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if len(contacts) == 0 {
+		fmt.Printf("No contacts yet, add one with `talkie contacts add <alias> <key, email or name>`.\n")
+		return
+	}
+	printContacts(contacts)
+}
+
+func printContacts(contacts []*common.Contact) {
+	for _, contact := range contacts {
+		star := " "
+		if contact.Favorite {
+			star = "*"
+		}
+		fmt.Printf("%s %-16s %-40s %s\n", star, contact.Alias, userLabel(contact.User()), contact.Key)
+	}
+}
+
+func (this *App) searchContacts(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie contacts search <text>\n")
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	query := c.Args()[0]
+
+	contacts, err := this.store.SearchContacts(query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if len(contacts) > 0 {
+		fmt.Printf("Contacts:\n")
+		printContacts(contacts)
+	}
+
+	// users talked to and keys in the keyring, unless they are contacts
+	seen := make(map[string]bool)
+	for _, contact := range contacts {
+		seen[contact.Key] = true
+	}
+	var others []*common.User
+	users, _ := this.store.FindUserByName(query)
+	byEmail, _ := this.store.FindUsersByEmail(query)
+	for _, user := range append(users, byEmail...) {
+		if !seen[user.Key] {
+			seen[user.Key] = true
+			others = append(others, user)
+		}
+	}
+	keys, _ := this.engine.ListPublicKeys(query)
+	for _, k := range keys {
+		if !seen[k.Fingerprint] {
+			seen[k.Fingerprint] = true
+			others = append(others, &common.User{Key: k.Fingerprint, Name: k.Name, Email: k.Email})
+		}
+	}
+	if len(others) > 0 {
+		fmt.Printf("Others:\n")
+		for _, user := range others {
+			fmt.Printf("  %-16s %-40s %s\n", "", userLabel(user), user.Key)
+		}
+	}
+
+	if len(contacts) == 0 && len(others) == 0 {
+		fmt.Printf("Nobody found.\n")
+	}
+}
+
+func (this *App) renameContact(c *cli.Context) {
+	if len(c.Args()) < 2 {
+		fmt.Printf("Usage: talkie contacts rename-alias <alias> <new alias>\n")
+		return
+	}
+	contact, err := this.store.FindContact(c.Args()[0])
+	if err != nil {
+		fmt.Printf("No contact %s!\n", c.Args()[0])
+		return
+	}
+	contact.Alias = c.Args()[1]
+	if err := this.store.UpdateContact(contact); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	fmt.Printf("Renamed %s to %s.\n", c.Args()[0], contact.Alias)
+}
+
+func (this *App) removeContact(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie contacts remove <alias>\n")
+		return
+	}
+	if err := this.store.DeleteContact(c.Args()[0]); err == common.ErrNoResult {
+		fmt.Printf("No contact %s!\n", c.Args()[0])
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+	}
+}
+
+func (this *App) verifyContact(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie contacts verify <email or key>\n")
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	contact := this.resolveRecipient(c.Args()[0], false)
+	if contact == nil || contact.Email == "" {
+		fmt.Printf("No contact found!\n")
+		return
+	}
+	fingerprint := this.fingerprint(contact.Key)
+	mine := this.fingerprint(this.user.Key)
+	if fingerprint == "" || mine == "" {
+		fmt.Printf("No public key %s in your keyring!\n", contact.Key)
+		return
+	}
+
+	pin, err := this.store.FindKeyPin(contact.Email)
+	if err != nil && err != common.ErrNoResult {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	changed := warnKeyChange(pin, contact, fingerprint)
+	if pin != nil && pin.Verified && !changed {
+		fmt.Printf("You already verified the key of %s.\n", userLabel(contact))
+	}
+
+	fmt.Printf("%s\n  %s\n", userLabel(contact), crypto.FormatFingerprint(fingerprint))
+	fmt.Printf("You\n  %s\n", crypto.FormatFingerprint(mine))
+	fmt.Printf("Safety number\n  %s\n", crypto.SafetyNumber(mine, fingerprint))
+	fmt.Printf("Compare it with %s in person or on a call: their talkie shows the same number.\n", contact.Name)
+	if changed {
+		fmt.Printf("Do the numbers match? Accept their new key? [y/N] > ")
+	} else {
+		fmt.Printf("Do the numbers match? [y/N] > ")
+	}
+	var answer string
+	fmt.Scanln(&answer)
+	if strings.ToLower(answer) != "y" {
+		fmt.Printf("Not verified.\n")
+		return
+	}
+
+	if pin == nil || changed {
+		pin = &common.KeyPin{
+			Email:     contact.Email,
+			FirstSeen: time.Now(),
+		}
+	}
+	pin.Fingerprint = fingerprint
+	pin.Verified = true
+	if err := this.store.SetKeyPin(pin); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	this.store.AddUser(contact)
+	fmt.Printf("Verified. The key of %s is pinned.\n", userLabel(contact))
+}
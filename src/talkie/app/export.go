@@ -0,0 +1,207 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/audio"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// archiveManifest is the name of the manifest in an archive directory.
+const archiveManifest = "manifest.json"
+
+func NewExportCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "export",
+		Usage: "export <id> -o file.{aiff,wav,opus}: save the audio of a message; export --all --dir <dir>: archive your mailbox",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "the file to write, its extension chooses the format (default message-<id>.aiff)",
+			},
+			cli.BoolFlag{
+				Name:  "all",
+				Usage: "export every message kept, with a manifest to import them back",
+			},
+			cli.StringFlag{
+				Name:  "dir",
+				Usage: "the directory to archive the mailbox to, with --all",
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Value: audio.FormatAIFF,
+				Usage: "the format of the audio archived with --all: aiff, wav or opus",
+			},
+			cli.BoolFlag{
+				Name:  "gpg",
+				Usage: "keep the message as it arrived, encrypted and signed by its sender, in a .gpg file alongside",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if c.Bool("all") {
+				this.exportAll(c)
+			} else {
+				this.export(c)
+			}
+		},
+	}
+}
+
+func (this *App) export(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie export <id> -o file.{aiff,wav,opus}, or talkie export --all --dir <dir>\n")
+		return
+	}
+	id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+	if err != nil {
+		fmt.Printf("Usage: talkie export <id> -o file.{aiff,wav,opus}, or talkie export --all --dir <dir>\n")
+		return
+	}
+	file := c.String("output")
+	if file == "" {
+		file = fmt.Sprintf("message-%d.%s", id, audio.FormatAIFF)
+	}
+	format, err := audio.FormatOf(file)
+	if err != nil {
+		fmt.Printf("Can't export to %s! Name a .aiff, .wav or .opus file.\n", file)
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+
+	msg, err := this.store.GetMessage(id)
+	if err == common.ErrNoResult {
+		fmt.Printf("No message %d!\n", id)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if _, err := this.exportMessage(msg, file, format, c.Bool("gpg")); err != nil {
+		fmt.Printf("Error exporting message %d! %s\n", id, err.Error())
+		return
+	}
+	fmt.Printf("Message %d from %s saved to %s.\n", id, userLabel(msg.From), file)
+	if c.Bool("gpg") {
+		fmt.Printf("As it arrived, signed by its sender, in %s.gpg.\n", file)
+	}
+}
+
+func (this *App) exportAll(c *cli.Context) {
+	dir := c.String("dir")
+	if dir == "" {
+		fmt.Printf("Usage: talkie export --all --dir <dir>\n")
+		return
+	}
+	format := c.String("format")
+	if _, err := audio.FormatOf("." + format); err != nil {
+		fmt.Printf("Unknown format %s! Use aiff, wav or opus.\n", format)
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+
+	messages, err := this.store.GetUserMessages(this.user.Key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	archive := &common.Archive{
+		Version:    common.ArchiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Owner:      this.user,
+	}
+	for _, m := range messages {
+		// the list leaves the content out
+		msg, err := this.store.GetMessage(m.MessageID)
+		if err != nil {
+			fmt.Printf("  Error reading message %d! %s\n", m.MessageID, err.Error())
+			continue
+		}
+		if len(msg.Content) == 0 {
+			fmt.Printf("  Skipping message %d, its audio was not kept.\n", msg.MessageID)
+			continue
+		}
+		name := fmt.Sprintf("%d.%s", msg.MessageID, format)
+		archived, err := this.exportMessage(msg, filepath.Join(dir, name), format, c.Bool("gpg"))
+		if err != nil {
+			fmt.Printf("  Error exporting message %d! %s\n", msg.MessageID, err.Error())
+			continue
+		}
+		archived.File = name
+		if c.Bool("gpg") {
+			archived.Ciphertext = name + ".gpg"
+		}
+		archive.Messages = append(archive.Messages, archived)
+	}
+
+	d, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, archiveManifest), d, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	fmt.Printf("Exported %d messages to %s, bring them back with `talkie import %s`.\n", len(archive.Messages), dir, dir)
+}
+
+// exportMessage decrypts msg and writes its audio to file in format, and
+// with keepCiphertext the message as it arrived to file.gpg. It returns how
+// msg is described in an archive.
+func (this *App) exportMessage(msg *common.Message, file, format string, keepCiphertext bool) (*common.ArchivedMessage, error) {
+	if len(msg.Content) == 0 {
+		return nil, ErrNoAudio
+	}
+	ciphertext := msg.Content
+	// a message whose seal is broken can't be told apart from a forgery,
+	// it is not exported
+	content, _, err := this.client.Open(this.user, msg)
+	if err != nil {
+		return nil, err
+	}
+	if format != audio.FormatAIFF || audio.Sniff(content) != audio.FormatAIFF {
+		samples, rate, err := audio.Decode(content)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = audio.Encode(samples, rate, format); err != nil {
+			return nil, err
+		}
+	}
+	if err := ioutil.WriteFile(file, content, 0600); err != nil {
+		return nil, err
+	}
+	if keepCiphertext {
+		if err := ioutil.WriteFile(file+".gpg", ciphertext, 0600); err != nil {
+			return nil, err
+		}
+	}
+	archived := common.NewArchivedMessage(msg)
+	archived.SHA256 = checksum(content)
+	return archived, nil
+}
+
+// checksum is the hex SHA-256 of d.
+func checksum(d []byte) string {
+	sum := sha256.Sum256(d)
+	return hex.EncodeToString(sum[:])
+}
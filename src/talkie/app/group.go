@@ -0,0 +1,248 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/api"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"os"
+	"strings"
+)
+
+func NewGroupCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "group",
+		Usage: "manage groups on the server, send to them with `talkie send @<name>`",
+		Subcommands: []cli.Command{
+			{
+				Name:  "create",
+				Usage: "create <name> <member...>",
+				Action: func(c *cli.Context) {
+					this.createGroup(c)
+				},
+			},
+			{
+				Name:  "add",
+				Usage: "add <name> <member...>",
+				Action: func(c *cli.Context) {
+					this.changeGroup(c, false)
+				},
+			},
+			{
+				Name:  "remove",
+				Usage: "remove <name> <member...>",
+				Action: func(c *cli.Context) {
+					this.changeGroup(c, true)
+				},
+			},
+			{
+				Name:  "show",
+				Usage: "show <name>",
+				Action: func(c *cli.Context) {
+					this.showGroup(c)
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "list the groups you own or are in",
+				Action: func(c *cli.Context) {
+					this.listGroups(c)
+				},
+			},
+			{
+				Name:  "delete",
+				Usage: "delete <name>",
+				Action: func(c *cli.Context) {
+					this.deleteGroup(c)
+				},
+			},
+		},
+	}
+}
+
+// groupName strips the @ a group may be written with.
+func groupName(s string) string {
+	return strings.ToLower(strings.TrimPrefix(s, "@"))
+}
+
+// groupMembers resolves the members named on the command line, nil if one
+// of them can't be found.
+func (this *App) groupMembers(args []string) []*common.User {
+	var members []*common.User
+	for _, arg := range args {
+		member := this.resolveRecipient(arg, false)
+		if member == nil {
+			fmt.Printf("No user found for %s!\n", arg)
+			return nil
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+func (this *App) createGroup(c *cli.Context) {
+	if len(c.Args()) < 2 {
+		fmt.Printf("Usage: talkie group create <name> <member...>\n")
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	name := groupName(c.Args()[0])
+	if !common.ValidGroupName(name) {
+		fmt.Printf("Bad group name %s! Use up to 32 lowercase letters, digits, '.', '_' or '-'.\n", name)
+		return
+	}
+	if _, err := this.client.GetGroup(context.Background(), this.user, name); err == nil {
+		fmt.Printf("Group @%s already exists, change it with `talkie group add` or `talkie group remove`.\n", name)
+		return
+	}
+	members := this.groupMembers(c.Args()[1:])
+	if members == nil {
+		return
+	}
+	this.saveGroup(&common.Group{Name: name, Members: members})
+}
+
+// changeGroup adds members to the group, or removes them from it.
+func (this *App) changeGroup(c *cli.Context, remove bool) {
+	if len(c.Args()) < 2 {
+		verb := "add"
+		if remove {
+			verb = "remove"
+		}
+		fmt.Printf("Usage: talkie group %s <name> <member...>\n", verb)
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	group := this.findGroup(groupName(c.Args()[0]))
+	if group == nil {
+		return
+	}
+	changed := this.groupMembers(c.Args()[1:])
+	if changed == nil {
+		return
+	}
+
+	keep := make(map[string]bool)
+	for _, m := range group.Members {
+		keep[strings.ToUpper(m.Key)] = true
+	}
+	members := group.Members
+	for _, m := range changed {
+		key := strings.ToUpper(m.Key)
+		if !remove && !keep[key] {
+			members = append(members, m)
+		}
+		keep[key] = !remove
+	}
+	group.Members = nil
+	for _, m := range members {
+		if keep[strings.ToUpper(m.Key)] {
+			group.Members = append(group.Members, m)
+		}
+	}
+	this.saveGroup(group)
+}
+
+// saveGroup uploads group, which only its owner can do.
+func (this *App) saveGroup(group *common.Group) {
+	saved, err := this.client.SetGroup(context.Background(), this.user, group, false)
+	switch err {
+	case nil:
+	case api.ErrUnauthorized:
+		fmt.Printf("Error: @%s belongs to someone else, only its owner can change it.\n", group.Name)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	printGroup(saved)
+}
+
+// findGroup fetches the group called name, nil if there is none or the
+// user isn't in it.
+func (this *App) findGroup(name string) *common.Group {
+	group, err := this.client.GetGroup(context.Background(), this.user, name)
+	if err == api.ErrNotFound {
+		fmt.Printf("No group @%s, or you are not in it!\n", name)
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return nil
+	}
+	return group
+}
+
+func (this *App) showGroup(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie group show <name>\n")
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if group := this.findGroup(groupName(c.Args()[0])); group != nil {
+		printGroup(group)
+	}
+}
+
+func (this *App) listGroups(c *cli.Context) {
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	groups, err := this.client.GetGroups(context.Background(), this.user)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if len(groups) == 0 {
+		fmt.Printf("No groups yet, create one with `talkie group create <name> <member...>`.\n")
+		return
+	}
+	for _, group := range groups {
+		mine := " "
+		if strings.EqualFold(group.Owner, this.user.Key) {
+			mine = "*"
+		}
+		fmt.Printf("%s @%-32s %d members\n", mine, group.Name, len(group.Members))
+	}
+}
+
+func (this *App) deleteGroup(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie group delete <name>\n")
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	name := groupName(c.Args()[0])
+	_, err := this.client.SetGroup(context.Background(), this.user, &common.Group{Name: name}, true)
+	switch err {
+	case nil:
+		fmt.Printf("Deleted @%s.\n", name)
+	case api.ErrNotFound:
+		fmt.Printf("No group @%s!\n", name)
+	case api.ErrUnauthorized:
+		fmt.Printf("Error: @%s belongs to someone else, only its owner can delete it.\n", name)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+	}
+}
+
+func printGroup(group *common.Group) {
+	fmt.Printf("@%s, owned by %s\n", group.Name, group.Owner)
+	for _, m := range group.Members {
+		fmt.Printf("  %-40s %s\n", userLabel(m), m.Key)
+	}
+}
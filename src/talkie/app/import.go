@@ -0,0 +1,144 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/audio"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func NewImportCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "import",
+		Usage: "import <dir>: bring back messages archived with `talkie export --all`",
+		Action: func(c *cli.Context) {
+			this.importArchive(c)
+		},
+	}
+}
+
+func (this *App) importArchive(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie import <dir>, a directory made by `talkie export --all`\n")
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+
+	dir, archive, err := readArchive(c.Args()[0])
+	if err != nil {
+		fmt.Printf("Error reading archive! %s\n", err.Error())
+		return
+	}
+	if archive.Owner != nil && archive.Owner.Key != this.user.Key {
+		shout(fmt.Sprintf("This is the mailbox of %s, you may not be able to open its messages.", userLabel(archive.Owner)))
+	}
+
+	imported, skipped := 0, 0
+	for _, a := range archive.Messages {
+		if this.imported(a) {
+			skipped++
+			continue
+		}
+		msg := a.Message()
+		if msg.Content, err = this.importContent(dir, a, msg); err != nil {
+			fmt.Printf("  Error importing message %d! %s\n", a.ID, err.Error())
+			continue
+		}
+		if msg.From != nil && msg.From.Key != "" {
+			if _, err := this.store.FindUserByKey(msg.From.Key); err == common.ErrNoResult {
+				this.store.AddUser(msg.From)
+			}
+		}
+		if err := this.store.AddMessage(msg); err != nil {
+			fmt.Printf("  Error importing message %d! %s\n", a.ID, err.Error())
+			continue
+		}
+		if a.Played {
+			this.store.UpdateMessagePlayed(msg.MessageID, true)
+		}
+		imported++
+	}
+	fmt.Printf("Imported %d messages, %d were there already.\n", imported, skipped)
+}
+
+// readArchive reads the manifest at p, or in the directory p, and tells
+// the directory its files are in.
+func readArchive(p string) (string, *common.Archive, error) {
+	dir, manifest := p, filepath.Join(p, archiveManifest)
+	if info, err := os.Stat(p); err == nil && !info.IsDir() {
+		dir, manifest = filepath.Dir(p), p
+	}
+	d, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		return "", nil, err
+	}
+	var archive common.Archive
+	if err := json.Unmarshal(d, &archive); err != nil {
+		return "", nil, err
+	}
+	if archive.Version > common.ArchiveVersion {
+		return "", nil, ErrNewerArchive
+	}
+	return dir, &archive, nil
+}
+
+// imported tells whether the archived message a is in the local store
+// already.
+func (this *App) imported(a *common.ArchivedMessage) bool {
+	if a.RemoteURL != "" {
+		if _, err := this.store.FindMessageByRemoteURL(a.RemoteURL); err == nil {
+			return true
+		}
+	}
+	if a.UUID != "" && a.ThreadID != "" {
+		thread, _ := this.store.GetThread(a.ThreadID)
+		for _, m := range thread {
+			if m.UUID == a.UUID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// importContent is the content to keep for the archived message a: the
+// message as it arrived if it was kept, so that its sender can still be
+// checked; otherwise its audio encrypted to the user again, as msg would
+// be. Only the original is signed by the sender, a message brought back
+// from its audio alone doesn't verify.
+func (this *App) importContent(dir string, a *common.ArchivedMessage, msg *common.Message) ([]byte, error) {
+	if a.Ciphertext != "" {
+		return ioutil.ReadFile(filepath.Join(dir, a.Ciphertext))
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, a.File))
+	if err != nil {
+		return nil, err
+	}
+	if a.SHA256 != "" && checksum(content) != a.SHA256 {
+		return nil, ErrChecksum
+	}
+	if audio.Sniff(content) != audio.FormatAIFF {
+		samples, rate, err := audio.Decode(content)
+		if err != nil {
+			return nil, err
+		}
+		content = audio.EncodeAIFF(samples, rate)
+	}
+
+	msg.Content = content
+	payload, err := json.Marshal(common.NewPayload(msg))
+	if err != nil {
+		return nil, err
+	}
+	msg.Sealed = false
+	msg.Verification = ""
+	return this.engine.Encrypt(this.user.Key, this.user.Key, bytes.NewReader(payload))
+}
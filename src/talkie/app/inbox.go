@@ -0,0 +1,626 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/api"
+	"github.com/gophergala/gopher_talkie/src/audio"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/transcribe"
+	"github.com/nsf/termbox-go"
+	"strings"
+	"time"
+)
+
+// how often the inbox asks the server for messages, on top of the events
+const inboxSyncInterval = 30 * time.Second
+
+// inbox is the full-screen view of `talkie list`. Its state belongs to the
+// loop in run; whatever runs in the background hands its results over as
+// functions on updates.
+type inbox struct {
+	app      *App
+	messages []*common.Message
+	selected int
+	top      int // first message shown in the pane
+
+	saved  map[int64]int64  // server message IDs to local ones
+	played map[int64]bool   // by server message ID
+	opened map[int64]string // what verifying the message said
+	warned map[int64]bool   // whether that is worth a warning
+	audio  map[int64][]byte // decrypted content
+
+	transcriber transcribe.Options
+	transcripts map[int64]string
+
+	playing *common.Message
+	player  *audio.Player
+	rec     *inboxRecording
+	confirm func() // runs on y, while asking
+	busy    map[int64]bool
+
+	status    string
+	statusErr bool
+
+	syncing  bool
+	syncedAt time.Time
+	syncErr  error
+	live     bool
+
+	ctx     context.Context
+	updates chan func()
+	done    bool
+}
+
+// inboxRecording is a reply being recorded, then sent.
+type inboxRecording struct {
+	label      string
+	recipients []*common.User
+	groups     []*common.Group
+	original   *common.Message
+	stop       chan int
+	samples    int
+	sending    bool
+	cancelled  bool
+}
+
+// runInbox shows messages, as fetched with err, in the full-screen inbox
+// until the user quits. transcriber is what transcribes them.
+func (this *App) runInbox(messages []*common.Message, err error, transcriber transcribe.Options) error {
+	if err := termbox.Init(); err != nil {
+		return err
+	}
+	defer termbox.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	box := &inbox{
+		app:     this,
+		saved:   make(map[int64]int64),
+		played:  make(map[int64]bool),
+		opened:  make(map[int64]string),
+		warned:  make(map[int64]bool),
+		audio:   make(map[int64][]byte),
+		busy:    make(map[int64]bool),
+		ctx:     ctx,
+		updates: make(chan func(), 16),
+
+		transcriber: transcriber,
+		transcripts: make(map[int64]string),
+	}
+	box.synced(messages, err)
+	box.run()
+	box.stopPlaying()
+	if box.rec != nil {
+		box.rec.cancelled = true
+		stopSignal(box.rec.stop)
+	}
+	return nil
+}
+
+func (this *inbox) run() {
+	events := make(chan termbox.Event)
+	go func() {
+		for {
+			ev := termbox.PollEvent()
+			select {
+			case events <- ev:
+			case <-this.ctx.Done():
+				return
+			}
+		}
+	}()
+	go this.watch()
+	ticker := time.NewTicker(inboxSyncInterval)
+	defer ticker.Stop()
+
+	for !this.done {
+		this.draw()
+		select {
+		case ev := <-events:
+			switch ev.Type {
+			case termbox.EventKey:
+				this.key(ev)
+			case termbox.EventError:
+				return
+			}
+		case f := <-this.updates:
+			f()
+		case <-ticker.C:
+			this.sync()
+		}
+	}
+}
+
+// post hands f over to the loop, unless the inbox is gone.
+func (this *inbox) post(f func()) {
+	select {
+	case this.updates <- f:
+	case <-this.ctx.Done():
+	}
+}
+
+// stopSignal stops whatever waits on the buffered stop, if it still does.
+func stopSignal(stop chan int) {
+	select {
+	case stop <- 1:
+	default:
+	}
+}
+
+func (this *inbox) setStatus(text string, isErr bool) {
+	this.status = strings.Replace(text, "\n", " ", -1)
+	this.statusErr = isErr
+}
+
+func (this *inbox) current() *common.Message {
+	if this.selected < 0 || this.selected >= len(this.messages) {
+		return nil
+	}
+	return this.messages[this.selected]
+}
+
+func (this *inbox) key(ev termbox.Event) {
+	if this.confirm != nil {
+		confirm := this.confirm
+		this.confirm = nil
+		this.setStatus("", false)
+		if ev.Ch == 'y' || ev.Ch == 'Y' {
+			confirm()
+		}
+		return
+	}
+	if this.rec != nil {
+		switch {
+		case ev.Key == termbox.KeyEnter || ev.Key == termbox.KeySpace:
+			stopSignal(this.rec.stop)
+		case ev.Key == termbox.KeyEsc || ev.Key == termbox.KeyCtrlC:
+			if !this.rec.sending {
+				this.rec.cancelled = true
+				stopSignal(this.rec.stop)
+			}
+		}
+		return
+	}
+
+	switch {
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		if this.selected > 0 {
+			this.selected--
+		}
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		if this.selected < len(this.messages)-1 {
+			this.selected++
+		}
+	case ev.Key == termbox.KeyEnter || ev.Key == termbox.KeySpace || ev.Ch == 'p':
+		if m := this.current(); m != nil {
+			this.togglePlay(m)
+		}
+	case ev.Ch == 'x':
+		this.stopPlaying()
+	case ev.Key == termbox.KeyArrowLeft || ev.Ch == 'h':
+		if this.player != nil {
+			this.player.Skip(-playSkip)
+		}
+	case ev.Key == termbox.KeyArrowRight || ev.Ch == 'l':
+		if this.player != nil {
+			this.player.Skip(playSkip)
+		}
+	case ev.Ch == '+' || ev.Ch == '=':
+		if this.player != nil {
+			stepSpeed(this.player, playSpeedStep)
+		}
+	case ev.Ch == '-':
+		if this.player != nil {
+			stepSpeed(this.player, -playSpeedStep)
+		}
+	case ev.Ch >= '0' && ev.Ch <= '9':
+		if this.player != nil {
+			this.player.Seek(this.player.Duration() * time.Duration(ev.Ch-'0') / 10)
+		}
+	case ev.Ch == 'r':
+		if m := this.current(); m != nil {
+			this.reply(m)
+		}
+	case ev.Ch == 'd':
+		if m := this.current(); m != nil {
+			this.setStatus(fmt.Sprintf("Delete the message from %s? (y/n)", this.sender(m)), false)
+			this.confirm = func() { this.delete(m) }
+		}
+	case ev.Ch == 't':
+		if m := this.current(); m != nil {
+			this.transcribe(m)
+		}
+	case ev.Ch == 'm':
+		if m := this.current(); m != nil {
+			this.markPlayed(m, !this.played[m.MessageID])
+		}
+	case ev.Ch == 's':
+		this.sync()
+	case ev.Ch == 'q' || ev.Key == termbox.KeyEsc || ev.Key == termbox.KeyCtrlC:
+		this.done = true
+	}
+}
+
+// watch syncs whenever the server tells a message arrived, and says
+// whether it can.
+func (this *inbox) watch() {
+	for {
+		events, err := this.app.client.Subscribe(this.ctx, this.app.user)
+		if err == nil {
+			this.post(func() { this.live = true })
+			for ev := range events {
+				if ev.Type == common.EventMessage {
+					this.post(this.sync)
+				}
+			}
+			this.post(func() { this.live = false })
+		}
+		select {
+		case <-time.After(watchRetryDelay):
+		case <-this.ctx.Done():
+			return
+		}
+	}
+}
+
+func (this *inbox) sync() {
+	if this.syncing {
+		return
+	}
+	this.syncing = true
+	go func() {
+		messages, err := this.app.client.GetMessages(this.ctx, this.app.user)
+		this.post(func() { this.synced(messages, err) })
+	}()
+}
+
+// synced takes the messages on the server, keeping what is known of the
+// ones already shown.
+func (this *inbox) synced(messages []*common.Message, err error) {
+	this.syncing = false
+	this.syncErr = err
+	if err != nil {
+		return
+	}
+	this.syncedAt = time.Now()
+
+	known := make(map[int64]*common.Message)
+	for _, m := range this.messages {
+		known[m.MessageID] = m
+	}
+	var selectedID int64
+	if m := this.current(); m != nil {
+		selectedID = m.MessageID
+	}
+
+	arrived := 0
+	this.messages = this.messages[:0]
+	for _, m := range messages {
+		if old, ok := known[m.MessageID]; ok {
+			m = old
+		} else {
+			arrived++
+			if local, err := this.app.store.FindMessageByRemoteURL(this.app.messageURL(m)); err == nil {
+				this.saved[m.MessageID] = local.MessageID
+				this.played[m.MessageID] = local.Played
+			}
+		}
+		if m.MessageID == selectedID {
+			this.selected = len(this.messages)
+		}
+		this.messages = append(this.messages, m)
+	}
+	if this.selected >= len(this.messages) {
+		this.selected = len(this.messages) - 1
+	}
+	if this.selected < 0 {
+		this.selected = 0
+	}
+	if arrived > 0 && len(known) > 0 {
+		this.setStatus(fmt.Sprintf("%d new message(s).", arrived), false)
+		fmt.Print("\a")
+	}
+}
+
+// open decrypts m in the background, then runs then with its content.
+func (this *inbox) open(m *common.Message, then func(content []byte)) {
+	if content, ok := this.audio[m.MessageID]; ok {
+		then(content)
+		return
+	}
+	if this.busy[m.MessageID] {
+		return
+	}
+	this.busy[m.MessageID] = true
+	this.setStatus("Downloading...", false)
+
+	// the copy is all the background touches
+	msg := *m
+	go func() {
+		last := -1
+		progress := func(done, total int64) {
+			if total <= 0 || int(done*100/total) == last {
+				return
+			}
+			last = int(done * 100 / total)
+			percent := last
+			this.post(func() { this.setStatus(fmt.Sprintf("Downloading... %d%%", percent), false) })
+		}
+		content, sig, err := this.app.decryptMessage(&msg, progress)
+		var warning string
+		if err == nil {
+			warning = this.app.keyWarning(&msg, sig)
+		}
+		this.post(func() {
+			delete(this.busy, m.MessageID)
+			if err != nil && err != api.ErrBadSeal {
+				this.setStatus("Error opening message! "+err.Error(), true)
+				return
+			}
+			*m = msg
+			this.app.saveVerification(m, this.saved)
+			text, bad := verificationText(m, sig)
+			if warning != "" {
+				text, bad = text+" "+warning, true
+			}
+			this.opened[m.MessageID], this.warned[m.MessageID] = text, bad
+			if err != nil {
+				this.setStatus("Error opening message! "+err.Error(), true)
+				return
+			}
+			this.setStatus("", false)
+			this.audio[m.MessageID] = content
+			then(content)
+		})
+	}()
+}
+
+// togglePlay plays m, or pauses or resumes it if it is playing already.
+func (this *inbox) togglePlay(m *common.Message) {
+	if this.playing == m {
+		if this.player.Paused() {
+			this.player.Resume()
+		} else {
+			this.player.Pause()
+		}
+		return
+	}
+	this.stopPlaying()
+	this.open(m, func(content []byte) {
+		this.stopPlaying()
+		player, err := audio.NewPlayer(content, audio.PlayerOptions{
+			Callback: func(time.Duration) {
+				this.post(func() {}) // to draw the position
+			},
+			CallbackInterval: time.Second / 4,
+			Device:           this.app.outputDevice(),
+		})
+		if err != nil {
+			this.setStatus("Error: "+err.Error(), true)
+			return
+		}
+		this.playing, this.player = m, player
+		this.setStatus("", false)
+		go func() {
+			err := player.Play()
+			this.post(func() {
+				if this.player == player {
+					this.playing, this.player = nil, nil
+				}
+				if err != nil {
+					this.setStatus("Error: "+err.Error(), true)
+					return
+				}
+				this.markPlayed(m, true)
+				if this.status == "" {
+					this.setStatus("Press r to reply.", false)
+				}
+			})
+		}()
+	})
+}
+
+func (this *inbox) stopPlaying() {
+	if this.player != nil {
+		this.player.Stop()
+	}
+	this.playing, this.player = nil, nil
+}
+
+// transcribe shows what was said in m, transcribing it in the background
+// unless that was done before.
+func (this *inbox) transcribe(m *common.Message) {
+	if _, ok := this.transcripts[m.MessageID]; ok {
+		return
+	}
+	this.open(m, func(content []byte) {
+		local, err := this.app.store.GetMessage(this.saved[m.MessageID])
+		if err != nil {
+			this.setStatus("Error: "+err.Error(), true)
+			return
+		}
+		this.setStatus("Transcribing...", false)
+		go func() {
+			text, err := this.app.transcript(local, content, this.transcriber)
+			this.post(func() {
+				if err != nil {
+					this.setStatus("Error transcribing message! "+err.Error(), true)
+					return
+				}
+				this.transcripts[m.MessageID] = text
+				this.setStatus("", false)
+			})
+		}()
+	})
+}
+
+// markPlayed records in the local copy of m whether it was played, making
+// that copy if there's none yet.
+func (this *inbox) markPlayed(m *common.Message, played bool) {
+	localID, ok := this.saved[m.MessageID]
+	if !ok {
+		this.app.saveVerification(m, this.saved)
+		if localID, ok = this.saved[m.MessageID]; !ok {
+			this.setStatus("Error: can't keep a copy of the message.", true)
+			return
+		}
+	}
+	if err := this.app.store.UpdateMessagePlayed(localID, played); err != nil {
+		this.setStatus("Error: "+err.Error(), true)
+		return
+	}
+	this.played[m.MessageID] = played
+}
+
+func (this *inbox) delete(m *common.Message) {
+	this.setStatus("Deleting...", false)
+	go func() {
+		err := this.app.client.DeleteMessage(this.ctx, this.app.user, m.MessageID)
+		this.post(func() {
+			if err != nil && err != api.ErrNotFound {
+				this.setStatus("Error deleting message! "+err.Error(), true)
+				return
+			}
+			if this.playing == m {
+				this.stopPlaying()
+			}
+			for i, other := range this.messages {
+				if other == m {
+					this.messages = append(this.messages[:i], this.messages[i+1:]...)
+					break
+				}
+			}
+			if this.selected >= len(this.messages) && this.selected > 0 {
+				this.selected--
+			}
+			this.setStatus("Deleted.", false)
+		})
+	}()
+}
+
+// reply opens m to learn who sent it and where it belongs, then records
+// an answer.
+func (this *inbox) reply(m *common.Message) {
+	this.open(m, func([]byte) {
+		this.stopPlaying()
+		this.setStatus("Checking keys...", false)
+		original := *m
+		go func() {
+			label, recipients, groups, err := this.app.replyTargets(&original)
+			this.post(func() {
+				if err != nil {
+					this.setStatus("Not replying! "+err.Error(), true)
+					return
+				}
+				if this.rec == nil {
+					this.record(label, recipients, groups, &original)
+				}
+			})
+		}()
+	})
+}
+
+// replyTargets is who a reply to m goes to, as reply finds it but without
+// a word on the terminal.
+func (this *App) replyTargets(m *common.Message) (string, []*common.User, []*common.Group, error) {
+	if m.Group != "" {
+		group, err := this.client.GetGroup(context.Background(), this.user, m.Group)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		owner, err := this.store.FindUserByKey(group.Owner)
+		if err != nil {
+			owner = &common.User{Key: group.Owner}
+		}
+		for _, member := range append([]*common.User{owner}, group.Members...) {
+			if member.Key == this.user.Key {
+				continue
+			}
+			if err := this.replyKeyCheck(member); err != nil {
+				return "", nil, nil, err
+			}
+		}
+		return "@" + group.Name, nil, []*common.Group{group}, nil
+	}
+
+	recipient := m.From
+	if recipient == nil {
+		return "", nil, nil, ErrNoSender
+	}
+	if recipient.Key == this.user.Key {
+		recipient = m.To
+	}
+	if err := this.replyKeyCheck(recipient); err != nil {
+		return "", nil, nil, err
+	}
+	return userLabel(recipient), []*common.User{recipient}, nil, nil
+}
+
+// replyKeyCheck is pinKey with an error that says whose key it is about.
+func (this *App) replyKeyCheck(user *common.User) error {
+	_, err := this.pinKey(user)
+	switch err {
+	case ErrNoKeyFound:
+		return fmt.Errorf("no public key %s for %s in your keyring", user.Key, userLabel(user))
+	case ErrKeyChanged:
+		pin, _ := this.store.FindKeyPin(user.Email)
+		return errors.New(keyChangeWarning(pin, user, this.fingerprint(user.Key)))
+	}
+	return err
+}
+
+// record records a reply in the background and sends it once it is done,
+// unless cancelled.
+func (this *inbox) record(label string, recipients []*common.User, groups []*common.Group, original *common.Message) {
+	rec := &inboxRecording{
+		label:      label,
+		recipients: recipients,
+		groups:     groups,
+		original:   original,
+		stop:       make(chan int, 1),
+	}
+	this.rec = rec
+	this.setStatus("", false)
+	go func() {
+		audioContent, err := this.app.recordAudio(rec.stop, func(samples int) {
+			this.post(func() { rec.samples = samples })
+		})
+		this.post(func() {
+			if rec.cancelled || err != nil {
+				this.rec = nil
+				if err != nil {
+					this.setStatus("Error recording message! "+err.Error(), true)
+				} else {
+					this.setStatus("Reply cancelled.", false)
+				}
+				return
+			}
+			rec.sending = true
+			go this.sendReply(rec, audioContent)
+		})
+	}()
+}
+
+func (this *inbox) sendReply(rec *inboxRecording, audioContent []byte) {
+	results := this.app.sendAudio(audioContent, sendOptions{seal: true}, rec.recipients, rec.groups, rec.original, nil)
+	this.post(func() {
+		this.rec = nil
+		for _, r := range results {
+			if r.err != nil {
+				this.setStatus(sendErrorText(r), true)
+				return
+			}
+		}
+		this.setStatus(fmt.Sprintf("Sent to %s.", rec.label), false)
+	})
+}
+
+// sender names who sent m, as far as that is known yet.
+func (this *inbox) sender(m *common.Message) string {
+	if m.Sealed && m.From == nil {
+		return "sealed sender"
+	}
+	return userLabel(m.From)
+}
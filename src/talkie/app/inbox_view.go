@@ -0,0 +1,258 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/nsf/termbox-go"
+	"strings"
+)
+
+const inboxHelp = "↑/↓ select  enter play/pause  x stop  ←/→ 5s  -/+ speed  r reply  t transcribe  d delete  m mark played  s sync  q quit"
+
+const (
+	colorText    = termbox.ColorDefault
+	colorReverse = termbox.ColorDefault | termbox.AttrReverse
+	colorWarning = termbox.ColorRed | termbox.AttrBold
+)
+
+// draw lays out the inbox: a title bar, the messages on the left and the
+// one selected on the right, the keys, and a status bar with the sync state.
+func (this *inbox) draw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	w, h := termbox.Size()
+	if w < 20 || h < 5 {
+		termbox.Flush()
+		return
+	}
+
+	fillLine(0, w, colorReverse)
+	drawText(1, 0, w-2, "talkie - "+userLabel(this.app.user), colorReverse|termbox.AttrBold, termbox.ColorDefault)
+
+	paneWidth := w * 2 / 5
+	if paneWidth < 24 {
+		paneWidth = 24
+	}
+	rows := h - 3
+	this.drawMessages(0, 1, paneWidth, rows)
+	for y := 1; y <= rows; y++ {
+		termbox.SetCell(paneWidth, y, '│', colorText, termbox.ColorDefault)
+	}
+	x := paneWidth + 2
+	if this.rec != nil {
+		this.drawRecording(x, 1, w-x-1)
+	} else if m := this.current(); m != nil {
+		this.drawDetails(m, x, 1, w-x-1, rows)
+	}
+
+	drawText(1, h-2, w-2, inboxHelp, colorText, termbox.ColorDefault)
+	this.drawStatus(h-1, w)
+	termbox.Flush()
+}
+
+func (this *inbox) drawMessages(x, y, width, rows int) {
+	if len(this.messages) == 0 {
+		drawText(x+1, y, width-1, "No messages.", colorText, termbox.ColorDefault)
+		return
+	}
+	if this.selected < this.top {
+		this.top = this.selected
+	}
+	if this.selected >= this.top+rows {
+		this.top = this.selected - rows + 1
+	}
+	for i := this.top; i < len(this.messages) && i < this.top+rows; i++ {
+		m := this.messages[i]
+		mark := " "
+		switch {
+		case this.playing == m:
+			mark = "▶"
+		case !this.played[m.MessageID]:
+			mark = "*"
+		}
+		line := fmt.Sprintf("%s %s  %s", mark, m.CreatedAt.Local().Format("Jan 02 15:04"), this.sender(m))
+		if m.Group != "" {
+			line += " in @" + m.Group
+		}
+		fg := colorText
+		if i == this.selected {
+			fg = colorReverse
+			fillLine(y+i-this.top, width, fg)
+		}
+		drawText(x, y+i-this.top, width, line, fg, termbox.ColorDefault)
+	}
+}
+
+func (this *inbox) drawDetails(m *common.Message, x, y, width, rows int) {
+	type line struct {
+		text string
+		fg   termbox.Attribute
+	}
+	var lines []line
+	add := func(text string, fg termbox.Attribute) {
+		for _, l := range wrap(text, width) {
+			lines = append(lines, line{l, fg})
+		}
+	}
+
+	add("From:    "+this.sender(m), colorText|termbox.AttrBold)
+	if m.Group != "" {
+		add("To:      @"+m.Group, colorText)
+	} else if m.To != nil {
+		add("To:      "+userLabel(m.To), colorText)
+	}
+	add("Sent:    "+m.CreatedAt.Local().Format("Mon Jan 02 2006 15:04"), colorText)
+	if this.playing == m {
+		add(playStatus(this.player), colorText|termbox.AttrBold)
+	}
+	if note := strings.TrimSpace(expiryNote(m)); note != "" {
+		add("Expires: "+strings.Trim(note, "()"), colorText)
+	}
+	if this.played[m.MessageID] {
+		add("Played.", colorText)
+	} else {
+		add("Not played yet.", colorText)
+	}
+	add("", colorText)
+
+	if text, ok := this.opened[m.MessageID]; ok {
+		fg := colorText
+		if this.warned[m.MessageID] {
+			fg = colorWarning
+		}
+		add(text, fg)
+	} else if this.busy[m.MessageID] {
+		add("Opening...", colorText)
+	} else {
+		add("Press enter to listen. Who signed it shows once it is opened.", colorText)
+	}
+
+	if text, ok := this.transcripts[m.MessageID]; ok {
+		add("", colorText)
+		add(strings.TrimSpace(quoteTranscript(text)), colorText)
+	}
+
+	if m.ThreadID != "" {
+		if thread, err := this.app.store.GetThread(m.ThreadID); err == nil && len(thread) > 1 {
+			add(fmt.Sprintf("%d messages in this conversation so far.", len(thread)), colorText)
+		}
+	}
+	if m.InReplyTo != "" {
+		add("This is a reply.", colorText)
+	}
+	if localID, ok := this.saved[m.MessageID]; ok {
+		add("", colorText)
+		add(fmt.Sprintf("Kept as message %d, press r or run `talkie reply %d` to answer it.", localID, localID), colorText)
+	}
+
+	for i, l := range lines {
+		if i >= rows {
+			break
+		}
+		drawText(x, y+i, width, l.text, l.fg, termbox.ColorDefault)
+	}
+}
+
+// drawRecording shows the reply being recorded, and how long it may go on.
+func (this *inbox) drawRecording(x, y, width int) {
+	rec := this.rec
+	drawText(x, y, width, "Replying to "+rec.label, colorText|termbox.AttrBold, termbox.ColorDefault)
+	if rec.sending {
+		drawText(x, y+2, width, "Sending...", colorText, termbox.ColorDefault)
+		return
+	}
+
+	left := this.app.remaining(rec.samples)
+	drawText(x, y+2, width, fmt.Sprintf("● Recording... %.1f seconds left", left.Seconds()), termbox.ColorRed, termbox.ColorDefault)
+	barWidth := width - 2
+	if barWidth > progressBarWidth {
+		barWidth = progressBarWidth
+	}
+	filled := 0
+	if this.app.maxDuration > 0 {
+		filled = int(float64(barWidth) * float64(this.app.maxDuration-left) / float64(this.app.maxDuration))
+	}
+	if filled < 0 {
+		filled = 0
+	}
+	if filled > barWidth {
+		filled = barWidth
+	}
+	bar := "[" + strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled) + "]"
+	drawText(x, y+3, width, bar, colorText, termbox.ColorDefault)
+	drawText(x, y+5, width, "Enter to stop and send, Esc to cancel.", colorText, termbox.ColorDefault)
+}
+
+func (this *inbox) drawStatus(y, w int) {
+	fillLine(y, w, colorReverse)
+
+	var state string
+	switch {
+	case this.syncing:
+		state = "Syncing..."
+	case this.syncErr != nil:
+		state = "Sync failed: " + this.syncErr.Error()
+	case !this.syncedAt.IsZero():
+		state = fmt.Sprintf("%d messages, synced %s", len(this.messages), this.syncedAt.Format("15:04:05"))
+	}
+	if this.live {
+		state += " | live"
+	} else {
+		state += fmt.Sprintf(" | polling every %s", inboxSyncInterval)
+	}
+
+	fg := colorReverse
+	if this.statusErr {
+		fg = colorReverse | termbox.ColorRed
+	}
+	drawText(1, y, w-len(state)-3, this.status, fg, termbox.ColorDefault)
+	drawText(w-len(state)-1, y, len(state), state, colorReverse, termbox.ColorDefault)
+}
+
+// drawText draws text at x, y, cut to width, and returns how much it drew.
+func drawText(x, y, width int, text string, fg, bg termbox.Attribute) int {
+	n := 0
+	for _, r := range text {
+		if n >= width {
+			break
+		}
+		termbox.SetCell(x+n, y, r, fg, bg)
+		n++
+	}
+	return n
+}
+
+// fillLine paints line y, width cells wide, in fg.
+func fillLine(y, width int, fg termbox.Attribute) {
+	for x := 0; x < width; x++ {
+		termbox.SetCell(x, y, ' ', fg, termbox.ColorDefault)
+	}
+}
+
+// wrap breaks text into lines of at most width runes, between words where
+// it can.
+func wrap(text string, width int) []string {
+	if width <= 0 {
+		return nil
+	}
+	var lines []string
+	var line []rune
+	for _, word := range strings.Fields(text) {
+		w := []rune(word)
+		if len(line) > 0 && len(line)+1+len(w) > width {
+			lines = append(lines, string(line))
+			line = nil
+		}
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, w...)
+		for len(line) > width {
+			lines = append(lines, string(line[:width]))
+			line = line[width:]
+		}
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/api"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"os"
+	"time"
+)
+
+// resolveTimeout bounds looking a key up on the server and the web
+const resolveTimeout = 30 * time.Second
+
+// lookupDirectory looks the keys of email up on the talkie server, then in
+// the Web Key Directory of its domain, and imports them.
+func (this *App) lookupDirectory(email string) []*common.PublicKey {
+	chain := api.NewKeyChain(this.engine,
+		&api.ServerResolver{Client: this.client},
+		&api.WKDResolver{},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	keys, err := chain.Resolve(ctx, email)
+	if err != nil && err != api.ErrKeyNotFound {
+		fmt.Printf("Error looking up the key of %s! %s\n", email, err.Error())
+	}
+	return keys
+}
+
+// fingerprint returns the fingerprint of key in the keyring, empty if it
+// isn't there.
+func (this *App) fingerprint(key string) string {
+	keys, _ := this.engine.ListPublicKeys(key)
+	for _, k := range keys {
+		if crypto.KeyMatches(k.Fingerprint, key) {
+			return k.Fingerprint
+		}
+	}
+	return ""
+}
+
+// pinKey pins the key of user on first use, and refuses any other key for
+// the same email afterwards with ErrKeyChanged. first tells whether the key
+// was just pinned.
+func (this *App) pinKey(user *common.User) (first bool, err error) {
+	if user.Email == "" {
+		return false, nil
+	}
+	fingerprint := this.fingerprint(user.Key)
+	if fingerprint == "" {
+		return false, ErrNoKeyFound
+	}
+
+	pin, err := this.store.FindKeyPin(user.Email)
+	if err == common.ErrNoResult {
+		pin = &common.KeyPin{
+			Email:       user.Email,
+			Fingerprint: fingerprint,
+			FirstSeen:   time.Now(),
+		}
+		if err := this.store.SetKeyPin(pin); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if keyChangeWarning(pin, user, fingerprint) != "" {
+		return false, ErrKeyChanged
+	}
+	return false, nil
+}
+
+// checkKeyPin is pinKey telling the user how it went.
+func (this *App) checkKeyPin(user *common.User) bool {
+	first, err := this.pinKey(user)
+	switch err {
+	case nil:
+		if first {
+			fmt.Printf("First message to %s, their key %s is now pinned.\n", userLabel(user), crypto.FormatFingerprint(this.fingerprint(user.Key)))
+			fmt.Printf("Run `talkie contacts verify %s` to make sure it is theirs.\n", user.Email)
+		}
+		return true
+	case ErrNoKeyFound:
+		fmt.Printf("No public key %s in your keyring!\n", user.Key)
+	case ErrKeyChanged:
+		pin, _ := this.store.FindKeyPin(user.Email)
+		warnKeyChange(pin, user, this.fingerprint(user.Key))
+		fmt.Printf("Not sending. If they really have a new key, check it with `talkie contacts verify %s`.\n", user.Email)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+	}
+	return false
+}
+
+// warnKeyChange shouts if fingerprint is not the key pinned for user, and
+// tells whether it did.
+func warnKeyChange(pin *common.KeyPin, user *common.User, fingerprint string) bool {
+	warning := keyChangeWarning(pin, user, fingerprint)
+	if warning == "" {
+		return false
+	}
+	shout(warning)
+	return true
+}
+
+// keyChangeWarning is the warning for fingerprint not being the key pinned
+// for user, empty if it is.
+func keyChangeWarning(pin *common.KeyPin, user *common.User, fingerprint string) string {
+	if pin == nil || crypto.KeyMatches(pin.Fingerprint, fingerprint) {
+		return ""
+	}
+	return fmt.Sprintf("The key of %s has CHANGED! It was %s, it is now %s. Someone may be impersonating them.",
+		userLabel(user), crypto.FormatFingerprint(pin.Fingerprint), crypto.FormatFingerprint(fingerprint))
+}
@@ -1,102 +1,134 @@
 package app
 
 import (
-	"bytes"
-	"code.google.com/p/go-uuid/uuid"
+	"context"
 	"fmt"
 	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/api"
 	"github.com/gophergala/gopher_talkie/src/audio"
-	_ "github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/common"
 	"github.com/gophergala/gopher_talkie/src/crypto"
-	"io"
 	"os"
-	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func NewListCommand(app *App) cli.Command {
 	return cli.Command{
 		Name:  "list",
 		Usage: "list all messages",
+		Flags: append([]cli.Flag{
+			cli.BoolFlag{
+				Name:  "threads",
+				Usage: "list conversations instead, with how many messages are unread",
+			},
+			cli.BoolFlag{
+				Name:  "transcribe",
+				Usage: "show what was said in each message instead of playing them, see `talkie transcribe`",
+			},
+			cli.BoolFlag{
+				Name:  "plain",
+				Usage: "print the messages and ask which one to play, instead of the full-screen inbox",
+			},
+		}, transcribeFlags()...),
 		Action: func(c *cli.Context) {
 			app.list(c)
 		},
 	}
 }
 
+func expiryNote(m *common.Message) string {
+	if m.BurnAfterPlaying {
+		return " (burns after playing)"
+	}
+	if !m.ExpiresAt.IsZero() {
+		return fmt.Sprintf(" (expires in %s)", m.ExpiresAt.Sub(time.Now()).Truncate(time.Minute))
+	}
+	return ""
+}
+
 func (this *App) list(c *cli.Context) {
 	if err := this.setup(c); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
 		return
 	}
 
-	messages, err := this.client.GetMessages(this.user)
+	messages, err := this.client.GetMessages(context.Background(), this.user)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	}
+	if c.Bool("threads") {
+		this.listThreads(messages)
+		return
+	}
+	if c.Bool("transcribe") {
+		this.listTranscripts(messages, this.transcriberOptions(c))
+		return
+	}
+	if !c.Bool("plain") && isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		if err := this.runInbox(messages, err, this.transcriberOptions(c)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		}
+		return
+	}
 
 	if len(messages) > 0 {
 		fmt.Printf("You have new messages:\n\n")
 		for i := range messages {
 			m := messages[i]
-			fmt.Printf("  (%d) %s <%s> - %s\n", i+1, m.From.Name, m.From.Email, m.CreatedAt.Format("Jan 02"))
+			if m.Sealed {
+				fmt.Printf("  (%d) sealed sender - %s%s\n", i+1, m.CreatedAt.Format("Jan 02"), expiryNote(m))
+				continue
+			}
+			var group string
+			if m.Group != "" {
+				group = " in @" + m.Group
+			}
+			fmt.Printf("  (%d) %s <%s>%s - %s%s\n", i+1, m.From.Name, m.From.Email, group, m.CreatedAt.Format("Jan 02"), expiryNote(m))
 		}
 
+		// local IDs of the messages recorded while listening
+		saved := make(map[int64]int64)
 		for {
 			fmt.Printf("Enter number (%d - %d) to listen, or Q)uit > ", 1, len(messages))
 			var choice string
 			fmt.Scanf("%v", &choice)
-			if choice == "Q" {
+			if strings.ToUpper(choice) == "Q" {
 				break
 			}
 
-			idx, _ := strconv.ParseInt(choice, 10, 4)
-			if int(idx) > 0 && int(idx) <= len(messages) {
-				// play message at idx-1
-				m := messages[int(idx)-1]
-
-				// download content
-				if len(m.Content) == 0 {
-					m.Content, err = this.client.DownloadMessage(m.MessageID)
-					if err != nil {
-						fmt.Printf("Error download message! %s\n", err.Error())
-						continue
-					}
-				}
-
-				// decrypt content
-				content, err := crypto.GPGDecrypt(this.user.Key, bytes.NewReader(m.Content))
-				if err != nil {
-					fmt.Printf("Error decrypt message! %s\n", err.Error())
+			idx, _ := strconv.Atoi(choice)
+			if idx > 0 && idx <= len(messages) {
+				m := messages[idx-1]
+				content, sig, err := this.openMessage(m, saved, newProgressBar("Downloading"))
+				if err == api.ErrBadSeal {
+					showVerification(m, sig)
 					continue
 				}
-
-				// save it to a temp file
-				tempfile := path.Join(os.TempDir(), fmt.Sprintf("%s.aiff", uuid.NewUUID().String()))
-				defer func() {
-					os.RemoveAll(tempfile)
-				}()
-				f, err := os.Create(tempfile)
 				if err != nil {
-					fmt.Printf("Error: %s\n", err.Error())
+					fmt.Printf("Error opening message! %s\n", err.Error())
 					continue
 				}
-				defer f.Close()
-
-				_, err = io.Copy(f, bytes.NewBuffer(content))
-				if err != nil {
-					fmt.Printf("Error: %s\n", err.Error())
-					continue
+				if m.Sealed {
+					fmt.Printf("Sealed message from %s, sent %s\n", userLabel(m.From), m.CreatedAt.Local().Format("Jan 02 15:04"))
+				}
+				showVerification(m, sig)
+				if warning := this.keyWarning(m, sig); warning != "" {
+					shout(warning)
 				}
 
-				// play
 				fmt.Printf("Playing...")
-				err = audio.PlayAIFF(tempfile, nil)
-				if err != nil {
+				if err := this.playAudio(content); err != nil {
 					fmt.Printf("Error: %s\n", err.Error())
 					continue
 				}
 				fmt.Println()
+				if localID, ok := saved[m.MessageID]; ok {
+					this.store.UpdateMessagePlayed(localID, true)
+					fmt.Printf("Reply with `talkie reply %d`.\n", localID)
+				}
 			}
 		}
 
@@ -104,3 +136,152 @@ func (this *App) list(c *cli.Context) {
 		fmt.Println("No messages.")
 	}
 }
+
+// openMessage downloads the content of m unless it is there already,
+// decrypts it and checks who signed it. The local copy of m, kept in saved,
+// records how that went.
+func (this *App) openMessage(m *common.Message, saved map[int64]int64, progress api.Progress) ([]byte, *crypto.Signature, error) {
+	content, sig, err := this.decryptMessage(m, progress)
+	if err == nil || err == api.ErrBadSeal {
+		this.saveVerification(m, saved)
+	}
+	return content, sig, err
+}
+
+// decryptMessage is openMessage without touching the local store. Once a
+// downloaded message opens, the server is told so: that sends the receipt
+// and burns it if it asked for that.
+func (this *App) decryptMessage(m *common.Message, progress api.Progress) ([]byte, *crypto.Signature, error) {
+	downloaded := false
+	if len(m.Content) == 0 {
+		content, err := this.client.Download(context.Background(), m.MessageID, progress)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.Content = content
+		downloaded = true
+	}
+	content, sig, err := this.client.Open(this.user, m)
+	if err == nil && downloaded {
+		// a lost receipt is no reason not to play the message
+		this.client.Acknowledge(context.Background(), this.user, m.MessageID)
+	}
+	return content, sig, err
+}
+
+// keyWarning is the warning for a message signed by its sender with
+// another key than the one pinned for them, empty if there is none.
+func (this *App) keyWarning(m *common.Message, sig *crypto.Signature) string {
+	if !m.Verification.Authentic() || m.From == nil || m.From.Email == "" {
+		return ""
+	}
+	pin, _ := this.store.FindKeyPin(m.From.Email)
+	return keyChangeWarning(pin, m.From, sig.Fingerprint)
+}
+
+// playAudio plays AIFF audio to the end.
+func (this *App) playAudio(content []byte) error {
+	player, err := audio.NewPlayer(content, audio.PlayerOptions{Device: this.outputDevice()})
+	if err != nil {
+		return err
+	}
+	return player.Play()
+}
+
+// saveVerification records m and how verifying it went in the local store,
+// saved maps server message IDs to the local ones already recorded.
+func (this *App) saveVerification(m *common.Message, saved map[int64]int64) {
+	localID, ok := saved[m.MessageID]
+	if !ok {
+		// listened to in an earlier run
+		if local, err := this.store.FindMessageByRemoteURL(this.messageURL(m)); err == nil {
+			localID, ok = local.MessageID, true
+			saved[m.MessageID] = localID
+		}
+	}
+	if ok {
+		this.store.UpdateMessageVerification(localID, m.Verification)
+		return
+	}
+	local := *m
+	local.RemoteURL = this.messageURL(m)
+	if local.BurnAfterPlaying {
+		// nothing of it is kept once it's played, not even here
+		local.Content = nil
+	}
+	if err := this.store.AddMessage(&local); err == nil {
+		saved[m.MessageID] = local.MessageID
+	}
+}
+
+// messageURL is where m, a message waiting on the server, can be found.
+func (this *App) messageURL(m *common.Message) string {
+	return this.client.GetURL(fmt.Sprintf("v1/messages/%d", m.MessageID), nil)
+}
+
+// listThreads lists the conversations in the local store, the latest
+// first, counting the messages still waiting on the server as unread.
+func (this *App) listThreads(waiting []*common.Message) {
+	conversations, err := this.store.GetConversations(this.user.Key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	byKey := make(map[string]*common.Conversation)
+	for _, conv := range conversations {
+		byKey[conversationKey(conv.Partner, conv.Group)] = conv
+	}
+
+	// messages listened to have a local copy, which counts already
+	var sealed int
+	for _, m := range waiting {
+		if _, err := this.store.FindMessageByRemoteURL(this.messageURL(m)); err == nil {
+			continue
+		}
+		if m.Group == "" && m.From == nil {
+			sealed++
+			continue
+		}
+		key := conversationKey(m.From, m.Group)
+		conv, ok := byKey[key]
+		if !ok {
+			conv = &common.Conversation{Group: m.Group}
+			if m.Group == "" {
+				conv.Partner = m.From
+			}
+			byKey[key] = conv
+			conversations = append(conversations, conv)
+		}
+		conv.Messages++
+		conv.Unread++
+		if m.CreatedAt.After(conv.LastAt) {
+			conv.LastAt = m.CreatedAt
+		}
+	}
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].LastAt.After(conversations[j].LastAt)
+	})
+
+	if len(conversations) == 0 && sealed == 0 {
+		fmt.Println("No conversations.")
+		return
+	}
+	for _, conv := range conversations {
+		label := "@" + conv.Group
+		if conv.Group == "" {
+			label = userLabel(conv.Partner)
+		}
+		fmt.Printf("  %-40s %3d messages, %3d unread - %s\n", label, conv.Messages, conv.Unread, conv.LastAt.Local().Format("Jan 02 15:04"))
+	}
+	if sealed > 0 {
+		fmt.Printf("  %-40s %3d unread, listen to them to see who sent them\n", "sealed senders", sealed)
+	}
+}
+
+// conversationKey tells apart the conversations with partner, or in group.
+func conversationKey(partner *common.User, group string) string {
+	if group != "" {
+		return "@" + group
+	}
+	return strings.ToUpper(partner.Key)
+}
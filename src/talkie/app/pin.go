@@ -0,0 +1,70 @@
+package app
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/api"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"os"
+	"strings"
+)
+
+func NewPinCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "pin",
+		Usage: "switch to https and pin the certificate of the server",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "fingerprint",
+				Usage: "expected SHA-256 fingerprint, as printed by talkie-server",
+			},
+			cli.StringFlag{
+				Name:  "ca-file",
+				Usage: "also require the certificate to be signed by a CA in this PEM bundle",
+			},
+		},
+		Action: func(c *cli.Context) {
+			this.pin(c)
+		},
+	}
+}
+
+func (this *App) pin(c *cli.Context) {
+	addr := this.serverAddr(c)
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+
+	fingerprint, err := api.FetchCertFingerprint(addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+
+	if expected := c.String("fingerprint"); expected != "" {
+		if common.NormalizeFingerprint(expected) != fingerprint {
+			fmt.Fprintf(os.Stderr, "Error: %s presents certificate %s, not %s\n", addr, fingerprint, expected)
+			return
+		}
+	} else {
+		fmt.Printf("%s presents a certificate with SHA-256 fingerprint\n  %s\n", addr, fingerprint)
+		fmt.Printf("Compare it with the one printed by talkie-server. Pin it? [y/N] > ")
+		var answer string
+		fmt.Scanln(&answer)
+		if strings.ToLower(answer) != "y" {
+			return
+		}
+	}
+
+	if this.config == nil {
+		this.config = &AppConfig{}
+	}
+	this.config.Server = "https://" + addr
+	this.config.PinnedCert = fingerprint
+	this.config.CAFile = c.String("ca-file")
+	if err := this.saveConfig(this.config); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	fmt.Printf("Pinned. talkie now talks to %s over TLS.\n", this.config.Server)
+}
@@ -3,37 +3,205 @@ package app
 import (
 	"fmt"
 	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/api"
+	"github.com/gophergala/gopher_talkie/src/audio"
 	"github.com/gophergala/gopher_talkie/src/common"
+	"golang.org/x/crypto/ssh/terminal"
 	"os"
+	"strconv"
+	"time"
 )
 
+const (
+	playSkip      = 5 * time.Second // how far back or forward a key moves
+	playSpeedStep = 0.25
+)
+
+const playHelp = "space pause/resume  h/l back/forward 5s  -/+ slower/faster  0-9 jump  q stop"
+
 func NewPlayCommand(this *App) cli.Command {
 	return cli.Command{
 		Name:  "play",
-		Usage: "play a message",
+		Usage: "play [id]: play a message you listened to before, the latest one without an id",
+		Flags: []cli.Flag{
+			cli.Float64Flag{
+				Name:  "speed",
+				Value: 1,
+				Usage: "play at this speed, from 0.5 to 2, at the same pitch",
+			},
+		},
 		Action: func(c *cli.Context) {
 			this.play(c)
 		},
 	}
 }
 
-func playMessage(msg *common.Message) {
-	fmt.Printf("Decrypting message...\n")
-	fmt.Printf("Playing...\n")
-}
-
 func (this *App) play(c *cli.Context) {
 	if err := this.setup(c); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s", err.Error())
 		return
 	}
 
-	if len(c.Args()) == 0 {
-		fmt.Printf("Playing the first message...\n")
+	msg, err := this.playTarget(c)
+	if err == common.ErrNoResult {
+		fmt.Printf("No such message! Listen to your messages with `talkie list` first.\n")
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	if len(msg.Content) == 0 {
+		fmt.Printf("The audio of message %d was not kept, listen to it with `talkie list`.\n", msg.MessageID)
+		return
+	}
+
+	content, sig, err := this.client.Open(this.user, msg)
+	if err == api.ErrBadSeal {
+		showVerification(msg, sig)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error opening message! %s\n", err.Error())
+		return
+	}
+	fmt.Printf("Message %d from %s, sent %s\n", msg.MessageID, userLabel(msg.From), msg.CreatedAt.Local().Format("Jan 02 15:04"))
+	showVerification(msg, sig)
+	if warning := this.keyWarning(msg, sig); warning != "" {
+		shout(warning)
+	}
+
+	var player *audio.Player
+	options := audio.PlayerOptions{
+		Speed: c.Float64("speed"),
+		Callback: func(pos time.Duration) {
+			fmt.Printf("\r%s", playStatus(player))
+		},
+		Device: this.outputDevice(),
+	}
+	player, err = audio.NewPlayer(content, options)
+	if err == audio.ErrBadSpeed {
+		fmt.Printf("Bad speed %g! Play from 0.5 to 2 times as fast.\n", options.Speed)
 		return
 	}
-	msgID := c.Args()[0]
-	fmt.Printf("Playing message %s...\n", msgID)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	if err := playWithKeys(player); err != nil {
+		fmt.Printf("\nError: %s\n", err.Error())
+		return
+	}
+	fmt.Println()
+	this.store.UpdateMessagePlayed(msg.MessageID, true)
+}
+
+// playTarget is the message named on the command line, or the latest one
+// to the user.
+func (this *App) playTarget(c *cli.Context) (*common.Message, error) {
+	if len(c.Args()) > 0 {
+		id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+		if err != nil {
+			return nil, common.ErrNoResult
+		}
+		return this.store.GetMessage(id)
+	}
+	messages, err := this.store.GetUserMessages(this.user.Key)
+	if err != nil {
+		return nil, err
+	}
+	var latest int64
+	for _, m := range messages {
+		if m.MessageID > latest {
+			latest = m.MessageID
+		}
+	}
+	if latest == 0 {
+		return nil, common.ErrNoResult
+	}
+	return this.store.GetMessage(latest)
+}
+
+// playWithKeys plays with player, controlled by keys from the terminal
+// unless there is none.
+func playWithKeys(player *audio.Player) error {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return player.Play()
+	}
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return player.Play()
+	}
+	defer terminal.Restore(fd, state)
+
+	fmt.Printf("%s\r\n", playHelp)
+	go func() {
+		buf := make([]byte, 8)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			if !playKey(player, string(buf[:n])) {
+				player.Stop()
+				return
+			}
+		}
+	}()
+	return player.Play()
+}
+
+// playKey does what key asks of player, and tells whether to play on.
+func playKey(player *audio.Player, key string) bool {
+	switch key {
+	case "q", "\x1b", "\x03": // Esc, Ctrl-C
+		return false
+	case " ", "p":
+		if player.Paused() {
+			player.Resume()
+		} else {
+			player.Pause()
+		}
+	case "h", "\x1b[D":
+		player.Skip(-playSkip)
+	case "l", "\x1b[C":
+		player.Skip(playSkip)
+	case "+", "=", "\x1b[A":
+		stepSpeed(player, playSpeedStep)
+	case "-", "\x1b[B":
+		stepSpeed(player, -playSpeedStep)
+	default:
+		if len(key) == 1 && key[0] >= '0' && key[0] <= '9' {
+			player.Seek(player.Duration() * time.Duration(key[0]-'0') / 10)
+		}
+	}
+	return true
+}
+
+// stepSpeed changes the speed of player by step, within what it can do.
+func stepSpeed(player *audio.Player, step float64) {
+	speed := player.Speed() + step
+	if speed < audio.MinSpeed {
+		speed = audio.MinSpeed
+	}
+	if speed > audio.MaxSpeed {
+		speed = audio.MaxSpeed
+	}
+	player.SetSpeed(speed)
+}
+
+// playStatus tells where player is, e.g. "Playing 0:12 / 0:45 1.5x".
+func playStatus(player *audio.Player) string {
+	state := "Playing"
+	if player.Paused() {
+		state = "Paused "
+	}
+	return fmt.Sprintf("%s %s / %s %4gx ", state, clock(player.Position()), clock(player.Duration()), player.Speed())
+}
 
-	// TODO: need implement
+// clock formats d as minutes and seconds.
+func clock(d time.Duration) string {
+	s := int(d / time.Second)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
 }
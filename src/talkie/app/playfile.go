@@ -0,0 +1,73 @@
+package app
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/audio"
+	"time"
+)
+
+func NewPlayFileCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "playfile",
+		Usage: "playfile <file>: play an aiff, wav or opus file, e.g. one made with talkie record",
+		Flags: []cli.Flag{
+			cli.Float64Flag{
+				Name:  "speed",
+				Value: 1,
+				Usage: "play at this speed, from 0.5 to 2, at the same pitch",
+			},
+			cli.StringFlag{
+				Name:  "device",
+				Usage: "the speakers, or part of their name (default: output_device in the config, or the default ones)",
+			},
+		},
+		Action: func(c *cli.Context) {
+			this.playFile(c)
+		},
+	}
+}
+
+func (this *App) playFile(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie playfile <file>\n")
+		return
+	}
+	file := c.Args()[0]
+	device := c.String("device")
+	if device == "" {
+		device = this.outputDevice()
+	}
+
+	var player *audio.Player
+	options := audio.PlayerOptions{
+		Speed: c.Float64("speed"),
+		Callback: func(pos time.Duration) {
+			fmt.Printf("\r%s", playStatus(player))
+		},
+		Device: device,
+	}
+	player, err := audio.OpenPlayer(file, options)
+	switch err {
+	case nil:
+	case audio.ErrBadSpeed:
+		fmt.Printf("Bad speed %g! Play from 0.5 to 2 times as fast.\n", options.Speed)
+		return
+	case audio.ErrUnknownFormat:
+		fmt.Printf("Can't play %s! talkie plays aiff, wav and opus files.\n", file)
+		return
+	default:
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	fmt.Printf("Playing %s, %s long\n", file, clock(player.Duration()))
+	if err := playWithKeys(player); err != nil {
+		if err == audio.ErrNoDevice {
+			fmt.Printf("\nNo device %s!\n", device)
+		} else {
+			fmt.Printf("\nError: %s\n", err.Error())
+		}
+		return
+	}
+	fmt.Println()
+}
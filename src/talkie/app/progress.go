@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/api"
+	"os"
+	"strings"
+)
+
+const progressBarWidth = 30
+
+// newProgressBar draws a progress bar after label on stderr, ending the line
+// once everything is transferred.
+func newProgressBar(label string) api.Progress {
+	last := -1
+	return func(done, total int64) {
+		if total <= 0 {
+			return
+		}
+		percent := int(done * 100 / total)
+		if percent == last {
+			return
+		}
+		last = percent
+
+		filled := progressBarWidth * percent / 100
+		bar := strings.Repeat("=", filled)
+		if filled < progressBarWidth {
+			bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+		}
+		fmt.Fprintf(os.Stderr, "\r%s [%s] %3d%% %s/%s", label, bar, percent, byteSize(done), byteSize(total))
+		if done >= total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+func byteSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
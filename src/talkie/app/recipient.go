@@ -0,0 +1,157 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// a favorite contact or the pinned key wins over an equal match, not
+	// over a better one
+	favoriteBonus = 5
+	pinnedBonus   = 5
+
+	maxShownCandidates = 10
+)
+
+var sourceNames = map[int]string{
+	common.SourceContact:   "contact",
+	common.SourceKnown:     "known",
+	common.SourceKeyring:   "keyring",
+	common.SourceDirectory: "directory",
+}
+
+// resolveRecipient finds who to means. A contact alias or a key ID the
+// store knows is taken as is. Otherwise contacts, users talked to before,
+// the keyring and, for an email, the key directories are searched for
+// partial names, emails or keys. When several match, the user picks one;
+// with yes, or nobody to ask, only a single or unmistakable match is taken.
+func (this *App) resolveRecipient(to string, yes bool) *common.User {
+	if contact, err := this.store.FindContact(to); err == nil {
+		return contact.User()
+	}
+	if user, err := this.store.FindUserByKey(to); err == nil && user != nil {
+		return user
+	}
+
+	candidates := this.recipientCandidates(to)
+	var picked *common.Candidate
+	switch {
+	case len(candidates) == 0:
+		return nil
+	case len(candidates) == 1 || unmistakable(candidates):
+		picked = candidates[0]
+	case yes || !isTerminal(os.Stdin):
+		fmt.Printf("%s matches %d recipients, be more specific:\n", to, len(candidates))
+		printCandidates(candidates)
+		return nil
+	default:
+		if picked = promptCandidate(to, candidates); picked == nil {
+			return nil
+		}
+	}
+
+	if picked.Source >= common.SourceKeyring {
+		this.store.AddUser(picked.User)
+	}
+	return picked.User
+}
+
+// recipientCandidates searches everywhere for to, best match first.
+func (this *App) recipientCandidates(to string) []*common.Candidate {
+	var candidates []*common.Candidate
+	add := func(user *common.User, alias string, source, score int) {
+		candidates = append(candidates, &common.Candidate{
+			User:   user,
+			Alias:  alias,
+			Source: source,
+			Score:  score,
+		})
+	}
+
+	contacts, _ := this.store.SearchContacts(to)
+	for _, c := range contacts {
+		score := common.MatchScore(to, c.Alias, c.Name, c.Email, c.Key)
+		if c.Favorite && score > common.MatchNone {
+			score += favoriteBonus
+		}
+		add(c.User(), c.Alias, common.SourceContact, score)
+	}
+	users, _ := this.store.SearchUsers(to)
+	for _, u := range users {
+		add(u, "", common.SourceKnown, common.MatchScore(to, u.Name, u.Email, u.Key))
+	}
+	keys, _ := this.engine.ListPublicKeys(to)
+	for _, k := range keys {
+		user := &common.User{Key: k.Fingerprint, Name: k.Name, Email: k.Email}
+		add(user, "", common.SourceKeyring, common.MatchScore(to, k.Name, k.Email, k.PublicKey, k.Fingerprint))
+	}
+
+	// ask the directories only for an address nobody here has
+	if strings.Contains(to, "@") && !hasExactMatch(candidates) {
+		for _, k := range this.lookupDirectory(to) {
+			add(k.User(), "", common.SourceDirectory, common.MatchExact)
+		}
+	}
+
+	for _, c := range candidates {
+		if c.Score == common.MatchNone || c.User.Email == "" {
+			continue
+		}
+		if pin, err := this.store.FindKeyPin(c.User.Email); err == nil && crypto.KeyMatches(pin.Fingerprint, c.User.Key) {
+			c.Score += pinnedBonus
+		}
+	}
+	return common.RankCandidates(candidates)
+}
+
+func hasExactMatch(candidates []*common.Candidate) bool {
+	for _, c := range candidates {
+		if c.Score >= common.MatchExact {
+			return true
+		}
+	}
+	return false
+}
+
+// unmistakable tells whether the best of ranked candidates is an exact match
+// that beats all the others.
+func unmistakable(ranked []*common.Candidate) bool {
+	return ranked[0].Score >= common.MatchExact && ranked[0].Score > ranked[1].Score
+}
+
+func printCandidates(candidates []*common.Candidate) {
+	for i, c := range candidates {
+		if i == maxShownCandidates {
+			fmt.Printf("  ...and %d more\n", len(candidates)-i)
+			break
+		}
+		fmt.Printf("  %2d) %-16s %-40s %s (%s)\n", i+1, c.Alias, userLabel(c.User), c.User.Key, sourceNames[c.Source])
+	}
+}
+
+func promptCandidate(to string, candidates []*common.Candidate) *common.Candidate {
+	if len(candidates) > maxShownCandidates {
+		candidates = candidates[:maxShownCandidates]
+	}
+	fmt.Printf("%s matches several recipients:\n", to)
+	printCandidates(candidates)
+	fmt.Printf("Which one? [1-%d, Enter to cancel] > ", len(candidates))
+	var answer string
+	fmt.Scanln(&answer)
+	n, err := strconv.Atoi(answer)
+	if err != nil || n < 1 || n > len(candidates) {
+		return nil
+	}
+	return candidates[n-1]
+}
+
+// isTerminal tells whether f is a terminal someone can answer questions on.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/audio"
+	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"time"
+)
+
+func NewRecordCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "record",
+		Usage: "record -o out.aiff: record to a file, to check your microphone; nothing is sent",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "the file to write, .aiff, .wav or .opus; wav and opus are mono",
+			},
+			cli.DurationFlag{
+				Name:  "max",
+				Value: DefaultMaxDuration,
+				Usage: "stop recording after this long, e.g. 30s",
+			},
+			cli.StringFlag{
+				Name:  "device",
+				Usage: "the microphone, or part of its name (default: input_device in the config, or the default one)",
+			},
+			cli.IntFlag{
+				Name:  "rate",
+				Value: audio.DefaultSampleRate,
+				Usage: "the sample rate",
+			},
+			cli.IntFlag{
+				Name:  "channels",
+				Value: 1,
+				Usage: "how many channels to record",
+			},
+			cli.BoolFlag{
+				Name:  "trim",
+				Usage: "leave out the silence before and after the sound",
+			},
+			cli.Float64Flag{
+				Name:  "silence",
+				Value: audio.DefaultSilenceLevel,
+				Usage: "with --trim, what is quieter than this fraction of full scale is silence",
+			},
+			cli.BoolFlag{
+				Name:  "devices",
+				Usage: "list the devices that can record",
+			},
+		},
+		Action: func(c *cli.Context) {
+			this.recordFile(c)
+		},
+	}
+}
+
+func (this *App) recordFile(c *cli.Context) {
+	if c.Bool("devices") {
+		listInputDevices()
+		return
+	}
+	file := c.String("output")
+	if file == "" {
+		fmt.Printf("Usage: talkie record -o out.aiff [--max 30s]\n")
+		return
+	}
+	format, err := audio.FormatOf(file)
+	if err != nil {
+		fmt.Printf("Can't record to %s! Name a .aiff, .wav or .opus file.\n", file)
+		return
+	}
+	device := c.String("device")
+	if device == "" {
+		device = this.inputDevice()
+	}
+
+	// wav and opus are converted from what is recorded
+	target := file
+	if format != audio.FormatAIFF {
+		target = path.Join(os.TempDir(), fmt.Sprintf("%s.aiff", uuid.NewUUID().String()))
+		defer os.Remove(target)
+	}
+	rate := float64(c.Int("rate"))
+	options := audio.RecordOptions{
+		FilePath:      target,
+		MaxDuration:   c.Duration("max"),
+		SampleRate:    rate,
+		InputChannels: c.Int("channels"),
+		Device:        device,
+		Trim:          c.Bool("trim"),
+		SilenceLevel:  c.Float64("silence"),
+		Callback: func(frames int) {
+			fmt.Printf("\rRecording... %s", clock(time.Duration(float64(frames)/rate*float64(time.Second))))
+		},
+	}
+	fmt.Printf("Recording to %s for at most %s, press any key to stop...\n", file, options.MaxDuration)
+	if err := recordWithKeys(options); err != nil {
+		if err == audio.ErrNoDevice {
+			fmt.Printf("\nNo device %s! Find yours with `talkie record --devices`.\n", device)
+		} else {
+			fmt.Printf("\nError recording! %s\n", err.Error())
+		}
+		return
+	}
+
+	content, err := ioutil.ReadFile(target)
+	if err != nil {
+		fmt.Printf("\nError: %s\n", err.Error())
+		return
+	}
+	samples, rate, err := audio.DecodeAIFF(content)
+	if err != nil {
+		fmt.Printf("\nError: %s\n", err.Error())
+		return
+	}
+	if format != audio.FormatAIFF {
+		encoded, err := audio.Encode(samples, rate, format)
+		if err == nil {
+			err = ioutil.WriteFile(file, encoded, 0600)
+		}
+		if err != nil {
+			fmt.Printf("\nError writing %s! %s\n", file, err.Error())
+			return
+		}
+	}
+
+	duration := time.Duration(float64(len(samples)) / rate * float64(time.Second))
+	peak := peakLevel(samples)
+	level := "silent"
+	if peak > 0 {
+		level = fmt.Sprintf("peaking at %.0f dB", 20*math.Log10(peak))
+	}
+	fmt.Printf("\rRecorded %s to %s, %s.\n", clock(duration), file, level)
+	if len(samples) == 0 || peak < audio.DefaultSilenceLevel {
+		fmt.Printf("It is all silence, is the microphone on? Pick another one with --device, see --devices.\n")
+	}
+	fmt.Printf("Listen to it with `talkie playfile %s`.\n", file)
+}
+
+// recordWithKeys records as options say until any key is pressed, if
+// there is a terminal to press it in.
+func recordWithKeys(options audio.RecordOptions) error {
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		if state, err := terminal.MakeRaw(fd); err == nil {
+			defer terminal.Restore(fd, state)
+			stop := make(chan int, 1)
+			options.StopSignal = stop
+			go func() {
+				buf := make([]byte, 8)
+				os.Stdin.Read(buf)
+				stop <- 1
+			}()
+		}
+	}
+	return audio.RecordAIFF(options)
+}
+
+// listInputDevices shows the devices talkie record --device takes.
+func listInputDevices() {
+	names, err := audio.InputDevices()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	if len(names) == 0 {
+		fmt.Println("No device can record.")
+		return
+	}
+	for _, name := range names {
+		fmt.Printf("  %s\n", name)
+	}
+}
+
+// peakLevel is how loud the loudest of samples is, as a fraction of full
+// scale.
+func peakLevel(samples []int32) float64 {
+	var peak float64
+	for _, s := range samples {
+		if v := math.Abs(float64(s)); v > peak {
+			peak = v
+		}
+	}
+	return peak / math.MaxInt32
+}
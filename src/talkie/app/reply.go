@@ -0,0 +1,72 @@
+package app
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"os"
+	"strconv"
+)
+
+func NewReplyCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "reply",
+		Usage: "reply <id>: record and send a voice message back, in the same conversation",
+		Flags: recordFlags(),
+		Action: func(c *cli.Context) {
+			this.reply(c)
+		},
+	}
+}
+
+func (this *App) reply(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie reply <id>, the id is shown after you listened to a message\n")
+		return
+	}
+	id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+	if err != nil {
+		fmt.Printf("Usage: talkie reply <id>, the id is shown after you listened to a message\n")
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+
+	original, err := this.store.GetMessage(id)
+	if err == common.ErrNoResult {
+		fmt.Printf("No message %d!\n", id)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+
+	// back to the group, the sender, or whoever got a message we sent
+	if original.Group != "" {
+		if group := this.groupTarget(original.Group, c.BoolT("seal")); group != nil {
+			this.record(c, nil, []*common.Group{group}, original)
+		}
+		return
+	}
+	recipient := original.From
+	if recipient == nil {
+		fmt.Printf("Message %d doesn't tell who sent it, listen to it first.\n", id)
+		return
+	}
+	if recipient.Key == this.user.Key {
+		recipient = original.To
+	}
+	if !this.checkKeyPin(recipient) {
+		return
+	}
+	if original.ThreadID != "" {
+		if thread, err := this.store.GetThread(original.ThreadID); err == nil && len(thread) > 1 {
+			fmt.Printf("%d messages in this conversation so far.\n", len(thread))
+		}
+	}
+	fmt.Printf("Replying to %s...\n", userLabel(recipient))
+	this.record(c, []*common.User{recipient}, nil, original)
+}
@@ -1,28 +1,69 @@
 package app
 
 import (
+	"bytes"
 	"code.google.com/p/go-uuid/uuid"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/api"
 	"github.com/gophergala/gopher_talkie/src/audio"
 	"github.com/gophergala/gopher_talkie/src/common"
-	"github.com/gophergala/gopher_talkie/src/crypto"
 	"github.com/nklizhe/gopass"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"time"
 )
 
 func NewSendCommand(this *App) cli.Command {
 	return cli.Command{
 		Name:  "send",
-		Usage: "record and send a voice message",
+		Usage: "record and send a voice message, or send an audio file with --file or --stdin",
+		Flags: append(recordFlags(),
+			cli.BoolFlag{
+				Name:  "yes, y",
+				Usage: "never ask which recipient was meant, fail unless it is clear",
+			},
+			cli.StringFlag{
+				Name:  "file",
+				Usage: "send this aiff, wav or opus file rather than recording",
+			},
+			cli.BoolFlag{
+				Name:  "stdin",
+				Usage: "send the audio piped in rather than recording, never asks which recipient was meant",
+			},
+			cli.BoolTFlag{
+				Name:  "transcode",
+				Usage: "convert --file or --stdin audio to 44.1kHz mono aiff like recordings, --transcode=false to send an aiff file as it is",
+			},
+		),
 		Action: func(c *cli.Context) {
 			this.send(c)
 		},
 	}
 }
 
+// recordFlags are the flags of the commands that record a message.
+func recordFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.DurationFlag{
+			Name:  "ttl",
+			Usage: "delete the message after this long, e.g. 24h",
+		},
+		cli.BoolFlag{
+			Name:  "burn",
+			Usage: "delete the message once the recipient has played it",
+		},
+		cli.BoolTFlag{
+			Name:  "seal",
+			Usage: "hide who sends the message from the server, --seal=false to send it in the open",
+		},
+	}
+}
+
 func (this *App) send(c *cli.Context) {
 	if err := this.setup(c); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s", err.Error())
@@ -33,92 +74,334 @@ func (this *App) send(c *cli.Context) {
 		panic(ErrNoUser)
 	}
 
-	var recipient *common.User
-	if len(c.Args()) == 0 {
-		fmt.Printf("Sending message to yourself...\n")
-		recipient = this.user
-	} else {
-		to := c.Args()[0]
-		recipient, _ = this.store.FindUserByKey(to)
-		if recipient == nil {
-			keys, err := crypto.GPGListPublicKeys(to)
-			if err == nil && len(keys) == 1 {
-				// add to store
-				recipient = &common.User{
-					Name:  keys[0].Name,
-					Email: keys[0].Email,
-					Key:   keys[0].PublicKey,
-				}
-				this.store.AddUser(recipient)
-
-				fmt.Printf("Sending message to %s <%s>...\n", recipient.Name, recipient.Email)
-			}
-		}
+	// read what is piped in before anything asks a question
+	audioContent, err := this.fileAudio(c)
+	if err != nil {
+		fmt.Printf("Error reading audio! %s\n", err.Error())
+		return
 	}
 
-	if recipient == nil {
-		fmt.Printf("No recipient found!\n")
+	recipients, groups := this.sendTargets(c)
+	if recipients == nil && groups == nil {
 		return
 	}
+	if audioContent == nil {
+		this.record(c, recipients, groups, nil)
+		return
+	}
+	fmt.Printf("Encrypting message...\n")
+	this.deliver(c, audioContent, recipients, groups, nil)
+}
+
+// fileAudio is the audio to send from --file or --stdin, nil to record it.
+// Unless --transcode=false it is converted to the format of recordings.
+func (this *App) fileAudio(c *cli.Context) ([]byte, error) {
+	var content []byte
+	var err error
+	switch {
+	case c.String("file") != "" && c.Bool("stdin"):
+		return nil, ErrTwoSources
+	case c.String("file") != "":
+		content, err = ioutil.ReadFile(c.String("file"))
+	case c.Bool("stdin"):
+		content, err = ioutil.ReadAll(os.Stdin)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.BoolT("transcode") {
+		return audio.Canonical(content)
+	}
+	// sent as it is, it must play everywhere
+	if audio.Sniff(content) != audio.FormatAIFF {
+		return nil, ErrNotAIFF
+	}
+	if samples, _, err := audio.DecodeAIFF(content); err != nil {
+		return nil, err
+	} else if len(samples) == 0 {
+		return nil, audio.ErrNoAudio
+	}
+	return content, nil
+}
+
+// sendOptions are what the flags of the commands that record a message
+// ask for.
+type sendOptions struct {
+	ttl  time.Duration
+	burn bool
+	seal bool
+}
 
+func newSendOptions(c *cli.Context) sendOptions {
+	return sendOptions{
+		ttl:  c.Duration("ttl"),
+		burn: c.Bool("burn"),
+		seal: c.BoolT("seal"),
+	}
+}
+
+// sendResult tells how sending a message to a recipient or a group went.
+type sendResult struct {
+	label     string
+	msg       *common.Message
+	encrypted bool // false if it failed before the upload
+	err       error
+}
+
+// record records a message and sends it to recipients and groups, as a
+// reply to original unless that is nil.
+func (this *App) record(c *cli.Context, recipients []*common.User, groups []*common.Group, original *common.Message) {
 	fmt.Printf("Press any key to start recording...\n")
 	gopass.GetCh()
 
-	// create a temp file
-	fileName := path.Join(os.TempDir(), fmt.Sprintf("%s.aiff", uuid.NewUUID().String()))
+	cb := func(samples int) {
+		fmt.Printf("\rRecording...%.1f seconds left", this.remaining(samples).Seconds())
+	}
+	fmt.Printf("\rRecording...%.1f seconds left", this.maxDuration.Seconds())
+	audioContent, err := this.recordAudio(nil, cb)
+	if err != nil {
+		fmt.Printf("Error recording message! %s", err.Error())
+		return
+	}
 
-	// create a signal
-	sig := make(chan int)
+	fmt.Printf("\rRecorded.\nEncrypting message...\n")
+	this.deliver(c, audioContent, recipients, groups, original)
+}
 
-	// create a callback func
-	cb := func(samples int) {
-		maxSamples := int(float64(this.maxDuration/time.Second) * audio.DefaultSampleRate)
-		remain := time.Duration(float64(maxSamples-samples)/audio.DefaultSampleRate) * time.Second
-		fmt.Printf("\rRecording...%.1f seconds left", remain.Seconds())
+// deliver sends audioContent to recipients and groups, as a reply to
+// original unless that is nil, and tells how it went.
+func (this *App) deliver(c *cli.Context, audioContent []byte, recipients []*common.User, groups []*common.Group, original *common.Message) {
+	progress := func(label string) api.Progress {
+		return newProgressBar("Sending to " + label)
 	}
+	for _, r := range this.sendAudio(audioContent, newSendOptions(c), recipients, groups, original, progress) {
+		if r.err == nil {
+			fmt.Printf("Sent to %s.\n", r.label)
+		} else {
+			fmt.Println(sendErrorText(r))
+		}
+	}
+}
+
+// remaining is how much longer a recording of samples can go on.
+func (this *App) remaining(samples int) time.Duration {
+	maxSamples := int(float64(this.maxDuration/time.Second) * audio.DefaultSampleRate)
+	return time.Duration(float64(maxSamples-samples)/audio.DefaultSampleRate) * time.Second
+}
+
+// recordAudio records at most maxDuration of audio, or until stop, and
+// returns it as AIFF. progress, if not nil, is called as it goes on with the
+// number of samples recorded.
+func (this *App) recordAudio(stop chan int, progress func(samples int)) ([]byte, error) {
+	fileName := path.Join(os.TempDir(), fmt.Sprintf("%s.aiff", uuid.NewUUID().String()))
+	defer os.Remove(fileName)
 
-	// record
-	fmt.Printf("\rRecording...%.1f seconds left", this.maxDuration.Seconds())
 	options := audio.RecordOptions{
 		FilePath:    fileName,
 		MaxDuration: this.maxDuration,
-		StopSignal:  sig,
-		Callback:    cb,
+		StopSignal:  stop,
+		Callback:    progress,
+		Device:      this.inputDevice(),
 	}
-
 	if err := audio.RecordAIFF(options); err != nil {
-		fmt.Printf("Error recording message! %s", err.Error())
-		return
+		return nil, err
 	}
+	return ioutil.ReadFile(fileName)
+}
 
-	// encrypt message
-	rd, err := os.Open(fileName)
-	if err != nil {
-		fmt.Printf("Error: %s", err.Error())
-		return
+// sendAudio encrypts audioContent for recipients and groups and uploads
+// it, as a reply to original unless that is nil. progress, if not nil,
+// gives the progress of each upload.
+func (this *App) sendAudio(audioContent []byte, options sendOptions, recipients []*common.User, groups []*common.Group, original *common.Message, progress func(label string) api.Progress) []*sendResult {
+	var expiresAt time.Time
+	createdAt := time.Now()
+	if options.ttl > 0 {
+		expiresAt = createdAt.Add(options.ttl)
+	}
+	// every copy is the same message, in the thread of the original
+	id := uuid.NewRandom().String()
+	threadID, inReplyTo := id, ""
+	if original != nil {
+		inReplyTo = original.UUID
+		if original.ThreadID != "" {
+			threadID = original.ThreadID
+		}
 	}
-	defer rd.Close()
+	newMessage := func() *common.Message {
+		return &common.Message{
+			From:             this.user,
+			CreatedAt:        createdAt,
+			Content:          audioContent,
+			ExpiresAt:        expiresAt,
+			BurnAfterPlaying: options.burn,
+			UUID:             id,
+			ThreadID:         threadID,
+			InReplyTo:        inReplyTo,
+		}
+	}
+	payload, err := json.Marshal(common.NewPayload(newMessage()))
 
-	fmt.Printf("\rRecorded.\nEncrypting message...\n")
-	content, err := crypto.GPGEncrypt(this.user.Key, recipient.Key, rd)
-	if err != nil {
-		fmt.Printf("Error encrypting message! %s", err.Error())
-		return
+	// one encrypted copy for everyone, unless each gets a sealed one
+	var shared []byte
+	if err == nil && !options.seal && len(recipients) > 0 {
+		keys := make([]string, len(recipients))
+		for i, r := range recipients {
+			keys[i] = r.Key
+		}
+		shared, err = this.engine.EncryptTo(this.user.Key, keys, bytes.NewReader(payload))
 	}
 
-	msg := &common.Message{
-		From:      this.user,
-		To:        recipient,
-		CreatedAt: time.Now(),
-		Content:   content,
+	var results []*sendResult
+	for _, recipient := range recipients {
+		msg := newMessage()
+		msg.To = recipient
+		r := &sendResult{label: userLabel(recipient), msg: msg, err: err}
+		results = append(results, r)
+		if err != nil {
+			continue
+		}
+		// what goes to the server
+		outgoing := msg
+		if shared != nil {
+			msg.Content = shared
+		} else if outgoing, r.err = this.client.Seal(msg); r.err == nil {
+			msg.Content = outgoing.Content
+			msg.Sealed = true
+		} else {
+			continue
+		}
+		r.encrypted = true
+		r.err = this.upload(msg, outgoing, progress, r.label)
+	}
+
+	for _, group := range groups {
+		msg := newMessage()
+		msg.Group = group.Name
+		msg.To = &common.User{Name: "@" + group.Name}
+		r := &sendResult{label: "@" + group.Name, msg: msg, err: err}
+		results = append(results, r)
+		if err != nil {
+			continue
+		}
+		keys := []string{group.Owner}
+		for _, m := range group.Members {
+			keys = append(keys, m.Key)
+		}
+		if msg.Content, r.err = this.engine.EncryptTo(this.user.Key, keys, bytes.NewReader(payload)); r.err != nil {
+			continue
+		}
+		r.encrypted = true
+		// the server knows the members, the local copy shows the group
+		outgoing := *msg
+		outgoing.To = nil
+		r.err = this.upload(msg, &outgoing, progress, r.label)
 	}
+	return results
+}
+
+// upload records msg locally and sends outgoing, which is msg or its sealed
+// form, to the server.
+func (this *App) upload(msg, outgoing *common.Message, progress func(label string) api.Progress, label string) error {
 	this.store.AddMessage(msg) // Store message before send
 
-	fmt.Printf("Sending...\n")
+	var p api.Progress
+	if progress != nil {
+		p = progress(label)
+	}
+	return this.client.Upload(context.Background(), outgoing, p)
+}
+
+// sendErrorText explains why sending failed.
+func sendErrorText(r *sendResult) string {
+	if !r.encrypted {
+		return fmt.Sprintf("Error encrypting message to %s! %s", r.label, r.err.Error())
+	}
+	switch r.err {
+	case api.ErrMessageTooLarge:
+		return "Error sending message! The server does not accept messages this large, try a shorter recording."
+	case api.ErrSenderBlocked:
+		text := fmt.Sprintf("Error sending message! %s does not accept messages from you.", r.label)
+		if r.msg.Sealed {
+			text += "\nIf they don't take sealed messages, send it with --seal=false."
+		}
+		return text
+	case api.ErrMailboxFull, api.ErrQuotaExceeded:
+		return fmt.Sprintf("Error sending message! %s has too many messages waiting, ask them to listen to some first.", r.label)
+	case api.ErrUnauthorized:
+		if r.msg.Group != "" {
+			return fmt.Sprintf("Error sending message! You are not in %s.", r.label)
+		}
+	}
+	return fmt.Sprintf("Error sending message to %s! %s\n...will retry later.", r.label, r.err.Error())
+}
 
-	err = this.client.Send(msg)
+// sendTargets resolves the arguments of send: recipients, and groups
+// written @name. Every key must check out against its pin, or nothing is
+// sent. Both are nil if there's nobody to send to.
+func (this *App) sendTargets(c *cli.Context) ([]*common.User, []*common.Group) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Sending message to yourself...\n")
+		return []*common.User{this.user}, nil
+	}
+
+	var recipients []*common.User
+	var groups []*common.Group
+	seen := make(map[string]bool)
+	for _, to := range c.Args() {
+		if strings.HasPrefix(to, "@") {
+			group := this.groupTarget(groupName(to), c.BoolT("seal"))
+			if group == nil {
+				return nil, nil
+			}
+			groups = append(groups, group)
+			continue
+		}
+
+		recipient := this.resolveRecipient(to, c.Bool("yes") || c.Bool("stdin"))
+		if recipient == nil {
+			fmt.Printf("No recipient found for %s!\n", to)
+			return nil, nil
+		}
+		if !this.checkKeyPin(recipient) {
+			return nil, nil
+		}
+		if !seen[recipient.Key] {
+			seen[recipient.Key] = true
+			recipients = append(recipients, recipient)
+			fmt.Printf("Sending message to %s...\n", userLabel(recipient))
+		}
+	}
+	return recipients, groups
+}
+
+// groupTarget fetches the group called name to send to it, nil unless the
+// keys of everyone in it check out against their pins.
+func (this *App) groupTarget(name string, seal bool) *common.Group {
+	group := this.findGroup(name)
+	if group == nil {
+		return nil
+	}
+	owner, err := this.store.FindUserByKey(group.Owner)
 	if err != nil {
-		fmt.Printf("Error sending message! %s\n...will retry later.\n", err.Error())
+		owner = &common.User{Key: group.Owner}
+	}
+	for _, m := range append([]*common.User{owner}, group.Members...) {
+		if m.Key == this.user.Key {
+			continue
+		}
+		// members are known by key, their email may find it
+		if this.fingerprint(m.Key) == "" && m.Email != "" {
+			this.lookupDirectory(m.Email)
+		}
+		if !this.checkKeyPin(m) {
+			return nil
+		}
+	}
+	if seal {
+		fmt.Printf("Messages to @%s are not sealed, the server checks that you are in the group.\n", name)
 	}
-	fmt.Println("Done")
+	fmt.Printf("Sending message to @%s (%d members)...\n", name, len(group.Members))
+	return group
 }
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/api"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/transcribe"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func NewTranscribeCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "transcribe",
+		Usage: "transcribe <id>: show what was said in a message you listened to before",
+		Flags: transcribeFlags(),
+		Action: func(c *cli.Context) {
+			this.transcribe(c)
+		},
+	}
+}
+
+// transcribeFlags choose the transcription engine, instead of the
+// transcriber in the config.
+func transcribeFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "engine",
+			Usage: "transcribe with whisper, a program like whisper.cpp's whisper-cli, or http, a transcription server",
+		},
+		cli.StringFlag{
+			Name:  "whisper-command",
+			Usage: "the whisper program to run (default whisper-cli)",
+		},
+		cli.StringFlag{
+			Name:  "model",
+			Usage: "the model file for whisper, or the model name for http",
+		},
+		cli.StringFlag{
+			Name:  "transcribe-url",
+			Usage: "where the transcription server takes audio, e.g. http://localhost:8080/inference",
+		},
+		cli.StringFlag{
+			Name:  "language",
+			Usage: "the language spoken, e.g. en (default: detected)",
+		},
+	}
+}
+
+// transcriberOptions are the transcriber in the config, changed by the
+// flags given.
+func (this *App) transcriberOptions(c *cli.Context) transcribe.Options {
+	var options transcribe.Options
+	if this.config != nil && this.config.Transcriber != nil {
+		options = *this.config.Transcriber
+	}
+	for _, f := range []struct {
+		flag  string
+		value *string
+	}{
+		{"engine", &options.Engine},
+		{"whisper-command", &options.Command},
+		{"model", &options.Model},
+		{"transcribe-url", &options.URL},
+		{"language", &options.Language},
+	} {
+		if v := c.String(f.flag); v != "" {
+			*f.value = v
+		}
+	}
+	return options
+}
+
+// newTranscriber is the transcriber options ask for, explaining how to
+// set one up if they don't.
+func newTranscriber(options transcribe.Options) (transcribe.Transcriber, error) {
+	if options.Engine == "" {
+		return nil, ErrNoTranscriber
+	}
+	return transcribe.New(&options)
+}
+
+func (this *App) transcribe(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Usage: talkie transcribe <id>, the id is shown after you listened to a message\n")
+		return
+	}
+	id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+	if err != nil {
+		fmt.Printf("Usage: talkie transcribe <id>, the id is shown after you listened to a message\n")
+		return
+	}
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+
+	msg, err := this.store.GetMessage(id)
+	if err == common.ErrNoResult {
+		fmt.Printf("No message %d!\n", id)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+	text, err := this.transcript(msg, nil, this.transcriberOptions(c))
+	if err != nil {
+		fmt.Printf("Error transcribing message %d! %s\n", id, err.Error())
+		return
+	}
+	fmt.Printf("Message %d from %s, sent %s:\n", msg.MessageID, userLabel(msg.From), msg.CreatedAt.Local().Format("Jan 02 15:04"))
+	fmt.Println(quoteTranscript(text))
+}
+
+// transcript is what was said in msg, a local copy whose audio is content,
+// or nil to decrypt it: the transcript kept with it, or a new one which is
+// then kept, encrypted to the user.
+func (this *App) transcript(msg *common.Message, content []byte, options transcribe.Options) (string, error) {
+	if text, ok := this.cachedTranscript(msg); ok {
+		return text, nil
+	}
+	tr, err := newTranscriber(options)
+	if err != nil {
+		return "", err
+	}
+	if content == nil {
+		if len(msg.Content) == 0 {
+			return "", ErrNoAudio
+		}
+		if content, _, err = this.client.Open(this.user, msg); err != nil && err != api.ErrBadSeal {
+			return "", err
+		}
+	}
+	text, err := tr.Transcribe(context.Background(), content)
+	if err != nil {
+		return "", err
+	}
+	this.saveTranscript(msg.MessageID, text)
+	return text, nil
+}
+
+// cachedTranscript is the transcript kept with msg, if there is one.
+func (this *App) cachedTranscript(msg *common.Message) (string, bool) {
+	if len(msg.Transcript) == 0 {
+		return "", false
+	}
+	text, err := this.engine.Decrypt(this.user.Key, bytes.NewReader(msg.Transcript))
+	if err != nil {
+		return "", false
+	}
+	return string(text), true
+}
+
+// saveTranscript keeps text with the local message id, encrypted to the
+// user like the audio it comes from.
+func (this *App) saveTranscript(id int64, text string) error {
+	encrypted, err := this.engine.Encrypt(this.user.Key, this.user.Key, strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+	return this.store.UpdateMessageTranscript(id, encrypted)
+}
+
+// quoteTranscript indents text under the message it was said in.
+func quoteTranscript(text string) string {
+	if text == "" {
+		return "    (nothing said)"
+	}
+	return "    \"" + text + "\""
+}
+
+// listTranscripts shows what was said in the messages waiting, rather than
+// playing them.
+func (this *App) listTranscripts(messages []*common.Message, options transcribe.Options) {
+	if len(messages) == 0 {
+		fmt.Println("No messages.")
+		return
+	}
+	if _, err := newTranscriber(options); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	saved := make(map[int64]int64)
+	for i, m := range messages {
+		content, sig, err := this.openMessage(m, saved, nil)
+		fmt.Printf("  (%d) %s - %s%s\n", i+1, userLabel(m.From), m.CreatedAt.Local().Format("Jan 02 15:04"), expiryNote(m))
+		if err != nil {
+			fmt.Printf("    Error opening message! %s\n", err.Error())
+			continue
+		}
+		if text, warning := verificationText(m, sig); warning {
+			fmt.Printf("    WARNING: %s\n", text)
+		}
+		local, err := this.store.GetMessage(saved[m.MessageID])
+		if err != nil {
+			fmt.Printf("    Error: %s\n", err.Error())
+			continue
+		}
+		text, err := this.transcript(local, content, options)
+		if err != nil {
+			fmt.Printf("    Error transcribing message! %s\n", err.Error())
+			continue
+		}
+		fmt.Println(quoteTranscript(text))
+		fmt.Printf("    Reply with `talkie reply %d`.\n", local.MessageID)
+	}
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"github.com/gophergala/gopher_talkie/src/crypto"
+	"os"
+	"strings"
+)
+
+// showVerification tells who signed m. Anything short of a good signature
+// by the sender m names is shouted on stderr.
+func showVerification(m *common.Message, sig *crypto.Signature) {
+	text, warning := verificationText(m, sig)
+	if warning {
+		shout(text)
+		return
+	}
+	fmt.Println(text)
+}
+
+// verificationText tells who signed m, and whether that is worth a warning.
+func verificationText(m *common.Message, sig *crypto.Signature) (string, bool) {
+	var fingerprint string
+	if sig != nil {
+		fingerprint = sig.Fingerprint
+	}
+
+	switch m.Verification {
+	case common.VerifyTrusted:
+		return fmt.Sprintf("Signed by %s, verified.", userLabel(m.From)), false
+	case common.VerifyUntrusted:
+		return fmt.Sprintf("Signed by %s, but you have not certified their key %s (trust: %s).", userLabel(m.From), fingerprint, sig.Trust), false
+	case common.VerifyMismatch:
+		return fmt.Sprintf("This message claims to be from %s but was signed by key %s. It may be forged!", userLabel(m.From), fingerprint), true
+	case common.VerifyBadSignature:
+		return fmt.Sprintf("This message has a BAD signature (%s). It may have been tampered with!", sig.Status), true
+	case common.VerifyExpiredKey:
+		return fmt.Sprintf("This message is signed by %s with key %s, which has EXPIRED. Someone else may have it now!", userLabel(m.From), fingerprint), true
+	case common.VerifyUnknownKey:
+		return fmt.Sprintf("This message is signed with key %s, which is not in your keyring. Its sender can't be verified!", fingerprint), true
+	}
+	return "This message is NOT signed. Anyone could have sent it!", true
+}
+
+// shout rings the bell and puts warning in a banner on stderr.
+func shout(warning string) {
+	banner := strings.Repeat("!", 72)
+	fmt.Fprintf(os.Stderr, "\a%s\n  WARNING: %s\n%s\n", banner, warning, banner)
+}
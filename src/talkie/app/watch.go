@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/gophergala/gopher_talkie/src/common"
+	"os"
+	"time"
+)
+
+// wait this long before subscribing again after the stream broke
+const watchRetryDelay = 10 * time.Second
+
+func NewWatchCommand(this *App) cli.Command {
+	return cli.Command{
+		Name:  "watch",
+		Usage: "wait for new messages and tell when they arrive",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "quiet",
+				Usage: "don't ring the terminal bell",
+			},
+		},
+		Action: func(c *cli.Context) {
+			this.watch(c)
+		},
+	}
+}
+
+func (this *App) watch(c *cli.Context) {
+	if err := this.setup(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return
+	}
+
+	fmt.Printf("Watching for messages to %s <%s>, press Ctrl-C to stop.\n", this.user.Name, this.user.Email)
+	for {
+		events, err := this.client.Subscribe(context.Background(), this.user)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		} else {
+			for ev := range events {
+				this.notify(ev, !c.Bool("quiet"))
+			}
+		}
+		time.Sleep(watchRetryDelay)
+	}
+}
+
+func (this *App) notify(ev *common.Event, bell bool) {
+	m := ev.Message
+	if m == nil {
+		return
+	}
+	when := ev.CreatedAt.Local().Format("15:04")
+	switch ev.Type {
+	case common.EventMessage:
+		if bell {
+			fmt.Print("\a")
+		}
+		if m.Sealed {
+			fmt.Printf("[%s] New sealed message%s, run `talkie list` to listen\n", when, expiryNote(m))
+			break
+		}
+		fmt.Printf("[%s] New message from %s%s, run `talkie list` to listen\n", when, userLabel(m.From), expiryNote(m))
+	case common.EventReceipt:
+		fmt.Printf("[%s] %s downloaded your message of %s\n", when, userLabel(m.To), m.CreatedAt.Local().Format("Jan 02 15:04"))
+	}
+}
+
+func userLabel(u *common.User) string {
+	switch {
+	case u == nil:
+		return "someone"
+	case u.Name != "" && u.Email != "":
+		return fmt.Sprintf("%s <%s>", u.Name, u.Email)
+	case u.Name != "":
+		return u.Name
+	case u.Email != "":
+		return u.Email
+	}
+	return u.Key
+}
@@ -0,0 +1,82 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// HTTP posts the audio to a transcription server: an OpenAI-style
+// /v1/audio/transcriptions endpoint, or the /inference one of the
+// whisper.cpp server, which both take the same form.
+type HTTP struct {
+	URL      string
+	Model    string
+	Language string
+	APIKey   string
+	Client   *http.Client // default: http.DefaultClient
+}
+
+func (h *HTTP) Transcribe(ctx context.Context, content []byte) (string, error) {
+	wav, err := speechWAV(content)
+	if err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return "", err
+	}
+	part.Write(wav)
+	form.WriteField("response_format", "json")
+	if h.Model != "" {
+		form.WriteField("model", h.Model)
+	}
+	if h.Language != "" {
+		form.WriteField("language", h.Language)
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", h.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	d, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return "", fmt.Errorf("transcription server: %s: %s", res.Status, strings.TrimSpace(string(d)))
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(d, &result); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Text), nil
+}
@@ -0,0 +1,77 @@
+// Package transcribe turns the speech in voice messages into text, with an
+// engine running on the user's machine or one they point it at. talkie
+// never sends audio or text to the talkie server for this.
+package transcribe
+
+import (
+	"context"
+	"errors"
+	"github.com/gophergala/gopher_talkie/src/audio"
+)
+
+const (
+	EngineWhisper = "whisper"
+	EngineHTTP    = "http"
+
+	// speech engines work on 16kHz audio
+	speechSampleRate = 16000
+)
+
+var (
+	ErrUnknownEngine = errors.New("unknown transcription engine, use whisper or http")
+	ErrNoModel       = errors.New("the whisper engine needs a model")
+	ErrNoURL         = errors.New("the http engine needs a url")
+)
+
+// Transcriber turns the speech in content, an AIFF file as talkie records
+// them, into text.
+type Transcriber interface {
+	Transcribe(ctx context.Context, content []byte) (string, error)
+}
+
+// Options choose and set up a Transcriber. They are kept in the talkie
+// config as "transcriber".
+type Options struct {
+	Engine   string `json:"engine,omitempty"`   // whisper or http
+	Command  string `json:"command,omitempty"`  // whisper: the program to run, default whisper-cli
+	Model    string `json:"model,omitempty"`    // whisper: path of the model file; http: model name
+	URL      string `json:"url,omitempty"`      // http: where to post the audio
+	Language string `json:"language,omitempty"` // e.g. en, default: detected
+	APIKey   string `json:"api_key,omitempty"`  // http: sent as a bearer token
+}
+
+// New makes the Transcriber options ask for.
+func New(options *Options) (Transcriber, error) {
+	switch options.Engine {
+	case EngineWhisper:
+		if options.Model == "" {
+			return nil, ErrNoModel
+		}
+		return &Whisper{
+			Command:  options.Command,
+			Model:    options.Model,
+			Language: options.Language,
+		}, nil
+	case EngineHTTP:
+		if options.URL == "" {
+			return nil, ErrNoURL
+		}
+		return &HTTP{
+			URL:      options.URL,
+			Model:    options.Model,
+			Language: options.Language,
+			APIKey:   options.APIKey,
+		}, nil
+	}
+	return nil, ErrUnknownEngine
+}
+
+// speechWAV converts content, AIFF, to the 16kHz 16-bit WAV speech engines
+// take.
+func speechWAV(content []byte) ([]byte, error) {
+	samples, rate, err := audio.DecodeAIFF(content)
+	if err != nil {
+		return nil, err
+	}
+	return audio.EncodeWAV(audio.Resample(samples, rate, speechSampleRate), speechSampleRate), nil
+}
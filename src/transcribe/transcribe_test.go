@@ -0,0 +1,104 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/gophergala/gopher_talkie/src/audio"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+// testAIFF is a second of silence, as talkie records it.
+func testAIFF() []byte {
+	return audio.EncodeAIFF(make([]int32, audio.DefaultSampleRate), audio.DefaultSampleRate)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&Options{})
+	assert.Equal(t, ErrUnknownEngine, err)
+	_, err = New(&Options{Engine: EngineWhisper})
+	assert.Equal(t, ErrNoModel, err)
+	_, err = New(&Options{Engine: EngineHTTP})
+	assert.Equal(t, ErrNoURL, err)
+
+	tr, err := New(&Options{Engine: EngineWhisper, Model: "ggml-base.en.bin"})
+	assert.Nil(t, err)
+	assert.Equal(t, "ggml-base.en.bin", tr.(*Whisper).Model)
+}
+
+func TestSpeechWAV(t *testing.T) {
+	wav, err := speechWAV(testAIFF())
+	assert.Nil(t, err)
+	assert.Equal(t, "RIFF", string(wav[0:4]))
+	assert.Equal(t, uint32(speechSampleRate), binary.LittleEndian.Uint32(wav[24:]))
+	assert.Equal(t, 44+2*speechSampleRate, len(wav))
+
+	_, err = speechWAV([]byte("not audio"))
+	assert.NotNil(t, err)
+}
+
+func TestWhisper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "talkie-whisper")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// a stand-in that says what it was asked, and checks it got a WAV file
+	command := path.Join(dir, "whisper-cli")
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-f) head -c 4 "$2" | grep -q RIFF || { echo "not a wav file" >&2; exit 1; }; shift ;;
+	-m) echo "  model $2"; shift ;;
+	-l) echo "language $2"; shift ;;
+	esac
+	shift
+done
+echo " hello   world "
+`
+	assert.Nil(t, ioutil.WriteFile(command, []byte(script), 0755))
+
+	w := &Whisper{Command: command, Model: "base.bin", Language: "en"}
+	text, err := w.Transcribe(context.Background(), testAIFF())
+	assert.Nil(t, err)
+	assert.Equal(t, "model base.bin language en hello world", text)
+
+	w.Command = path.Join(dir, "missing")
+	_, err = w.Transcribe(context.Background(), testAIFF())
+	assert.NotNil(t, err)
+
+	_, err = w.Transcribe(context.Background(), []byte("not audio"))
+	assert.Equal(t, audio.ErrBadFileFormat, err)
+}
+
+func TestHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "who are you", http.StatusUnauthorized)
+			return
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wav, _ := ioutil.ReadAll(f)
+		fmt.Fprintf(w, `{"text": " %s %s %s %d "}`, r.FormValue("model"), r.FormValue("language"), r.FormValue("response_format"), len(wav))
+	}))
+	defer ts.Close()
+
+	h := &HTTP{URL: ts.URL, Model: "whisper-1", Language: "de", APIKey: "secret"}
+	text, err := h.Transcribe(context.Background(), testAIFF())
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("whisper-1 de json %d", 44+2*speechSampleRate), text)
+
+	h.APIKey = ""
+	_, err = h.Transcribe(context.Background(), testAIFF())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
@@ -0,0 +1,69 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const DefaultWhisperCommand = "whisper-cli"
+
+// Whisper runs a whisper.cpp style program on the user's machine, which
+// takes a WAV file with -f and prints what was said.
+type Whisper struct {
+	Command  string // default: whisper-cli
+	Model    string
+	Language string
+}
+
+func (w *Whisper) Transcribe(ctx context.Context, content []byte) (string, error) {
+	wav, err := speechWAV(content)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "talkie-*.wav")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(wav)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	command := w.Command
+	if command == "" {
+		command = DefaultWhisperCommand
+	}
+	// no timestamps, no progress
+	args := []string{"-m", w.Model, "-f", f.Name(), "-nt", "-np"}
+	if w.Language != "" {
+		args = append(args, "-l", w.Language)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s: %s", command, err.Error(), lastLine(msg))
+		}
+		return "", fmt.Errorf("%s: %s", command, err.Error())
+	}
+	return strings.Join(strings.Fields(stdout.String()), " "), nil
+}
+
+// lastLine is the last line of s, where programs usually say what went wrong.
+func lastLine(s string) string {
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}